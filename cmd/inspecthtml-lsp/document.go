@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"github.com/dpb587/inspecthtml-go/inspecthtml"
+	"golang.org/x/net/html"
+)
+
+// document is the parsed version of an open document.
+type document struct {
+	uri     string
+	version int
+
+	// unit is the column unit of positions.
+	unit inspecthtml.ColumnUnit
+
+	root     *html.Node
+	metadata *inspecthtml.ParseMetadata
+}
+
+func newDocument(uri string, version int, text string, unit inspecthtml.ColumnUnit) (*document, error) {
+	root, metadata, err := inspecthtml.NewParser(
+		strings.NewReader(text),
+		inspecthtml.ParserConfig{}.SetRetainSource(true).SetTokenizerDiagnostics(true).SetTreeDiagnostics(true),
+	).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse: %v", err)
+	}
+
+	return &document{
+		uri:      uri,
+		version:  version,
+		unit:     unit,
+		root:     root,
+		metadata: metadata,
+	}, nil
+}
+
+func (d *document) position(o cursorio.TextOffset) lspPosition {
+	if v, ok := d.metadata.ConvertOffset(o, d.unit); ok {
+		o = v
+	}
+
+	return lspPosition{
+		Line:      o.LineColumn[0],
+		Character: o.LineColumn[1],
+	}
+}
+
+func (d *document) rangeOf(r cursorio.TextOffsetRange) lspRange {
+	return lspRange{
+		Start: d.position(r.From),
+		End:   d.position(r.Until),
+	}
+}
+
+// lookup returns the deepest node at the position, along with the offset of the position.
+func (d *document) lookup(p lspPosition) (inspecthtml.OffsetIndexMatch, cursorio.TextOffset, bool) {
+	o, ok := d.metadata.ConvertLineColumn(cursorio.TextLineColumn{p.Line, p.Character}, d.unit)
+	if !ok {
+		return inspecthtml.OffsetIndexMatch{}, cursorio.TextOffset{}, false
+	}
+
+	match, ok := d.metadata.GetOffsetIndex().LookupOffset(o)
+
+	return match, o, ok
+}
+
+// getSourceMetadata returns the metadata of a node which has its own source. Clones only share the source of their
+// original.
+func (d *document) getSourceMetadata(n *html.Node) (*inspecthtml.NodeMetadata, bool) {
+	v, ok := d.metadata.GetNodeMetadata(n)
+	if !ok || d.metadata.GetNodeProvenance(n) == inspecthtml.NodeProvenanceCloned {
+		return nil, false
+	}
+
+	return v, true
+}
+
+func (d *document) documentSymbols() []lspDocumentSymbol {
+	return d.appendDocumentSymbols([]lspDocumentSymbol{}, d.root)
+}
+
+// appendDocumentSymbols appends the symbols of the child elements of a node. The children of elements without their
+// own source (e.g. implied html or body) are appended in their place.
+func (d *document) appendDocumentSymbols(symbols []lspDocumentSymbol, n *html.Node) []lspDocumentSymbol {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+
+		v, ok := d.getSourceMetadata(c)
+		if !ok {
+			symbols = d.appendDocumentSymbols(symbols, c)
+
+			continue
+		}
+
+		selection := v.TokenOffsets
+		if v.TagNameOffsets != nil {
+			selection = *v.TagNameOffsets
+		}
+
+		symbol := lspDocumentSymbol{
+			Name:           describeElement(c),
+			Kind:           lspSymbolKindField,
+			Range:          d.rangeOf(v.GetOuterOffsets()),
+			SelectionRange: d.rangeOf(selection),
+			Children:       d.appendDocumentSymbols(nil, c),
+		}
+
+		if provenance := d.metadata.GetNodeProvenance(c); provenance != inspecthtml.NodeProvenanceExplicit {
+			symbol.Detail = provenance.String()
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols
+}
+
+// foldingRanges returns the elements and comments which span multiple lines. The line of an end tag in source is not
+// folded, so it remains visible.
+func (d *document) foldingRanges() []lspFoldingRange {
+	ranges := []lspFoldingRange{}
+
+	visitNode(d.root, func(n *html.Node) {
+		v, ok := d.getSourceMetadata(n)
+		if !ok {
+			return
+		}
+
+		var foldingRange lspFoldingRange
+
+		switch n.Type {
+		case html.ElementNode:
+			outer := v.GetOuterOffsets()
+
+			foldingRange.StartLine = outer.From.LineColumn[0]
+			foldingRange.EndLine = outer.Until.LineColumn[0]
+
+			if v.ImpliedEndTagReason == 0 && v.EndTagTokenOffsets != nil && v.EndTagTokenOffsets.Until.Byte > v.EndTagTokenOffsets.From.Byte {
+				foldingRange.EndLine = v.EndTagTokenOffsets.From.LineColumn[0] - 1
+			} else if outer.Until.LineColumn[1] == 0 {
+				// implicitly closed at the start of a line
+				foldingRange.EndLine--
+			}
+		case html.CommentNode:
+			foldingRange.StartLine = v.TokenOffsets.From.LineColumn[0]
+			foldingRange.EndLine = v.TokenOffsets.Until.LineColumn[0]
+			foldingRange.Kind = lspFoldingRangeKindComment
+		default:
+			return
+		}
+
+		if foldingRange.EndLine > foldingRange.StartLine {
+			ranges = append(ranges, foldingRange)
+		}
+	})
+
+	return ranges
+}
+
+// matchOffsets returns the ranges of the match, from the most specific part at the offset (e.g. an attribute value)
+// through the start tag and outer range of its node.
+func matchOffsets(match inspecthtml.OffsetIndexMatch, o cursorio.TextOffset) []cursorio.TextOffsetRange {
+	var ranges []cursorio.TextOffsetRange
+
+	v := match.NodeMetadata
+
+	switch match.Kind {
+	case inspecthtml.OffsetIndexMatchTagName:
+		ranges = append(ranges, *v.TagNameOffsets)
+	case inspecthtml.OffsetIndexMatchEndTag:
+		ranges = append(ranges, *v.EndTagTokenOffsets)
+	case inspecthtml.OffsetIndexMatchAttrKey, inspecthtml.OffsetIndexMatchAttrValue:
+		attr := v.TagAttr[match.AttrIndex]
+
+		if match.Kind == inspecthtml.OffsetIndexMatchAttrKey {
+			ranges = append(ranges, attr.KeyOffsets)
+		} else {
+			if attr.ValueSegment != nil {
+				ranges = append(ranges, attr.ValueSegment.Offsets)
+			}
+
+			ranges = append(ranges, *attr.ValueOffsets)
+		}
+
+		attrOffsets := attr.KeyOffsets
+		if attr.ValueOffsets != nil {
+			attrOffsets.Until = attr.ValueOffsets.Until
+		}
+
+		ranges = append(ranges, attrOffsets)
+	}
+
+	if o.Byte >= v.TokenOffsets.From.Byte && o.Byte < v.TokenOffsets.Until.Byte {
+		ranges = append(ranges, v.TokenOffsets)
+	}
+
+	return append(ranges, v.GetOuterOffsets())
+}
+
+func (d *document) selectionRanges(positions []lspPosition) []lspSelectionRange {
+	selectionRanges := make([]lspSelectionRange, len(positions))
+
+	for i, p := range positions {
+		selectionRanges[i] = d.selectionRange(p)
+	}
+
+	return selectionRanges
+}
+
+// selectionRange returns the ranges of the match at the position, and then of each ancestor. Since nodes may have been
+// moved by the DOM Processor, any range which does not contain the previous one is skipped.
+func (d *document) selectionRange(p lspPosition) lspSelectionRange {
+	match, o, ok := d.lookup(p)
+	if !ok {
+		return lspSelectionRange{
+			Range: lspRange{
+				Start: p,
+				End:   p,
+			},
+		}
+	}
+
+	ranges := matchOffsets(match, o)
+
+	for n := match.Node.Parent; n != nil; n = n.Parent {
+		if v, ok := d.getSourceMetadata(n); ok {
+			ranges = append(ranges, v.GetOuterOffsets())
+		}
+	}
+
+	var selection *lspSelectionRange
+	var parent cursorio.TextOffsetRange
+
+	for i := len(ranges) - 1; i >= 0; i-- {
+		r := ranges[i]
+
+		if selection != nil && (r == parent || r.From.Byte < parent.From.Byte || r.Until.Byte > parent.Until.Byte) {
+			continue
+		}
+
+		selection = &lspSelectionRange{
+			Range:  d.rangeOf(r),
+			Parent: selection,
+		}
+		parent = r
+	}
+
+	return *selection
+}
+
+// hover describes the path of elements to the node at the position.
+func (d *document) hover(p lspPosition) *lspHover {
+	match, o, ok := d.lookup(p)
+	if !ok {
+		return nil
+	}
+
+	var path []string
+
+	for n := match.Node; n != nil; n = n.Parent {
+		if n.Type == html.ElementNode {
+			path = append(path, describeElement(n))
+		}
+	}
+
+	slices.Reverse(path)
+
+	value := fmt.Sprintf("`%s`", strings.Join(path, " > "))
+
+	if provenance := d.metadata.GetNodeProvenance(match.Node); provenance != inspecthtml.NodeProvenanceExplicit {
+		value += fmt.Sprintf("\n\nProvenance: %s", provenance)
+	}
+
+	hoverRange := d.rangeOf(matchOffsets(match, o)[0])
+
+	return &lspHover{
+		Contents: lspMarkupContent{
+			Kind:  "markdown",
+			Value: value,
+		},
+		Range: &hoverRange,
+	}
+}
+
+// definition returns the start tag of the elements whose id is referenced by the attribute value at the position, such
+// as `href="#id"`, `for`, or any of `aria-labelledby`.
+func (d *document) definition(p lspPosition) []lspLocation {
+	match, o, ok := d.lookup(p)
+	if !ok || match.Kind != inspecthtml.OffsetIndexMatchAttrValue || match.AttrIndex >= len(match.Node.Attr) {
+		return nil
+	}
+
+	attr := match.Node.Attr[match.AttrIndex]
+	if attr.Namespace != "" {
+		return nil
+	}
+
+	var id string
+
+	switch attr.Key {
+	case "href":
+		id, _ = strings.CutPrefix(attr.Val, "#")
+		if id == attr.Val {
+			// not a fragment
+			return nil
+		}
+	case "for":
+		id = attr.Val
+	case "aria-labelledby":
+		i, ok := match.NodeMetadata.TagAttr[match.AttrIndex].GetValueDataIndex(o)
+		if !ok {
+			return nil
+		}
+
+		id = fieldAt(attr.Val, i)
+	}
+
+	if id == "" {
+		return nil
+	}
+
+	var locations []lspLocation
+
+	visitNode(d.root, func(n *html.Node) {
+		if n.Type != html.ElementNode || getAttr(n, "id") != id {
+			return
+		}
+
+		if v, ok := d.getSourceMetadata(n); ok {
+			locations = append(locations, lspLocation{
+				URI:   d.uri,
+				Range: d.rangeOf(v.TokenOffsets),
+			})
+		}
+	})
+
+	return locations
+}
+
+func (d *document) diagnostics() []lspDiagnostic {
+	diagnostics := make([]lspDiagnostic, 0, len(d.metadata.GetDiagnostics()))
+
+	for _, v := range d.metadata.GetDiagnostics() {
+		diagnostic := lspDiagnostic{
+			Range:   d.rangeOf(v.Offsets),
+			Code:    string(v.Code),
+			Source:  "inspecthtml",
+			Message: v.Message,
+		}
+
+		switch v.Severity {
+		case inspecthtml.DiagnosticSeverityError:
+			diagnostic.Severity = lspDiagnosticSeverityError
+		case inspecthtml.DiagnosticSeverityWarning:
+			diagnostic.Severity = lspDiagnosticSeverityWarning
+		case inspecthtml.DiagnosticSeverityInfo:
+			diagnostic.Severity = lspDiagnosticSeverityInformation
+		}
+
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	return diagnostics
+}
+
+//
+
+func visitNode(n *html.Node, f func(n *html.Node)) {
+	f(n)
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		visitNode(c, f)
+	}
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+// describeElement returns the tag name of an element along with its id and classes, similar to a CSS selector (e.g.
+// `div#main.a.b`).
+func describeElement(n *html.Node) string {
+	s := n.Data
+
+	if id := getAttr(n, "id"); id != "" {
+		s += "#" + id
+	}
+
+	for _, class := range strings.Fields(getAttr(n, "class")) {
+		s += "." + class
+	}
+
+	return s
+}
+
+// fieldAt returns the whitespace-separated field of s which contains the byte index, including at its end.
+func fieldAt(s string, i int) string {
+	i = min(max(i, 0), len(s))
+
+	from := strings.LastIndexAny(s[:i], " \t\n\f\r") + 1
+
+	until := strings.IndexAny(s[i:], " \t\n\f\r")
+	if until < 0 {
+		until = len(s)
+	} else {
+		until += i
+	}
+
+	return s[from:until]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Error codes of JSON-RPC and LSP.
+const (
+	jsonrpcCodeParseError           = -32700
+	jsonrpcCodeInvalidRequest       = -32600
+	jsonrpcCodeMethodNotFound       = -32601
+	jsonrpcCodeInvalidParams        = -32602
+	jsonrpcCodeInternalError        = -32603
+	jsonrpcCodeServerNotInitialized = -32002
+)
+
+// jsonrpcMessage is a request, response, or notification. Requests and notifications have a method, and only requests
+// and responses have an ID.
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+// validRequest returns true if the message is a request or notification with a valid ID (i.e. a string, number, or
+// null) if it has one.
+func (m *jsonrpcMessage) validRequest() bool {
+	return m.JSONRPC == "2.0" && m.Method != "" && m.validID()
+}
+
+func (m *jsonrpcMessage) validID() bool {
+	if len(m.ID) == 0 || string(m.ID) == "null" {
+		return true
+	}
+
+	switch c := m.ID[0]; {
+	case c == '"', c == '-', c >= '0' && c <= '9':
+		return true
+	}
+
+	return false
+}
+
+// jsonrpcResponse is a response, which always has an ID. It is null if the ID of the request could not be determined
+// (e.g. a parse error).
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonrpcError) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (code %d)", e.Message, e.Code)
+}
+
+// jsonrpcConn reads and writes messages with the base protocol of LSP, where each message is prefixed by headers.
+type jsonrpcConn struct {
+	r *textproto.Reader
+
+	w   io.Writer
+	wMu sync.Mutex
+}
+
+func newJSONRPCConn(r io.Reader, w io.Writer) *jsonrpcConn {
+	return &jsonrpcConn{
+		r: textproto.NewReader(bufio.NewReader(r)),
+		w: w,
+	}
+}
+
+// read returns the next message. It returns io.EOF if the input ended between messages.
+func (c *jsonrpcConn) read() (*jsonrpcMessage, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+
+	contentLength, err := strconv.ParseInt(strings.TrimSpace(header.Get("Content-Length")), 10, 64)
+	if err != nil || contentLength < 0 {
+		return nil, fmt.Errorf("read header: invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+
+	buf := make([]byte, contentLength)
+
+	_, err = io.ReadFull(c.r.R, buf)
+	if err != nil {
+		return nil, fmt.Errorf("read content: %v", err)
+	}
+
+	m := &jsonrpcMessage{}
+
+	err = json.Unmarshal(buf, m)
+	if err != nil {
+		code := jsonrpcCodeParseError
+		if json.Valid(buf) {
+			// e.g. a method which is not a string
+			code = jsonrpcCodeInvalidRequest
+		}
+
+		return nil, &jsonrpcError{
+			Code:    code,
+			Message: err.Error(),
+		}
+	}
+
+	return m, nil
+}
+
+func (c *jsonrpcConn) write(m *jsonrpcMessage) error {
+	m.JSONRPC = "2.0"
+
+	return c.writeContent(m)
+}
+
+func (c *jsonrpcConn) writeContent(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+	if err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+
+	return nil
+}
+
+// respond writes the response of a request, which is the result unless err is not nil. The ID is null if it is nil.
+func (c *jsonrpcConn) respond(id json.RawMessage, result any, err error) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	m := &jsonrpcResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+	}
+
+	if err == nil {
+		m.Result, err = json.Marshal(result)
+	}
+
+	if err != nil {
+		rpcErr, ok := err.(*jsonrpcError)
+		if !ok {
+			rpcErr = &jsonrpcError{
+				Code:    jsonrpcCodeInternalError,
+				Message: err.Error(),
+			}
+		}
+
+		m.Result = nil
+		m.Error = rpcErr
+	}
+
+	return c.writeContent(m)
+}
+
+func (c *jsonrpcConn) notify(method string, params any) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	return c.write(&jsonrpcMessage{
+		Method: method,
+		Params: buf,
+	})
+}
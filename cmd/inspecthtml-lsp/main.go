@@ -0,0 +1,17 @@
+// Command inspecthtml-lsp is a Language Server Protocol server for HTML files which communicates over stdio. It
+// supports document symbols, folding ranges, selection ranges, hover, go-to-definition of id references, and publishes
+// the diagnostics of parsing.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := newServer(os.Stdin, os.Stdout).serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+
+		os.Exit(1)
+	}
+}
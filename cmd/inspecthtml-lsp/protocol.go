@@ -0,0 +1,150 @@
+package main
+
+// The subset of the Language Server Protocol which is used by the server.
+//
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/
+
+type lspPosition struct {
+	Line      int64 `json:"line"`
+	Character int64 `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// lspTextDocumentParams is the common field of any params which refer to a document.
+type lspTextDocumentParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Position     lspPosition               `json:"position"`
+}
+
+//
+
+type lspInitializeParams struct {
+	Capabilities struct {
+		General struct {
+			PositionEncodings []string `json:"positionEncodings"`
+		} `json:"general"`
+	} `json:"capabilities"`
+}
+
+type lspInitializeResult struct {
+	Capabilities lspServerCapabilities `json:"capabilities"`
+	ServerInfo   lspServerInfo         `json:"serverInfo"`
+}
+
+type lspServerCapabilities struct {
+	PositionEncoding       string `json:"positionEncoding"`
+	TextDocumentSync       int    `json:"textDocumentSync"`
+	DocumentSymbolProvider bool   `json:"documentSymbolProvider"`
+	FoldingRangeProvider   bool   `json:"foldingRangeProvider"`
+	SelectionRangeProvider bool   `json:"selectionRangeProvider"`
+	HoverProvider          bool   `json:"hoverProvider"`
+	DefinitionProvider     bool   `json:"definitionProvider"`
+}
+
+type lspServerInfo struct {
+	Name string `json:"name"`
+}
+
+const lspTextDocumentSyncKindFull = 1
+
+//
+
+type lspDidOpenTextDocumentParams struct {
+	TextDocument struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+		Text    string `json:"text"`
+	} `json:"textDocument"`
+}
+
+type lspDidChangeTextDocumentParams struct {
+	TextDocument struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+	} `json:"textDocument"`
+
+	// ContentChanges are always the full text, since only full synchronization is supported.
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+//
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+const lspSymbolKindField = 8
+
+type lspFoldingRange struct {
+	StartLine int64  `json:"startLine"`
+	EndLine   int64  `json:"endLine"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+const lspFoldingRangeKindComment = "comment"
+
+type lspSelectionRangeParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Positions    []lspPosition             `json:"positions"`
+}
+
+type lspSelectionRange struct {
+	Range  lspRange           `json:"range"`
+	Parent *lspSelectionRange `json:"parent,omitempty"`
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+	Range    *lspRange        `json:"range,omitempty"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+//
+
+type lspPublishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Version     *int            `json:"version,omitempty"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity,omitempty"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Message  string   `json:"message"`
+}
+
+const (
+	lspDiagnosticSeverityError       = 1
+	lspDiagnosticSeverityWarning     = 2
+	lspDiagnosticSeverityInformation = 3
+)
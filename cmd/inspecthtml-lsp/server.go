@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/dpb587/inspecthtml-go/inspecthtml"
+)
+
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+type positionEncoding struct {
+	name string
+	unit inspecthtml.ColumnUnit
+}
+
+// positionEncodings are the supported encodings of LSP positions, in order of preference.
+var positionEncodings = []positionEncoding{
+	{"utf-16", inspecthtml.ColumnUnitUTF16},
+	{"utf-8", inspecthtml.ColumnUnitByte},
+	{"utf-32", inspecthtml.ColumnUnitRune},
+}
+
+// server handles the messages of a single client, in order.
+type server struct {
+	conn *jsonrpcConn
+
+	initialized bool
+	shutdown    bool
+
+	// unit is the negotiated column unit of positions.
+	unit      inspecthtml.ColumnUnit
+	documents map[string]*document
+}
+
+func newServer(r io.Reader, w io.Writer) *server {
+	return &server{
+		conn:      newJSONRPCConn(r, w),
+		unit:      inspecthtml.ColumnUnitUTF16,
+		documents: map[string]*document{},
+	}
+}
+
+// serve handles messages until the exit notification. It returns an error if reading or writing failed, or if exit was
+// not preceded by a shutdown request.
+func (s *server) serve() error {
+	for {
+		m, err := s.conn.read()
+		if err != nil {
+			var rpcErr *jsonrpcError
+			if errors.As(err, &rpcErr) {
+				if err := s.conn.respond(nil, nil, rpcErr); err != nil {
+					return err
+				}
+
+				continue
+			} else if err == io.EOF {
+				return errExitWithoutShutdown
+			}
+
+			return err
+		}
+
+		if !m.validRequest() {
+			id := m.ID
+			if !m.validID() {
+				id = nil
+			}
+
+			err := s.conn.respond(id, nil, &jsonrpcError{
+				Code:    jsonrpcCodeInvalidRequest,
+				Message: "invalid request",
+			})
+			if err != nil {
+				return err
+			}
+
+			continue
+		} else if m.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+
+			return nil
+		} else if m.ID == nil {
+			// errors of notifications cannot be reported to the client
+			_ = s.handleNotification(m)
+
+			continue
+		}
+
+		result, err := s.handleRequest(m)
+
+		err = s.conn.respond(m.ID, result, err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handleRequest(m *jsonrpcMessage) (any, error) {
+	if m.Method == "initialize" {
+		return handleParams(m.Params, s.initialize)
+	} else if !s.initialized {
+		return nil, &jsonrpcError{
+			Code:    jsonrpcCodeServerNotInitialized,
+			Message: "server not initialized",
+		}
+	} else if s.shutdown {
+		return nil, &jsonrpcError{
+			Code:    jsonrpcCodeInvalidRequest,
+			Message: "server is shutting down",
+		}
+	}
+
+	switch m.Method {
+	case "shutdown":
+		s.shutdown = true
+
+		return nil, nil
+	case "textDocument/documentSymbol":
+		return handleDocumentParams(s, m.Params, func(d *document, params lspTextDocumentParams) (any, error) {
+			return d.documentSymbols(), nil
+		})
+	case "textDocument/foldingRange":
+		return handleDocumentParams(s, m.Params, func(d *document, params lspTextDocumentParams) (any, error) {
+			return d.foldingRanges(), nil
+		})
+	case "textDocument/selectionRange":
+		return handleDocumentParams(s, m.Params, func(d *document, params lspSelectionRangeParams) (any, error) {
+			return d.selectionRanges(params.Positions), nil
+		})
+	case "textDocument/hover":
+		return handleDocumentParams(s, m.Params, func(d *document, params lspTextDocumentPositionParams) (any, error) {
+			return d.hover(params.Position), nil
+		})
+	case "textDocument/definition":
+		return handleDocumentParams(s, m.Params, func(d *document, params lspTextDocumentPositionParams) (any, error) {
+			return d.definition(params.Position), nil
+		})
+	}
+
+	return nil, &jsonrpcError{
+		Code:    jsonrpcCodeMethodNotFound,
+		Message: fmt.Sprintf("method not found: %s", m.Method),
+	}
+}
+
+func (s *server) handleNotification(m *jsonrpcMessage) error {
+	if !s.initialized {
+		return nil
+	}
+
+	switch m.Method {
+	case "textDocument/didOpen":
+		_, err := handleParams(m.Params, func(params lspDidOpenTextDocumentParams) (any, error) {
+			return nil, s.openDocument(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text)
+		})
+
+		return err
+	case "textDocument/didChange":
+		_, err := handleParams(m.Params, func(params lspDidChangeTextDocumentParams) (any, error) {
+			if len(params.ContentChanges) == 0 {
+				return nil, nil
+			}
+
+			return nil, s.openDocument(params.TextDocument.URI, params.TextDocument.Version, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		})
+
+		return err
+	case "textDocument/didClose":
+		_, err := handleParams(m.Params, func(params lspTextDocumentParams) (any, error) {
+			delete(s.documents, params.TextDocument.URI)
+
+			return nil, s.conn.notify("textDocument/publishDiagnostics", lspPublishDiagnosticsParams{
+				URI:         params.TextDocument.URI,
+				Diagnostics: []lspDiagnostic{},
+			})
+		})
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *server) initialize(params lspInitializeParams) (any, error) {
+	encoding := positionEncodings[0]
+
+	for _, clientEncoding := range params.Capabilities.General.PositionEncodings {
+		if idx := slices.IndexFunc(positionEncodings, func(v positionEncoding) bool {
+			return v.name == clientEncoding
+		}); idx >= 0 {
+			encoding = positionEncodings[idx]
+
+			break
+		}
+	}
+
+	s.initialized = true
+	s.unit = encoding.unit
+
+	return lspInitializeResult{
+		Capabilities: lspServerCapabilities{
+			PositionEncoding:       encoding.name,
+			TextDocumentSync:       lspTextDocumentSyncKindFull,
+			DocumentSymbolProvider: true,
+			FoldingRangeProvider:   true,
+			SelectionRangeProvider: true,
+			HoverProvider:          true,
+			DefinitionProvider:     true,
+		},
+		ServerInfo: lspServerInfo{
+			Name: "inspecthtml-lsp",
+		},
+	}, nil
+}
+
+// openDocument parses the text of a document, replacing any previous version, and publishes its diagnostics.
+func (s *server) openDocument(uri string, version int, text string) error {
+	d, err := newDocument(uri, version, text, s.unit)
+	if err != nil {
+		return err
+	}
+
+	s.documents[uri] = d
+
+	return s.conn.notify("textDocument/publishDiagnostics", lspPublishDiagnosticsParams{
+		URI:         uri,
+		Version:     &d.version,
+		Diagnostics: d.diagnostics(),
+	})
+}
+
+func handleParams[P any](raw json.RawMessage, f func(params P) (any, error)) (any, error) {
+	var params P
+
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &jsonrpcError{
+				Code:    jsonrpcCodeInvalidParams,
+				Message: err.Error(),
+			}
+		}
+	}
+
+	return f(params)
+}
+
+// handleDocumentParams is the same as handleParams, but for the open document of the params. The result is null for
+// unknown documents.
+func handleDocumentParams[P any](s *server, raw json.RawMessage, f func(d *document, params P) (any, error)) (any, error) {
+	return handleParams(raw, func(params lspTextDocumentParams) (any, error) {
+		d, ok := s.documents[params.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+
+		return handleParams(raw, func(params P) (any, error) {
+			return f(d, params)
+		})
+	})
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testClient is an in-process client of a server, connected by pipes.
+type testClient struct {
+	t    *testing.T
+	conn *jsonrpcConn
+
+	// messages are read in the background, since the server blocks while writing
+	messages chan *jsonrpcMessage
+	served   chan error
+
+	nextID        int
+	notifications []*jsonrpcMessage
+}
+
+func newTestClient(t *testing.T) *testClient {
+	serverR, clientW := io.Pipe()
+	clientR, serverW := io.Pipe()
+
+	c := &testClient{
+		t:        t,
+		conn:     newJSONRPCConn(clientR, clientW),
+		messages: make(chan *jsonrpcMessage, 64),
+		served:   make(chan error, 1),
+	}
+
+	go func() {
+		c.served <- newServer(serverR, serverW).serve()
+
+		serverW.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+
+		for {
+			m, err := c.conn.read()
+			if err != nil {
+				return
+			}
+
+			c.messages <- m
+		}
+	}()
+
+	t.Cleanup(func() {
+		clientW.Close()
+	})
+
+	return c
+}
+
+// call sends a request and returns its response. Any notifications received meanwhile are recorded.
+func (c *testClient) call(method string, params any, result any) *jsonrpcError {
+	c.nextID++
+
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+
+	buf, err := json.Marshal(params)
+	if err != nil {
+		c.t.Fatalf("marshal: %v", err)
+	}
+
+	err = c.conn.write(&jsonrpcMessage{
+		ID:     id,
+		Method: method,
+		Params: buf,
+	})
+	if err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+
+	for m := range c.messages {
+		if m.Method != "" {
+			c.notifications = append(c.notifications, m)
+
+			continue
+		} else if string(m.ID) != string(id) {
+			c.t.Fatalf("response: expected id %s, got %s", id, m.ID)
+		} else if m.Error != nil {
+			return m.Error
+		}
+
+		if result != nil {
+			if err := json.Unmarshal(m.Result, result); err != nil {
+				c.t.Fatalf("unmarshal: %v", err)
+			}
+		}
+
+		return nil
+	}
+
+	c.t.Fatalf("response: expected id %s, got end of input", id)
+
+	return nil
+}
+
+func (c *testClient) notify(method string, params any) {
+	if err := c.conn.notify(method, params); err != nil {
+		c.t.Fatalf("notify: %v", err)
+	}
+}
+
+// initialize initializes the server and opens a document.
+func (c *testClient) initialize(text string) {
+	err := c.call("initialize", map[string]any{}, nil)
+	if err != nil {
+		c.t.Fatalf("initialize: %v", err)
+	}
+
+	c.notify("initialized", map[string]any{})
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{
+			"uri":        "file:///test.html",
+			"languageId": "html",
+			"version":    1,
+			"text":       text,
+		},
+	})
+}
+
+func describeRange(r lspRange) string {
+	return fmt.Sprintf("%d:%d-%d:%d", r.Start.Line, r.Start.Character, r.End.Line, r.End.Character)
+}
+
+func positionParams(line, character int64) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": "file:///test.html"},
+		"position":     lspPosition{Line: line, Character: character},
+	}
+}
+
+func TestServerLifecycle(t *testing.T) {
+	c := newTestClient(t)
+
+	if err := c.call("textDocument/hover", positionParams(0, 0), nil); err == nil {
+		t.Fatalf("expected error before initialize")
+	} else if _a, _e := err.Code, jsonrpcCodeServerNotInitialized; _a != _e {
+		t.Errorf("code: expected %v, got %v", _e, _a)
+	}
+
+	var result lspInitializeResult
+
+	if err := c.call("initialize", map[string]any{
+		"capabilities": map[string]any{
+			"general": map[string]any{
+				"positionEncodings": []string{"utf-32", "utf-16"},
+			},
+		},
+	}, &result); err != nil {
+		t.Fatalf("initialize: %v", err)
+	} else if _a, _e := result.Capabilities.PositionEncoding, "utf-32"; _a != _e {
+		t.Errorf("position encoding: expected %v, got %v", _e, _a)
+	}
+
+	if err := c.call("textDocument/unknown", map[string]any{}, nil); err == nil {
+		t.Fatalf("expected error for unknown method")
+	} else if _a, _e := err.Code, jsonrpcCodeMethodNotFound; _a != _e {
+		t.Errorf("code: expected %v, got %v", _e, _a)
+	}
+
+	var hover *lspHover
+
+	if err := c.call("textDocument/hover", positionParams(0, 0), &hover); err != nil {
+		t.Fatalf("hover: %v", err)
+	} else if hover != nil {
+		t.Errorf("expected no hover for unknown document")
+	}
+
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	c.notify("exit", nil)
+
+	if err := <-c.served; err != nil {
+		t.Errorf("serve: expected no error, got %v", err)
+	}
+}
+
+func TestServerInvalidMessages(t *testing.T) {
+	c := newTestClient(t)
+
+	for _, tc := range []struct {
+		content  string
+		expected string
+		code     int
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":`, "null", jsonrpcCodeParseError},
+		{`{"jsonrpc":"2.0","id":2,"method":1}`, "null", jsonrpcCodeInvalidRequest},
+		{`{"jsonrpc":"2.0","id":{},"method":"shutdown"}`, "null", jsonrpcCodeInvalidRequest},
+		{`{"jsonrpc":"2.0"}`, "null", jsonrpcCodeInvalidRequest},
+		{`{"jsonrpc":"1.0","id":"a","method":"shutdown"}`, `"a"`, jsonrpcCodeInvalidRequest},
+	} {
+		t.Run(tc.content, func(t *testing.T) {
+			_, err := fmt.Fprintf(c.conn.w, "Content-Length: %d\r\n\r\n%s", len(tc.content), tc.content)
+			if err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			m, ok := <-c.messages
+			if !ok {
+				t.Fatalf("response: expected message, got end of input")
+			} else if _a, _e := string(m.ID), tc.expected; _a != _e {
+				t.Errorf("id: expected %s, got %s", _e, _a)
+			} else if m.Error == nil {
+				t.Errorf("expected error")
+			} else if _a, _e := m.Error.Code, tc.code; _a != _e {
+				t.Errorf("code: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+
+	// still serving
+	if err := c.call("initialize", map[string]any{}, nil); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	c := newTestClient(t)
+	c.initialize("<p>a</b>")
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": "file:///test.html", "version": 2},
+		"contentChanges": []map[string]any{{"text": "<p>a</p>"}},
+	})
+	c.notify("textDocument/didClose", map[string]any{
+		"textDocument": map[string]any{"uri": "file:///test.html"},
+	})
+
+	// wait for the notifications to be handled
+	if err := c.call("shutdown", nil, nil); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	var actual []string
+
+	for _, m := range c.notifications {
+		var params lspPublishDiagnosticsParams
+
+		if _a, _e := m.Method, "textDocument/publishDiagnostics"; _a != _e {
+			t.Fatalf("method: expected %v, got %v", _e, _a)
+		} else if err := json.Unmarshal(m.Params, &params); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+
+		var diagnostics []string
+
+		for _, diagnostic := range params.Diagnostics {
+			diagnostics = append(diagnostics, fmt.Sprintf("%s %s %d %s", describeRange(diagnostic.Range), diagnostic.Code, diagnostic.Severity, diagnostic.Source))
+		}
+
+		version := "-"
+		if params.Version != nil {
+			version = strconv.Itoa(*params.Version)
+		}
+
+		actual = append(actual, fmt.Sprintf("%s@%s [%s]", params.URI, version, strings.Join(diagnostics, ", ")))
+	}
+
+	if _a, _e := strings.Join(actual, "\n"), strings.Join([]string{
+		`file:///test.html@1 [0:4-0:8 end-tag-unmatched 2 inspecthtml]`,
+		`file:///test.html@2 []`,
+		`file:///test.html@- []`,
+	}, "\n"); _a != _e {
+		t.Errorf("notifications: expected\n%s\ngot\n%s", _e, _a)
+	}
+}
+
+func TestServerDocumentSymbol(t *testing.T) {
+	c := newTestClient(t)
+	c.initialize("<!DOCTYPE html>\n<div id=main class=\"a b\">\n  <p>x<b>y</b>\n  <ul><li>1<li>2</ul>\n</div>")
+
+	var result []lspDocumentSymbol
+
+	if err := c.call("textDocument/documentSymbol", map[string]any{
+		"textDocument": map[string]any{"uri": "file:///test.html"},
+	}, &result); err != nil {
+		t.Fatalf("documentSymbol: %v", err)
+	}
+
+	var actual []string
+
+	var visitSymbols func(symbols []lspDocumentSymbol, indent string)
+	visitSymbols = func(symbols []lspDocumentSymbol, indent string) {
+		for _, symbol := range symbols {
+			actual = append(actual, fmt.Sprintf("%s%s %s %s %s", indent, symbol.Name, describeRange(symbol.Range), describeRange(symbol.SelectionRange), symbol.Detail))
+
+			visitSymbols(symbol.Children, indent+"  ")
+		}
+	}
+
+	visitSymbols(result, "")
+
+	if _a, _e := strings.Join(actual, "\n"), strings.Join([]string{
+		`div#main.a.b 1:0-4:6 1:1-1:4 `,
+		`  p 2:2-3:2 2:3-2:4 `,
+		`    b 2:6-2:14 2:7-2:8 `,
+		`  ul 3:2-3:21 3:3-3:5 `,
+		`    li 3:6-3:11 3:7-3:9 `,
+		`    li 3:11-3:16 3:12-3:14 `,
+	}, "\n"); _a != _e {
+		t.Errorf("symbols: expected\n%s\ngot\n%s", _e, _a)
+	}
+}
+
+func TestServerFoldingRange(t *testing.T) {
+	c := newTestClient(t)
+	c.initialize("<div>\n  <p>\n    x\n</div>\n<!--\n  c\n-->\n<ul>\n  <li>1</li>\n</ul>")
+
+	var result []lspFoldingRange
+
+	if err := c.call("textDocument/foldingRange", map[string]any{
+		"textDocument": map[string]any{"uri": "file:///test.html"},
+	}, &result); err != nil {
+		t.Fatalf("foldingRange: %v", err)
+	}
+
+	var actual []string
+
+	for _, foldingRange := range result {
+		actual = append(actual, fmt.Sprintf("%d-%d %s", foldingRange.StartLine, foldingRange.EndLine, foldingRange.Kind))
+	}
+
+	if _a, _e := strings.Join(actual, "\n"), strings.Join([]string{
+		`0-2 `,
+		`1-2 `,
+		`4-6 comment`,
+		`7-8 `,
+	}, "\n"); _a != _e {
+		t.Errorf("folding ranges: expected\n%s\ngot\n%s", _e, _a)
+	}
+}
+
+func TestServerSelectionRange(t *testing.T) {
+	c := newTestClient(t)
+	c.initialize("<div>\n  <p class=\"a\">text</p>\n</div>")
+
+	for _, tc := range []struct {
+		name     string
+		position lspPosition
+		expected []string
+	}{
+		{
+			name:     "tag name",
+			position: lspPosition{Line: 1, Character: 3},
+			expected: []string{"1:3-1:4", "1:2-1:15", "1:2-1:23", "0:0-2:6"},
+		},
+		{
+			name:     "attribute value",
+			position: lspPosition{Line: 1, Character: 12},
+			expected: []string{"1:12-1:13", "1:11-1:14", "1:5-1:14", "1:2-1:15", "1:2-1:23", "0:0-2:6"},
+		},
+		{
+			name:     "text",
+			position: lspPosition{Line: 1, Character: 16},
+			expected: []string{"1:15-1:19", "1:2-1:23", "0:0-2:6"},
+		},
+		{
+			name:     "end tag",
+			position: lspPosition{Line: 2, Character: 2},
+			expected: []string{"2:0-2:6", "0:0-2:6"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var result []lspSelectionRange
+
+			if err := c.call("textDocument/selectionRange", map[string]any{
+				"textDocument": map[string]any{"uri": "file:///test.html"},
+				"positions":    []lspPosition{tc.position},
+			}, &result); err != nil {
+				t.Fatalf("selectionRange: %v", err)
+			} else if _a, _e := len(result), 1; _a != _e {
+				t.Fatalf("results: expected %v, got %v", _e, _a)
+			}
+
+			var actual []string
+
+			for selection := &result[0]; selection != nil; selection = selection.Parent {
+				actual = append(actual, describeRange(selection.Range))
+			}
+
+			if _a, _e := strings.Join(actual, " "), strings.Join(tc.expected, " "); _a != _e {
+				t.Errorf("ranges: expected %s, got %s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestServerHover(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		position lspPosition
+		expected string
+	}{
+		{
+			name:  "element",
+			input: "<table id=t><tr><td>😀<b class=x>y</b></td></tr></table>",
+			// utf-16, after the surrogate pair
+			position: lspPosition{Line: 0, Character: 23},
+			expected: "0:23-0:24 `html > body > table#t > tbody > tr > td > b.x`",
+		},
+		{
+			name:     "reparented",
+			input:    "<head></head>\n<title>t</title>",
+			position: lspPosition{Line: 1, Character: 2},
+			expected: "1:1-1:6 `html > head > title`\n\nProvenance: reparented",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t)
+			c.initialize(tc.input)
+
+			var result *lspHover
+
+			if err := c.call("textDocument/hover", positionParams(tc.position.Line, tc.position.Character), &result); err != nil {
+				t.Fatalf("hover: %v", err)
+			} else if result == nil {
+				t.Fatalf("expected hover")
+			}
+
+			if _a, _e := fmt.Sprintf("%s %s", describeRange(*result.Range), result.Contents.Value), tc.expected; _a != _e {
+				t.Errorf("hover: expected %q, got %q", _e, _a)
+			}
+		})
+	}
+}
+
+func TestServerDefinition(t *testing.T) {
+	c := newTestClient(t)
+	c.initialize(strings.Join([]string{
+		`<h1 id=title>T</h1><label id="l" for="name">N</label>`,
+		`<input id=name aria-labelledby="title l">`,
+		`<a href="#name">a</a><a href="/x">b</a>`,
+	}, "\n"))
+
+	for _, tc := range []struct {
+		name     string
+		position lspPosition
+		expected []string
+	}{
+		{
+			name:     "for",
+			position: lspPosition{Line: 0, Character: 39},
+			expected: []string{"1:0-1:41"},
+		},
+		{
+			name:     "aria-labelledby",
+			position: lspPosition{Line: 1, Character: 38},
+			expected: []string{"0:19-0:44"},
+		},
+		{
+			name:     "href",
+			position: lspPosition{Line: 2, Character: 11},
+			expected: []string{"1:0-1:41"},
+		},
+		{
+			name:     "href without fragment",
+			position: lspPosition{Line: 2, Character: 31},
+		},
+		{
+			name:     "not a reference",
+			position: lspPosition{Line: 0, Character: 8},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var result []lspLocation
+
+			if err := c.call("textDocument/definition", positionParams(tc.position.Line, tc.position.Character), &result); err != nil {
+				t.Fatalf("definition: %v", err)
+			}
+
+			var actual []string
+
+			for _, location := range result {
+				actual = append(actual, describeRange(location.Range))
+			}
+
+			if _a, _e := strings.Join(actual, " "), strings.Join(tc.expected, " "); _a != _e {
+				t.Errorf("locations: expected %s, got %s", _e, _a)
+			}
+		})
+	}
+}
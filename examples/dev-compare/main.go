@@ -1,4 +1,5 @@
 // Compare the re-rendered results of html.Parse vs inspecthtml.Parse for a given input file to identify any discrepancies.
+// The syntax tree of inspecthtml is also expected to serialize to the original input.
 package main
 
 import (
@@ -36,11 +37,18 @@ func mainErr() error {
 			return fmt.Errorf("html: parse: %v", err)
 		}
 
-		inspecthtmlRoot, _, err := inspecthtml.Parse(bytes.NewReader(buf))
+		inspecthtmlRoot, inspecthtmlMetadata, err := inspecthtml.NewParser(
+			bytes.NewReader(buf),
+			inspecthtml.ParserConfig{}.SetSyntaxTree(true),
+		).Parse()
 		if err != nil {
 			return fmt.Errorf("inspecthtml: parse: %v", err)
 		}
 
+		if syntaxBytes := inspecthtmlMetadata.GetSyntaxTree().Bytes(); !bytes.Equal(syntaxBytes, buf) {
+			return fmt.Errorf("syntax tree mismatch: expected %d bytes, got %d", len(buf), len(syntaxBytes))
+		}
+
 		//
 
 		htmlRender := &bytes.Buffer{}
@@ -59,16 +59,42 @@ func dumpNode(metadata *inspecthtml.ParseMetadata, node *html.Node, indent strin
 				indent,
 				nodeMetadata.TokenOffsets.OffsetRangeString(),
 			)
+
+			if len(nodeMetadata.TextSegments) > 1 {
+				for _, segment := range nodeMetadata.TextSegments {
+					fmt.Fprintf(os.Stdout,
+						"%s// TextSegment Data=%d:%d Offsets=%s\n",
+						indent,
+						segment.DataFrom,
+						segment.DataUntil,
+						segment.Offsets.OffsetRangeString(),
+					)
+				}
+			}
 		}
 
 		fmt.Fprintf(os.Stdout, "%s%s\n", indent, node.Data)
 	case html.DoctypeNode:
 		if hasNodeMetadata {
 			fmt.Fprintf(os.Stdout,
-				"%s// DoctypeNode=%s\n",
+				"%s// DoctypeNode=%s",
 				indent,
 				nodeMetadata.TokenOffsets.OffsetRangeString(),
 			)
+
+			if nodeMetadata.DoctypeNameOffsets != nil {
+				fmt.Fprintf(os.Stdout, " NameOffsets=%s", nodeMetadata.DoctypeNameOffsets.OffsetRangeString())
+			}
+
+			if nodeMetadata.DoctypePublicIdentifierOffsets != nil {
+				fmt.Fprintf(os.Stdout, " PublicIdentifierOffsets=%s", nodeMetadata.DoctypePublicIdentifierOffsets.OffsetRangeString())
+			}
+
+			if nodeMetadata.DoctypeSystemIdentifierOffsets != nil {
+				fmt.Fprintf(os.Stdout, " SystemIdentifierOffsets=%s", nodeMetadata.DoctypeSystemIdentifierOffsets.OffsetRangeString())
+			}
+
+			fmt.Fprintf(os.Stdout, "\n")
 		}
 
 		fmt.Fprintf(os.Stdout, "%s%s\n", indent, node.Data)
@@ -89,7 +115,19 @@ func dumpNode(metadata *inspecthtml.ParseMetadata, node *html.Node, indent strin
 				fmt.Fprintf(os.Stdout, " SelfClosing")
 			}
 
+			if provenance := metadata.GetNodeProvenance(node); provenance != inspecthtml.NodeProvenanceExplicit {
+				fmt.Fprintf(os.Stdout, " Provenance=%s", provenance)
+			}
+
+			if nodeMetadata.ImpliedEndTagReason != 0 {
+				fmt.Fprintf(os.Stdout, " ImpliedEndTag=%s", nodeMetadata.ImpliedEndTagReason)
+			}
+
 			fmt.Fprintf(os.Stdout, "\n")
+
+			for _, mergedStartTag := range nodeMetadata.MergedStartTags {
+				fmt.Fprintf(os.Stdout, "%s// MergedStartTagToken=%s\n", indent, mergedStartTag.TokenOffsets.OffsetRangeString())
+			}
 		}
 
 		fmt.Fprintf(os.Stdout, "%s<%s", indent, node.Data)
@@ -98,13 +136,17 @@ func dumpNode(metadata *inspecthtml.ParseMetadata, node *html.Node, indent strin
 			for attrIdx, attr := range node.Attr {
 				fmt.Fprintf(os.Stdout, "\n%s  // Attr", indent)
 
-				if hasNodeMetadata {
+				if hasNodeMetadata && attrIdx < len(nodeMetadata.TagAttr) {
 					if attrMetadata := nodeMetadata.TagAttr[attrIdx]; attrMetadata != nil {
 						fmt.Fprintf(os.Stdout, " KeyOffsets=%s", attrMetadata.KeyOffsets.OffsetRangeString())
 
 						if attrMetadata.ValueOffsets != nil {
 							fmt.Fprintf(os.Stdout, " ValueOffsets=%s", attrMetadata.ValueOffsets.OffsetRangeString())
 						}
+
+						if attrMetadata.MergedStartTag != nil {
+							fmt.Fprintf(os.Stdout, " MergedStartTag=%s", attrMetadata.MergedStartTag.TokenOffsets.OffsetRangeString())
+						}
 					}
 				}
 
@@ -3,8 +3,7 @@ module github.com/dpb587/inspecthtml-go
 go 1.25.0
 
 require (
+	github.com/apparentlymart/go-textseg/v16 v16.0.0
 	github.com/dpb587/cursorio-go v0.0.0-20260306132056-e4faa564eb12
 	golang.org/x/net v0.51.0
 )
-
-require github.com/apparentlymart/go-textseg/v16 v16.0.0 // indirect
@@ -0,0 +1,222 @@
+package inspecthtml
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/apparentlymart/go-textseg/v16/textseg"
+	"github.com/dpb587/cursorio-go/cursorio"
+)
+
+// ColumnUnit is the unit used for counting the columns of a line.
+type ColumnUnit int
+
+const (
+	ColumnUnitByte ColumnUnit = iota + 1
+	ColumnUnitRune
+
+	// ColumnUnitUTF16 counts UTF-16 code units, such as for LSP clients or JavaScript string indices. Runes outside the
+	// Basic Multilingual Plane (e.g. most emoji) are two units.
+	ColumnUnitUTF16
+
+	// ColumnUnitGrapheme counts extended grapheme clusters, which is closer to what is displayed (e.g. a letter with a
+	// combining accent or an emoji sequence is one unit).
+	ColumnUnitGrapheme
+)
+
+func (u ColumnUnit) String() string {
+	switch u {
+	case ColumnUnitByte:
+		return "byte"
+	case ColumnUnitRune:
+		return "rune"
+	case ColumnUnitUTF16:
+		return "utf16"
+	case ColumnUnitGrapheme:
+		return "grapheme"
+	}
+
+	return "unknown"
+}
+
+// scan returns the length of the next unit in data (which must not be empty) and how many columns it is.
+func (u ColumnUnit) scan(data []byte) (int, int64) {
+	switch u {
+	case ColumnUnitRune:
+		_, size := utf8.DecodeRune(data)
+
+		return size, 1
+	case ColumnUnitUTF16:
+		r, size := utf8.DecodeRune(data)
+		if r >= 0x10000 {
+			// surrogate pair
+			return size, 2
+		}
+
+		return size, 1
+	case ColumnUnitGrapheme:
+		size, _, _ := textseg.ScanGraphemeClusters(data, true)
+		if size == 0 {
+			size = 1
+		}
+
+		return size, 1
+	}
+
+	return 1, 1
+}
+
+// columnIndexInterval is the approximate number of bytes between checkpoints of a line.
+const columnIndexInterval = 1024
+
+// columnIndex converts between byte offsets and line/columns of a unit within a source. Lines are separated by `\n`,
+// `\r\n`, or `\r`.
+type columnIndex struct {
+	unit    ColumnUnit
+	source  []byte
+	initial cursorio.TextOffset
+
+	// checkpoints are the start of every line, and unit boundaries within long lines, in order
+	checkpoints []columnCheckpoint
+}
+
+type columnCheckpoint struct {
+	index      int
+	lineColumn cursorio.TextLineColumn
+}
+
+func compareLineColumn(a, b cursorio.TextLineColumn) int {
+	if a[0] != b[0] {
+		return int(a[0] - b[0])
+	}
+
+	return int(a[1] - b[1])
+}
+
+// newColumnIndex scans the source, which starts at the initial offset. The column of the initial offset is assumed to
+// already be in the unit.
+func newColumnIndex(source []byte, initial cursorio.TextOffset, unit ColumnUnit) *columnIndex {
+	ci := &columnIndex{
+		unit:    unit,
+		source:  source,
+		initial: initial,
+		checkpoints: []columnCheckpoint{
+			{
+				lineColumn: initial.LineColumn,
+			},
+		},
+	}
+
+	lc := initial.LineColumn
+
+	for i := 0; i < len(source); {
+		if n := lineBreakLen(source[i:]); n > 0 {
+			i += n
+			lc = cursorio.TextLineColumn{lc[0] + 1, 0}
+
+			ci.checkpoints = append(ci.checkpoints, columnCheckpoint{
+				index:      i,
+				lineColumn: lc,
+			})
+
+			continue
+		}
+
+		size, columns := unit.scan(source[i:])
+		i += size
+		lc[1] += columns
+
+		if i-ci.checkpoints[len(ci.checkpoints)-1].index >= columnIndexInterval {
+			ci.checkpoints = append(ci.checkpoints, columnCheckpoint{
+				index:      i,
+				lineColumn: lc,
+			})
+		}
+	}
+
+	return ci
+}
+
+// lineBreakLen returns the length of the line break at the start of data, or 0.
+func lineBreakLen(data []byte) int {
+	switch {
+	case len(data) > 1 && data[0] == '\r' && data[1] == '\n':
+		return 2
+	case data[0] == '\r' || data[0] == '\n':
+		return 1
+	}
+
+	return 0
+}
+
+// offset returns the offset of the byte. A byte within a unit (e.g. a combining character) is at the column of the
+// unit. It returns false if the byte is not within the source.
+func (ci *columnIndex) offset(b int64) (cursorio.TextOffset, bool) {
+	until := b - ci.initial.Byte
+	if until < 0 || until > int64(len(ci.source)) {
+		return cursorio.TextOffset{}, false
+	}
+
+	cp := ci.checkpoints[sort.Search(len(ci.checkpoints), func(i int) bool {
+		return int64(ci.checkpoints[i].index) > until
+	})-1]
+
+	lc := cp.lineColumn
+
+	for i := cp.index; int64(i) < until; {
+		if n := lineBreakLen(ci.source[i:]); n > 0 {
+			// within a `\r\n`
+			i += n
+			lc = cursorio.TextLineColumn{lc[0] + 1, 0}
+
+			continue
+		}
+
+		size, columns := ci.unit.scan(ci.source[i:])
+		if int64(i+size) > until {
+			break
+		}
+
+		i += size
+		lc[1] += columns
+	}
+
+	return cursorio.TextOffset{
+		Byte:       b,
+		LineColumn: lc,
+	}, true
+}
+
+// lineColumn returns the offset of the line/column. A column within a unit (e.g. between a UTF-16 surrogate pair) is at
+// the start of the unit. It returns false if the line/column is not within the source.
+func (ci *columnIndex) lineColumn(lc cursorio.TextLineColumn) (cursorio.TextOffset, bool) {
+	idx := sort.Search(len(ci.checkpoints), func(i int) bool {
+		return compareLineColumn(ci.checkpoints[i].lineColumn, lc) > 0
+	}) - 1
+	if idx < 0 || ci.checkpoints[idx].lineColumn[0] != lc[0] {
+		return cursorio.TextOffset{}, false
+	}
+
+	cp := ci.checkpoints[idx]
+	i, column := cp.index, cp.lineColumn[1]
+
+	for column < lc[1] {
+		if i >= len(ci.source) || lineBreakLen(ci.source[i:]) > 0 {
+			// beyond the end of the line
+			return cursorio.TextOffset{}, false
+		}
+
+		size, columns := ci.unit.scan(ci.source[i:])
+		if column+columns > lc[1] {
+			break
+		}
+
+		i += size
+		column += columns
+	}
+
+	return cursorio.TextOffset{
+		Byte:       ci.initial.Byte + int64(i),
+		LineColumn: cursorio.TextLineColumn{lc[0], column},
+	}, true
+}
@@ -13,3 +13,11 @@ func Parse(r io.Reader) (*html.Node, *ParseMetadata, error) {
 func ParseWithOptions(r io.Reader, opts ...html.ParseOption) (*html.Node, *ParseMetadata, error) {
 	return NewParser(r).ParseWithOptions(opts...)
 }
+
+func ParseFragment(r io.Reader, context *html.Node) ([]*html.Node, *ParseMetadata, error) {
+	return NewParser(r).ParseFragment(context)
+}
+
+func ParseFragmentWithOptions(r io.Reader, context *html.Node, opts ...html.ParseOption) ([]*html.Node, *ParseMetadata, error) {
+	return NewParser(r).ParseFragmentWithOptions(context, opts...)
+}
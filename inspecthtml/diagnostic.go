@@ -0,0 +1,113 @@
+package inspecthtml
+
+import (
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+type DiagnosticSeverity int
+
+const (
+	DiagnosticSeverityError DiagnosticSeverity = iota + 1
+	DiagnosticSeverityWarning
+	DiagnosticSeverityInfo
+)
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case DiagnosticSeverityError:
+		return "error"
+	case DiagnosticSeverityWarning:
+		return "warning"
+	case DiagnosticSeverityInfo:
+		return "info"
+	}
+
+	return "unknown"
+}
+
+type DiagnosticCode string
+
+const (
+	// DiagnosticCodeAttrOffsetsUnavailable is used when the attributes of a tag could not be matched with those of the
+	// tokenizer. Unmatched attributes use an empty range at the end of the tag.
+	DiagnosticCodeAttrOffsetsUnavailable DiagnosticCode = "attr-offsets-unavailable"
+
+	// DiagnosticCodeTextNullDropped is used when NUL characters of a text token were dropped.
+	DiagnosticCodeTextNullDropped DiagnosticCode = "text-null-dropped"
+
+	// DiagnosticCodeEndTagUnmatched is used when an end tag did not close an element.
+	DiagnosticCodeEndTagUnmatched DiagnosticCode = "end-tag-unmatched"
+)
+
+// Recoveries of the DOM Processor, which are derived from the source order of the final tree.
+const (
+	// DiagnosticCodeElementUnclosed is used when an element was still open at the end of the document (or fragment) and
+	// its end tag is not optional.
+	DiagnosticCodeElementUnclosed DiagnosticCode = "element-unclosed"
+
+	// DiagnosticCodeElementImplicitlyClosed is used when an element was closed without its end tag by a following
+	// sibling or the end of its parent (e.g. a p closed by a div).
+	DiagnosticCodeElementImplicitlyClosed DiagnosticCode = "element-implicitly-closed"
+
+	// DiagnosticCodeElementMisnested is used when a node was moved out of a misnested element by the adoption agency
+	// algorithm (e.g. `<b><p>x</b>`).
+	DiagnosticCodeElementMisnested DiagnosticCode = "element-misnested"
+
+	// DiagnosticCodeElementReconstructed is used when a formatting element was reopened by the DOM Processor, such as
+	// after being closed by the adoption agency algorithm.
+	DiagnosticCodeElementReconstructed DiagnosticCode = "element-reconstructed"
+
+	// DiagnosticCodeNodeFosterParented is used when a node was moved before a table since it was not allowed within it.
+	DiagnosticCodeNodeFosterParented DiagnosticCode = "node-foster-parented"
+
+	// DiagnosticCodeNodeReparented is used when a node was moved elsewhere for any other reason (e.g. into head).
+	DiagnosticCodeNodeReparented DiagnosticCode = "node-reparented"
+)
+
+// Parse errors of the tokenizer use the same codes as the spec, which describes them in more detail.
+//
+// https://html.spec.whatwg.org/multipage/parsing.html#parse-errors
+const (
+	DiagnosticCodeAbruptClosingOfEmptyComment                   DiagnosticCode = "abrupt-closing-of-empty-comment"
+	DiagnosticCodeCDATAInHTMLContent                            DiagnosticCode = "cdata-in-html-content"
+	DiagnosticCodeCharacterReferenceOutsideUnicodeRange         DiagnosticCode = "character-reference-outside-unicode-range"
+	DiagnosticCodeControlCharacterReference                     DiagnosticCode = "control-character-reference"
+	DiagnosticCodeDuplicateAttribute                            DiagnosticCode = "duplicate-attribute"
+	DiagnosticCodeEndTagWithAttributes                          DiagnosticCode = "end-tag-with-attributes"
+	DiagnosticCodeEndTagWithTrailingSolidus                     DiagnosticCode = "end-tag-with-trailing-solidus"
+	DiagnosticCodeEOFBeforeTagName                              DiagnosticCode = "eof-before-tag-name"
+	DiagnosticCodeEOFInComment                                  DiagnosticCode = "eof-in-comment"
+	DiagnosticCodeEOFInDoctype                                  DiagnosticCode = "eof-in-doctype"
+	DiagnosticCodeEOFInTag                                      DiagnosticCode = "eof-in-tag"
+	DiagnosticCodeIncorrectlyClosedComment                      DiagnosticCode = "incorrectly-closed-comment"
+	DiagnosticCodeIncorrectlyOpenedComment                      DiagnosticCode = "incorrectly-opened-comment"
+	DiagnosticCodeInvalidFirstCharacterOfTagName                DiagnosticCode = "invalid-first-character-of-tag-name"
+	DiagnosticCodeMissingAttributeValue                         DiagnosticCode = "missing-attribute-value"
+	DiagnosticCodeMissingDoctypeName                            DiagnosticCode = "missing-doctype-name"
+	DiagnosticCodeMissingEndTagName                             DiagnosticCode = "missing-end-tag-name"
+	DiagnosticCodeMissingSemicolonAfterCharacterReference       DiagnosticCode = "missing-semicolon-after-character-reference"
+	DiagnosticCodeMissingWhitespaceBeforeDoctypeName            DiagnosticCode = "missing-whitespace-before-doctype-name"
+	DiagnosticCodeMissingWhitespaceBetweenAttributes            DiagnosticCode = "missing-whitespace-between-attributes"
+	DiagnosticCodeNestedComment                                 DiagnosticCode = "nested-comment"
+	DiagnosticCodeNoncharacterCharacterReference                DiagnosticCode = "noncharacter-character-reference"
+	DiagnosticCodeNonVoidHTMLElementStartTagWithTrailingSolidus DiagnosticCode = "non-void-html-element-start-tag-with-trailing-solidus"
+	DiagnosticCodeNullCharacterReference                        DiagnosticCode = "null-character-reference"
+	DiagnosticCodeSurrogateCharacterReference                   DiagnosticCode = "surrogate-character-reference"
+	DiagnosticCodeUnexpectedCharacterInAttributeName            DiagnosticCode = "unexpected-character-in-attribute-name"
+	DiagnosticCodeUnexpectedCharacterInUnquotedAttributeValue   DiagnosticCode = "unexpected-character-in-unquoted-attribute-value"
+	DiagnosticCodeUnexpectedEqualsSignBeforeAttributeName       DiagnosticCode = "unexpected-equals-sign-before-attribute-name"
+	DiagnosticCodeUnexpectedNullCharacter                       DiagnosticCode = "unexpected-null-character"
+	DiagnosticCodeUnexpectedQuestionMarkInsteadOfTagName        DiagnosticCode = "unexpected-question-mark-instead-of-tag-name"
+	DiagnosticCodeUnexpectedSolidusInTag                        DiagnosticCode = "unexpected-solidus-in-tag"
+)
+
+type Diagnostic struct {
+	Code     DiagnosticCode
+	Severity DiagnosticSeverity
+	Message  string
+	Offsets  cursorio.TextOffsetRange
+
+	// Node is the affected node of the tree, if any.
+	Node *html.Node
+}
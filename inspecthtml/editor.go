@@ -0,0 +1,406 @@
+package inspecthtml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var ErrEditorOverlap = errors.New("overlapping edit")
+
+// Editor collects source-preserving edits of a parsed document. Only the affected byte ranges are rewritten; everything
+// else remains byte-for-byte unchanged. The source must be the original input, where the first byte corresponds to
+// offset zero of the metadata (i.e. the parser was not configured with a different initial offset).
+type Editor struct {
+	source   []byte
+	metadata *ParseMetadata
+	edits    []editorEdit
+}
+
+type editorEdit struct {
+	from  int
+	until int
+	data  []byte
+}
+
+func NewEditor(source []byte, metadata *ParseMetadata) *Editor {
+	return &Editor{
+		source:   source,
+		metadata: metadata,
+	}
+}
+
+func (e *Editor) add(from, until int, data []byte) error {
+	if from < 0 || until < from || until > len(e.source) {
+		return fmt.Errorf("edit range [%d, %d) is outside of source", from, until)
+	}
+
+	for _, existing := range e.edits {
+		var overlaps bool
+
+		if from == until {
+			overlaps = existing.from < from && from < existing.until
+		} else if existing.from == existing.until {
+			overlaps = from < existing.from && existing.from < until
+		} else {
+			overlaps = from < existing.until && existing.from < until
+		}
+
+		if overlaps {
+			return fmt.Errorf("%w: [%d, %d) conflicts with [%d, %d)", ErrEditorOverlap, from, until, existing.from, existing.until)
+		}
+	}
+
+	e.edits = append(e.edits, editorEdit{
+		from:  from,
+		until: until,
+		data:  data,
+	})
+
+	return nil
+}
+
+func (e *Editor) getNodeMetadata(n *html.Node) (*NodeMetadata, error) {
+	metadata, ok := e.metadata.GetNodeMetadata(n)
+	if !ok {
+		return nil, fmt.Errorf("node metadata not found")
+	} else if e.metadata.GetNodeProvenance(n) == NodeProvenanceCloned {
+		// the metadata is shared with the original element, which would be edited instead
+		return nil, fmt.Errorf("node was cloned from another element: %s", n.Data)
+	}
+
+	return metadata, nil
+}
+
+// validateName returns an error if a tag or attribute name would not be tokenized as a single name (e.g. it would end
+// the tag, or start another attribute).
+func (e *Editor) validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n\f\r/>=\"'<") {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+
+	return nil
+}
+
+func (e *Editor) getAttr(n *html.Node, metadata *NodeMetadata, key string) (int, *NodeAttributeMetadata, error) {
+	for attrIdx, attr := range n.Attr {
+		if attr.Namespace != "" || attr.Key != key {
+			continue
+		} else if attrIdx >= len(metadata.TagAttr) {
+			return -1, nil, fmt.Errorf("attribute metadata not found: %s", key)
+		}
+
+		return attrIdx, metadata.TagAttr[attrIdx], nil
+	}
+
+	return -1, nil, nil
+}
+
+// attrInsertOffset returns the offset immediately after the tag name or last attribute of a start tag.
+func (e *Editor) attrInsertOffset(metadata *NodeMetadata) (int, error) {
+	if metadata.TagNameOffsets == nil {
+		return -1, fmt.Errorf("tag name metadata not found")
+	}
+
+	offset := int(metadata.TagNameOffsets.Until.Byte)
+
+	for _, attr := range metadata.TagAttr {
+		if attr.MergedStartTag != nil {
+			// from a different start tag
+			continue
+		}
+
+		until := int(attr.KeyOffsets.Until.Byte)
+		if attr.ValueOffsets != nil {
+			until = int(attr.ValueOffsets.Until.Byte)
+		}
+
+		offset = max(offset, until)
+	}
+
+	return offset, nil
+}
+
+func (e *Editor) quoteAttrValue(value string, quote byte) []byte {
+	buf := []byte{quote}
+	buf = append(buf, html.EscapeString(value)...)
+	buf = append(buf, quote)
+
+	return buf
+}
+
+// SetAttrValue changes the value of an existing attribute or, if it does not exist, adds it. The quote style of an
+// existing, quoted value is preserved.
+func (e *Editor) SetAttrValue(n *html.Node, key, value string) error {
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	attrIdx, attrMetadata, err := e.getAttr(n, metadata, key)
+	if err != nil {
+		return err
+	} else if attrIdx == -1 {
+		return e.AddAttr(n, key, value)
+	}
+
+	if attrMetadata.ValueOffsets == nil {
+		// rewrite the key along with the value, so the edit overlaps any other edit of the attribute (e.g. its removal)
+		keyFrom, keyUntil := int(attrMetadata.KeyOffsets.From.Byte), int(attrMetadata.KeyOffsets.Until.Byte)
+
+		data := append([]byte{}, e.source[keyFrom:keyUntil]...)
+		data = append(data, '=')
+		data = append(data, e.quoteAttrValue(value, '"')...)
+
+		return e.add(keyFrom, keyUntil, data)
+	}
+
+	quote := byte('"')
+	if from := int(attrMetadata.ValueOffsets.From.Byte); from < len(e.source) && e.source[from] == '\'' {
+		quote = '\''
+	}
+
+	return e.add(
+		int(attrMetadata.ValueOffsets.From.Byte),
+		int(attrMetadata.ValueOffsets.Until.Byte),
+		e.quoteAttrValue(value, quote),
+	)
+}
+
+// RemoveAttrValue removes the value of an existing attribute, leaving it as an empty, boolean-style attribute.
+func (e *Editor) RemoveAttrValue(n *html.Node, key string) error {
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	attrIdx, attrMetadata, err := e.getAttr(n, metadata, key)
+	if err != nil {
+		return err
+	} else if attrIdx == -1 {
+		return fmt.Errorf("attribute not found: %s", key)
+	} else if attrMetadata.ValueOffsets == nil {
+		return nil
+	}
+
+	return e.add(int(attrMetadata.KeyOffsets.Until.Byte), int(attrMetadata.ValueOffsets.Until.Byte), nil)
+}
+
+// AddAttr adds a new attribute after the existing attributes of the start tag. The key is rejected if it would not be
+// tokenized as a single attribute name.
+func (e *Editor) AddAttr(n *html.Node, key, value string) error {
+	if err := e.validateName(key); err != nil {
+		return err
+	}
+
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	attrIdx, _, err := e.getAttr(n, metadata, key)
+	if err != nil {
+		return err
+	} else if attrIdx > -1 {
+		return fmt.Errorf("attribute already exists: %s", key)
+	}
+
+	offset, err := e.attrInsertOffset(metadata)
+	if err != nil {
+		return err
+	}
+
+	data := append([]byte(" "+key+"="), e.quoteAttrValue(value, '"')...)
+
+	return e.add(offset, offset, data)
+}
+
+// RemoveAttr removes an existing attribute, including the whitespace which preceded it.
+func (e *Editor) RemoveAttr(n *html.Node, key string) error {
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	attrIdx, attrMetadata, err := e.getAttr(n, metadata, key)
+	if err != nil {
+		return err
+	} else if attrIdx == -1 {
+		return fmt.Errorf("attribute not found: %s", key)
+	}
+
+	// merged attributes are removed from the start tag they were written in
+	tag := metadata
+	if attrMetadata.MergedStartTag != nil {
+		tag = attrMetadata.MergedStartTag
+	}
+
+	if tag.TagNameOffsets == nil {
+		return fmt.Errorf("tag name metadata not found")
+	}
+
+	from := int(attrMetadata.KeyOffsets.From.Byte)
+	until := int(attrMetadata.KeyOffsets.Until.Byte)
+	if attrMetadata.ValueOffsets != nil {
+		until = int(attrMetadata.ValueOffsets.Until.Byte)
+	}
+
+	// extend backwards to the end of whatever preceded the attribute
+	precedingUntil := int(tag.TagNameOffsets.Until.Byte)
+
+	for _, attr := range tag.TagAttr {
+		if attr == attrMetadata || attr.KeyOffsets == attrMetadata.KeyOffsets || attr.MergedStartTag != nil {
+			continue
+		}
+
+		attrUntil := int(attr.KeyOffsets.Until.Byte)
+		if attr.ValueOffsets != nil {
+			attrUntil = int(attr.ValueOffsets.Until.Byte)
+		}
+
+		if attrUntil <= from {
+			precedingUntil = max(precedingUntil, attrUntil)
+		}
+	}
+
+	return e.add(precedingUntil, until, nil)
+}
+
+// RenameTag changes the tag name of both the start tag and, if present in source, the end tag. The name is rejected if
+// it would not be tokenized as a single tag name.
+func (e *Editor) RenameTag(n *html.Node, name string) error {
+	if err := e.validateName(name); err != nil {
+		return err
+	} else if c := name[0]; (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+		// otherwise, tokenized as text rather than a tag
+		return fmt.Errorf("invalid name: %q", name)
+	}
+
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	} else if metadata.TagNameOffsets == nil {
+		return fmt.Errorf("tag name metadata not found")
+	}
+
+	endTagFrom, endTagUntil := -1, -1
+
+	if metadata.EndTagTokenOffsets != nil {
+		from := int(metadata.EndTagTokenOffsets.From.Byte)
+		until := int(metadata.EndTagTokenOffsets.Until.Byte)
+
+		if until > from && bytes.HasPrefix(e.source[from:until], []byte("</")) {
+			endTagFrom = from + 2
+			endTagUntil = endTagFrom
+
+			for endTagUntil < until && !bytes.ContainsRune([]byte(" \t\n\r\f/>"), rune(e.source[endTagUntil])) {
+				endTagUntil++
+			}
+		}
+	}
+
+	err = e.add(int(metadata.TagNameOffsets.From.Byte), int(metadata.TagNameOffsets.Until.Byte), []byte(name))
+	if err != nil {
+		return err
+	}
+
+	if endTagFrom > -1 {
+		err = e.add(endTagFrom, endTagUntil, []byte(name))
+		if err != nil {
+			e.edits = e.edits[:len(e.edits)-1]
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplaceInner replaces the content between the start and end tag of an element.
+func (e *Editor) ReplaceInner(n *html.Node, data []byte) error {
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	inner := metadata.GetInnerOffsets()
+	if inner == nil {
+		return fmt.Errorf("inner offsets not found")
+	}
+
+	return e.add(int(inner.From.Byte), int(inner.Until.Byte), data)
+}
+
+// ReplaceOuter replaces the node, including its start and end tags.
+func (e *Editor) ReplaceOuter(n *html.Node, data []byte) error {
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	outer := metadata.GetOuterOffsets()
+
+	return e.add(int(outer.From.Byte), int(outer.Until.Byte), data)
+}
+
+// Wrap inserts data immediately before and after the node.
+func (e *Editor) Wrap(n *html.Node, before, after []byte) error {
+	metadata, err := e.getNodeMetadata(n)
+	if err != nil {
+		return err
+	}
+
+	outer := metadata.GetOuterOffsets()
+
+	err = e.add(int(outer.From.Byte), int(outer.From.Byte), before)
+	if err != nil {
+		return err
+	}
+
+	err = e.add(int(outer.Until.Byte), int(outer.Until.Byte), after)
+	if err != nil {
+		e.edits = e.edits[:len(e.edits)-1]
+
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the node, including its start and end tags.
+func (e *Editor) Delete(n *html.Node) error {
+	return e.ReplaceOuter(n, nil)
+}
+
+// Bytes returns the source with all edits applied.
+func (e *Editor) Bytes() []byte {
+	edits := make([]editorEdit, len(e.edits))
+	copy(edits, e.edits)
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].from != edits[j].from {
+			return edits[i].from < edits[j].from
+		}
+
+		// insertions are applied before a replacement starting at the same offset
+		return edits[i].from == edits[i].until && edits[j].from != edits[j].until
+	})
+
+	var buf bytes.Buffer
+
+	var cursor int
+
+	for _, edit := range edits {
+		buf.Write(e.source[cursor:edit.from])
+		buf.Write(edit.data)
+		cursor = edit.until
+	}
+
+	buf.Write(e.source[cursor:])
+
+	return buf.Bytes()
+}
@@ -0,0 +1,296 @@
+package inspecthtml
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func TestEditor(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		edit     func(e *Editor, document *html.Node) error
+		expected string
+	}{
+		{
+			"set existing attribute value keeps quote style",
+			"<p  class='lead'   id=x>hello</p>",
+			func(e *Editor, document *html.Node) error {
+				p := findElement(document, atom.P)
+
+				if err := e.SetAttrValue(p, "class", `a "quoted" value`); err != nil {
+					return err
+				}
+
+				return e.SetAttrValue(p, "id", "y")
+			},
+			"<p  class='a &#34;quoted&#34; value'   id=\"y\">hello</p>",
+		},
+		{
+			"set attribute value without value",
+			"<input disabled>",
+			func(e *Editor, document *html.Node) error {
+				return e.SetAttrValue(findElement(document, atom.Input), "disabled", "disabled")
+			},
+			"<input disabled=\"disabled\">",
+		},
+		{
+			"remove attribute value",
+			"<input disabled=\"disabled\" />",
+			func(e *Editor, document *html.Node) error {
+				return e.RemoveAttrValue(findElement(document, atom.Input), "disabled")
+			},
+			"<input disabled />",
+		},
+		{
+			"add attribute",
+			"<br\n  class=\"x\"\n/>",
+			func(e *Editor, document *html.Node) error {
+				return e.SetAttrValue(findElement(document, atom.Br), "title", "<new>")
+			},
+			"<br\n  class=\"x\" title=\"&lt;new&gt;\"\n/>",
+		},
+		{
+			"remove attribute",
+			"<a class=\"x\"\n   href=\"#\" id=\"y\">link</a>",
+			func(e *Editor, document *html.Node) error {
+				a := findElement(document, atom.A)
+
+				if err := e.RemoveAttr(a, "href"); err != nil {
+					return err
+				}
+
+				return e.RemoveAttr(a, "class")
+			},
+			"<a id=\"y\">link</a>",
+		},
+		{
+			"rename tag",
+			"<div><B class=\"x\">bold</B ></div>",
+			func(e *Editor, document *html.Node) error {
+				return e.RenameTag(findElement(document, atom.B), "strong")
+			},
+			"<div><strong class=\"x\">bold</strong ></div>",
+		},
+		{
+			"rename tag with implied end",
+			"<ul><li>one<li>two</ul>",
+			func(e *Editor, document *html.Node) error {
+				return e.RenameTag(findElement(document, atom.Li), "dt")
+			},
+			"<ul><dt>one<li>two</ul>",
+		},
+		{
+			"replace inner",
+			"<div>\n  <p>hello</p>\n</div>",
+			func(e *Editor, document *html.Node) error {
+				return e.ReplaceInner(findElement(document, atom.P), []byte("<em>world</em>"))
+			},
+			"<div>\n  <p><em>world</em></p>\n</div>",
+		},
+		{
+			"wrap and replace outer",
+			"<div><p>hello</p></div>",
+			func(e *Editor, document *html.Node) error {
+				p := findElement(document, atom.P)
+
+				if err := e.Wrap(p, []byte("<section>"), []byte("</section>")); err != nil {
+					return err
+				}
+
+				return e.ReplaceOuter(p, []byte("<p>world</p>"))
+			},
+			"<div><section><p>world</p></section></div>",
+		},
+		{
+			"delete",
+			"<ul><li>one</li><li>two</li></ul>",
+			func(e *Editor, document *html.Node) error {
+				return e.Delete(findElement(document, atom.Li))
+			},
+			"<ul><li>two</li></ul>",
+		},
+		{
+			"merged attributes",
+			"<body class=a>x<body id=b title=c>",
+			func(e *Editor, document *html.Node) error {
+				body := findElement(document, atom.Body)
+
+				if err := e.RemoveAttr(body, "id"); err != nil {
+					return err
+				} else if err := e.SetAttrValue(body, "title", "d"); err != nil {
+					return err
+				}
+
+				return e.AddAttr(body, "lang", "en")
+			},
+			"<body class=a lang=\"en\">x<body title=\"d\">",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(bytes.NewReader([]byte(tc.input)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			editor := NewEditor([]byte(tc.input), documentOffsets)
+
+			if err := tc.edit(editor, document); err != nil {
+				t.Fatalf("unexpected edit error: %v", err)
+			} else if _a, _e := string(editor.Bytes()), tc.expected; _a != _e {
+				t.Fatalf("expected %q, got %q", _e, _a)
+			}
+		})
+	}
+}
+
+func TestEditorOverlap(t *testing.T) {
+	input := "<div><p class=\"x\">hello</p></div>"
+
+	document, documentOffsets, err := Parse(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	editor := NewEditor([]byte(input), documentOffsets)
+
+	if err := editor.SetAttrValue(findElement(document, atom.P), "class", "y"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := editor.ReplaceInner(findElement(document, atom.Div), nil); !errors.Is(err, ErrEditorOverlap) {
+		t.Fatalf("expected overlap error, got %v", err)
+	}
+
+	if err := editor.RenameTag(findElement(document, atom.P), "span"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := string(editor.Bytes()), "<div><span class=\"y\">hello</span></div>"; _a != _e {
+		t.Fatalf("expected %q, got %q", _e, _a)
+	}
+}
+
+func TestEditorOverlapRemovedAttr(t *testing.T) {
+	input := "<div a=1 c>x</div>"
+
+	document, documentOffsets, err := Parse(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	div := findElement(document, atom.Div)
+	editor := NewEditor([]byte(input), documentOffsets)
+
+	if err := editor.RemoveAttr(div, "c"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := editor.SetAttrValue(div, "c", "v"); !errors.Is(err, ErrEditorOverlap) {
+		t.Fatalf("expected overlap error, got %v", err)
+	} else if err := editor.RemoveAttrValue(div, "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the insertion follows the removed attribute
+	if err := editor.AddAttr(div, "d", "v"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := string(editor.Bytes()), "<div a d=\"v\">x</div>"; _a != _e {
+		t.Fatalf("expected %q, got %q", _e, _a)
+	}
+}
+
+func TestEditorCloned(t *testing.T) {
+	input := "<b>1<p>2</b>3</p>"
+
+	document, documentOffsets, err := Parse(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	editor := NewEditor([]byte(input), documentOffsets)
+
+	// the formatting element reopened within the p by the adoption agency algorithm
+	cloned := findElement(document, atom.P).FirstChild
+	if _a, _e := documentOffsets.GetNodeProvenance(cloned), NodeProvenanceCloned; _a != _e {
+		t.Fatalf("provenance: expected %v, got %v", _e, _a)
+	}
+
+	if err := editor.Delete(cloned); err == nil {
+		t.Fatal("delete: expected error")
+	} else if err := editor.RenameTag(cloned, "i"); err == nil {
+		t.Fatal("rename: expected error")
+	} else if err := editor.SetAttrValue(cloned, "class", "x"); err == nil {
+		t.Fatal("set attribute value: expected error")
+	}
+
+	if err := editor.RenameTag(findElement(document, atom.B), "i"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := string(editor.Bytes()), "<i>1<p>2</i>3</p>"; _a != _e {
+		t.Fatalf("expected %q, got %q", _e, _a)
+	}
+}
+
+func TestEditorInvalidName(t *testing.T) {
+	input := "<p class=\"x\">hello</p>"
+
+	document, documentOffsets, err := Parse(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := findElement(document, atom.P)
+	editor := NewEditor([]byte(input), documentOffsets)
+
+	for _, name := range []string{"", "x onclick", "x\tonclick", "a/b", "a>", "a=b", `a"`, "a'", "a<b"} {
+		if err := editor.AddAttr(p, name, "v"); err == nil {
+			t.Errorf("add attribute %q: expected error", name)
+		} else if err := editor.SetAttrValue(p, name, "v"); err == nil {
+			t.Errorf("set attribute value %q: expected error", name)
+		}
+	}
+
+	for _, name := range []string{"", "a><script", "a b", "a/", "1a"} {
+		if err := editor.RenameTag(p, name); err == nil {
+			t.Errorf("rename %q: expected error", name)
+		}
+	}
+
+	if _a, _e := string(editor.Bytes()), input; _a != _e {
+		t.Fatalf("expected %q, got %q", _e, _a)
+	}
+}
+
+func TestEditorVoid(t *testing.T) {
+	for _, input := range []string{"<p>a<br>b</p>", "<p>a<br/>b</p>"} {
+		t.Run(input, func(t *testing.T) {
+			document, documentOffsets, err := Parse(bytes.NewReader([]byte(input)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			editor := NewEditor([]byte(input), documentOffsets)
+
+			// a void element has no inner content to replace
+			if err := editor.ReplaceInner(findElement(document, atom.Br), []byte("x")); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -5,13 +5,37 @@ import (
 )
 
 type NodeMetadata struct {
+	// Provenance describes how the node relates to its source. Since clones (e.g. formatting elements reconstructed by
+	// the DOM Processor) share the metadata of their original, use ParseMetadata.GetNodeProvenance for a specific node.
+	Provenance NodeProvenance
+
 	TokenOffsets cursorio.TextOffsetRange
 
 	TagNameOffsets *cursorio.TextOffsetRange
 	TagAttr        []*NodeAttributeMetadata
 	TagSelfClosing bool
 
+	// MergedStartTags is only used by html and body elements. It is the metadata of any additional start tags whose
+	// attributes were merged into the element, in source order.
+	MergedStartTags []*NodeMetadata
+
+	// EndTagTokenOffsets is nil for void and self-closing elements, which never have an end tag.
 	EndTagTokenOffsets *cursorio.TextOffsetRange
+
+	// ImpliedEndTagReason is used when the end tag was not in source, in which case EndTagTokenOffsets is a logical end
+	// tag of zero length. It is zero when the end tag was in source, or for void and self-closing elements.
+	ImpliedEndTagReason ImpliedEndTagReason
+
+	// DoctypeNameOffsets, DoctypePublicIdentifierOffsets, and DoctypeSystemIdentifierOffsets are only used by doctype
+	// nodes. Identifier offsets include their quotes.
+	DoctypeNameOffsets             *cursorio.TextOffsetRange
+	DoctypePublicIdentifierOffsets *cursorio.TextOffsetRange
+	DoctypeSystemIdentifierOffsets *cursorio.TextOffsetRange
+
+	// TextSegments is only used by text nodes. Each segment maps a part of the node's data to the source it was read
+	// from, in order. Multiple segments are used when the data was not contiguous in source (e.g. merged whitespace or
+	// dropped NUL characters), in which case TokenOffsets spans all of them.
+	TextSegments []NodeTextSegment
 }
 
 func (n NodeMetadata) GetOuterOffsets() cursorio.TextOffsetRange {
@@ -40,9 +64,210 @@ func (n NodeMetadata) GetInnerOffsets() *cursorio.TextOffsetRange {
 	}
 }
 
+// GetTextDataOffset returns the source offset of the byte index within the data of a text node (i.e. html.Node.Data).
+// An index at the boundary of two segments uses the later segment. It returns false if the index is out of range.
+func (n NodeMetadata) GetTextDataOffset(data string, i int) (cursorio.TextOffset, bool) {
+	for si, segment := range n.TextSegments {
+		if i < segment.DataUntil || (i == segment.DataUntil && si == len(n.TextSegments)-1) {
+			return segment.GetDataOffset(data, i)
+		}
+	}
+
+	return cursorio.TextOffset{}, false
+}
+
+// GetTextDataIndex returns the byte index within the data of a text node for the source offset. It returns false if the
+// offset is not within a segment.
+func (n NodeMetadata) GetTextDataIndex(offset cursorio.TextOffset) (int, bool) {
+	for _, segment := range n.TextSegments {
+		if i, ok := segment.GetDataIndex(offset); ok {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+//
+
+// NodeProvenance describes how a node of the DOM tree relates to the source.
+type NodeProvenance int
+
+const (
+	// NodeProvenanceExplicit is used for nodes which are in source, where they were written.
+	NodeProvenanceExplicit NodeProvenance = iota + 1
+
+	// NodeProvenanceImplied is used for nodes which are not in source, such as elements injected by the DOM Processor.
+	// They do not have metadata.
+	NodeProvenanceImplied
+
+	// NodeProvenanceReparented is used for nodes which are in source, but were moved elsewhere by the DOM Processor
+	// (e.g. foster parenting or the adoption agency algorithm).
+	NodeProvenanceReparented
+
+	// NodeProvenanceMerged is used for nodes which combine multiple parts of source, such as text merged with
+	// whitespace or html and body elements with MergedStartTags.
+	NodeProvenanceMerged
+
+	// NodeProvenanceCloned is used for elements which were created by the DOM Processor as a copy of an element in
+	// source (e.g. reconstructed formatting elements). They share the metadata of the original element.
+	NodeProvenanceCloned
+)
+
+func (p NodeProvenance) String() string {
+	switch p {
+	case NodeProvenanceExplicit:
+		return "explicit"
+	case NodeProvenanceImplied:
+		return "implied"
+	case NodeProvenanceReparented:
+		return "reparented"
+	case NodeProvenanceMerged:
+		return "merged"
+	case NodeProvenanceCloned:
+		return "cloned"
+	}
+
+	return "unknown"
+}
+
+// ImpliedEndTagReason describes why an element was closed without an end tag in source.
+type ImpliedEndTagReason int
+
+const (
+	// ImpliedEndTagReasonSibling is used when the element was closed by a following sibling (e.g. consecutive li).
+	ImpliedEndTagReasonSibling ImpliedEndTagReason = iota + 1
+
+	// ImpliedEndTagReasonParent is used when the element was closed along with its parent.
+	ImpliedEndTagReasonParent
+
+	// ImpliedEndTagReasonEOF is used when the element was closed by the end of the document or fragment.
+	ImpliedEndTagReasonEOF
+)
+
+func (r ImpliedEndTagReason) String() string {
+	switch r {
+	case ImpliedEndTagReasonSibling:
+		return "sibling"
+	case ImpliedEndTagReasonParent:
+		return "parent"
+	case ImpliedEndTagReasonEOF:
+		return "eof"
+	}
+
+	return "unknown"
+}
+
+//
+
+// NodeTextSegment maps the byte range of text node data, [DataFrom, DataUntil), to its source offsets. The length of
+// the source may differ from the data, such as for character references, in which case Replacements describes them.
+type NodeTextSegment struct {
+	DataFrom  int
+	DataUntil int
+	Offsets   cursorio.TextOffsetRange
+
+	// Replacements are the parts of the segment which were decoded into different data, in order. Any other data is the
+	// same as its source.
+	Replacements []NodeTextReplacement
+}
+
+// GetDataOffset returns the source offset of the byte index within data, which is the same data the segment ranges
+// refer to. An index within a replacement uses the start of its source. It returns false if the index is out of range.
+func (s NodeTextSegment) GetDataOffset(data string, i int) (cursorio.TextOffset, bool) {
+	if i < s.DataFrom || i > s.DataUntil || s.DataUntil > len(data) {
+		return cursorio.TextOffset{}, false
+	}
+
+	from, dataFrom := s.Offsets.From, s.DataFrom
+
+	for _, r := range s.Replacements {
+		if i < r.DataFrom {
+			break
+		} else if i < r.DataUntil {
+			return r.Offsets.From, true
+		}
+
+		from, dataFrom = r.Offsets.Until, r.DataUntil
+	}
+
+	if i == dataFrom {
+		return from, true
+	}
+
+	w := cursorio.NewTextWriter(from)
+	w.Write([]byte(data[dataFrom:i]))
+
+	return w.GetTextOffset(), true
+}
+
+// GetDataIndex returns the byte index within data for the source offset. An offset within the source of a replacement
+// uses the start of its data. It returns false if the offset is out of range.
+func (s NodeTextSegment) GetDataIndex(offset cursorio.TextOffset) (int, bool) {
+	if offset.Byte < s.Offsets.From.Byte || offset.Byte > s.Offsets.Until.Byte {
+		return 0, false
+	}
+
+	from, dataFrom := s.Offsets.From, s.DataFrom
+
+	for _, r := range s.Replacements {
+		if offset.Byte < r.Offsets.From.Byte {
+			break
+		} else if offset.Byte < r.Offsets.Until.Byte {
+			return r.DataFrom, true
+		}
+
+		from, dataFrom = r.Offsets.Until, r.DataUntil
+	}
+
+	return dataFrom + int(offset.Byte-from.Byte), true
+}
+
+// NodeTextReplacement maps the byte range of data, [DataFrom, DataUntil), to the source it was decoded from, such as a
+// character reference (e.g. `&amp;` into `&`) or a normalized newline (e.g. `\r\n` into `\n`).
+type NodeTextReplacement struct {
+	DataFrom  int
+	DataUntil int
+	Offsets   cursorio.TextOffsetRange
+
+	// Data is the decoded replacement (i.e. the same as the data within the range).
+	Data string
+
+	// CharacterReference is true when the source was a character reference.
+	CharacterReference bool
+}
+
 //
 
 type NodeAttributeMetadata struct {
 	KeyOffsets   cursorio.TextOffsetRange
 	ValueOffsets *cursorio.TextOffsetRange
+
+	// ValueSegment maps the decoded value (i.e. html.Attribute.Val) to its source, excluding any quotes. It is nil when
+	// there was no value.
+	ValueSegment *NodeTextSegment
+
+	// MergedStartTag is the start tag the attribute was merged from (one of NodeMetadata.MergedStartTags), or nil if it
+	// is from the element's own start tag.
+	MergedStartTag *NodeMetadata
+}
+
+// GetValueDataOffset returns the source offset of the byte index within the decoded value. It returns false if there
+// was no value or the index is out of range.
+func (a NodeAttributeMetadata) GetValueDataOffset(value string, i int) (cursorio.TextOffset, bool) {
+	if a.ValueSegment == nil {
+		return cursorio.TextOffset{}, false
+	}
+
+	return a.ValueSegment.GetDataOffset(value, i)
+}
+
+// GetValueDataIndex returns the byte index within the decoded value for the source offset. It returns false if there
+// was no value or the offset is out of range.
+func (a NodeAttributeMetadata) GetValueDataIndex(offset cursorio.TextOffset) (int, bool) {
+	if a.ValueSegment == nil {
+		return 0, false
+	}
+
+	return a.ValueSegment.GetDataIndex(offset)
 }
@@ -0,0 +1,222 @@
+package inspecthtml
+
+import (
+	"cmp"
+	"sort"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+type OffsetIndexMatchKind int
+
+const (
+	// OffsetIndexMatchNode is used when the offset is within the node, but not within a more specific part of it.
+	OffsetIndexMatchNode OffsetIndexMatchKind = iota
+	OffsetIndexMatchTagName
+	OffsetIndexMatchAttrKey
+	OffsetIndexMatchAttrValue
+	OffsetIndexMatchEndTag
+)
+
+type OffsetIndexMatch struct {
+	Node         *html.Node
+	NodeMetadata *NodeMetadata
+	Kind         OffsetIndexMatchKind
+
+	// AttrIndex refers to both Node.Attr and NodeMetadata.TagAttr for attribute matches; otherwise -1.
+	AttrIndex int
+}
+
+// OffsetIndex supports looking up nodes by their source offsets. It is an interval tree (implicitly structured over a
+// slice sorted by starting offset) of the outer offsets of every node with metadata.
+type OffsetIndex struct {
+	entries  []offsetIndexEntry
+	maxUntil []cursorio.TextOffset
+}
+
+type offsetIndexEntry struct {
+	node     *html.Node
+	metadata *NodeMetadata
+	offsets  cursorio.TextOffsetRange
+	depth    int
+}
+
+type offsetComparator func(a, b cursorio.TextOffset) int
+
+func compareOffsetByte(a, b cursorio.TextOffset) int {
+	return cmp.Compare(a.Byte, b.Byte)
+}
+
+func compareOffsetLineColumn(a, b cursorio.TextOffset) int {
+	if c := cmp.Compare(a.LineColumn[0], b.LineColumn[0]); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(a.LineColumn[1], b.LineColumn[1])
+}
+
+func newOffsetIndex(po *ParseMetadata) *OffsetIndex {
+	oi := &OffsetIndex{}
+
+	for n := range po.metadataByNode {
+		metadata, ok := po.GetNodeMetadata(n)
+		if !ok {
+			continue
+		}
+
+		var depth int
+
+		for p := n.Parent; p != nil; p = p.Parent {
+			depth++
+		}
+
+		oi.entries = append(oi.entries, offsetIndexEntry{
+			node:     n,
+			metadata: metadata,
+			offsets:  metadata.GetOuterOffsets(),
+			depth:    depth,
+		})
+	}
+
+	sort.Slice(oi.entries, func(i, j int) bool {
+		ei, ej := oi.entries[i], oi.entries[j]
+
+		if c := compareOffsetByte(ei.offsets.From, ej.offsets.From); c != 0 {
+			return c < 0
+		} else if c := compareOffsetByte(ei.offsets.Until, ej.offsets.Until); c != 0 {
+			return c > 0
+		}
+
+		return ei.depth < ej.depth
+	})
+
+	oi.maxUntil = make([]cursorio.TextOffset, len(oi.entries))
+	oi.buildMaxUntil(0, len(oi.entries))
+
+	return oi
+}
+
+func (oi *OffsetIndex) buildMaxUntil(lo, hi int) (cursorio.TextOffset, bool) {
+	if lo >= hi {
+		return cursorio.TextOffset{}, false
+	}
+
+	mid := (lo + hi) / 2
+	maxUntil := oi.entries[mid].offsets.Until
+
+	if v, ok := oi.buildMaxUntil(lo, mid); ok && compareOffsetByte(v, maxUntil) > 0 {
+		maxUntil = v
+	}
+
+	if v, ok := oi.buildMaxUntil(mid+1, hi); ok && compareOffsetByte(v, maxUntil) > 0 {
+		maxUntil = v
+	}
+
+	oi.maxUntil[mid] = maxUntil
+
+	return maxUntil, true
+}
+
+// visit calls f, in order of starting offset, for every entry which overlaps the range. If point is true, the range is
+// treated as the single position of from (and until is ignored).
+func (oi *OffsetIndex) visit(lo, hi int, from, until cursorio.TextOffset, point bool, compare offsetComparator, f func(e *offsetIndexEntry)) {
+	if lo >= hi {
+		return
+	}
+
+	mid := (lo + hi) / 2
+
+	if compare(oi.maxUntil[mid], from) <= 0 {
+		// nothing in this subtree ends after the range starts
+		return
+	}
+
+	oi.visit(lo, mid, from, until, point, compare, f)
+
+	e := &oi.entries[mid]
+
+	if point {
+		if compare(e.offsets.From, from) > 0 {
+			return
+		}
+	} else if compare(e.offsets.From, until) >= 0 {
+		return
+	}
+
+	if compare(e.offsets.Until, from) > 0 {
+		f(e)
+	}
+
+	oi.visit(mid+1, hi, from, until, point, compare, f)
+}
+
+func (oi *OffsetIndex) lookup(o cursorio.TextOffset, compare offsetComparator) (OffsetIndexMatch, bool) {
+	var deepest *offsetIndexEntry
+
+	oi.visit(0, len(oi.entries), o, o, true, compare, func(e *offsetIndexEntry) {
+		if deepest == nil || e.depth > deepest.depth || (e.depth == deepest.depth && compare(e.offsets.From, deepest.offsets.From) >= 0) {
+			deepest = e
+		}
+	})
+
+	if deepest == nil {
+		return OffsetIndexMatch{}, false
+	}
+
+	match := OffsetIndexMatch{
+		Node:         deepest.node,
+		NodeMetadata: deepest.metadata,
+		Kind:         OffsetIndexMatchNode,
+		AttrIndex:    -1,
+	}
+
+	contains := func(r *cursorio.TextOffsetRange) bool {
+		return r != nil && compare(r.From, o) <= 0 && compare(r.Until, o) > 0
+	}
+
+	if contains(deepest.metadata.TagNameOffsets) {
+		match.Kind = OffsetIndexMatchTagName
+	} else if contains(deepest.metadata.EndTagTokenOffsets) {
+		match.Kind = OffsetIndexMatchEndTag
+	} else {
+		for attrIdx, attr := range deepest.metadata.TagAttr {
+			if contains(&attr.KeyOffsets) {
+				match.Kind = OffsetIndexMatchAttrKey
+				match.AttrIndex = attrIdx
+
+				break
+			} else if contains(attr.ValueOffsets) {
+				match.Kind = OffsetIndexMatchAttrValue
+				match.AttrIndex = attrIdx
+
+				break
+			}
+		}
+	}
+
+	return match, true
+}
+
+// LookupOffset returns the deepest node containing the byte offset, including which part of the node it was found in.
+func (oi *OffsetIndex) LookupOffset(o cursorio.TextOffset) (OffsetIndexMatch, bool) {
+	return oi.lookup(o, compareOffsetByte)
+}
+
+// LookupLineColumn returns the deepest node containing the line and column, including which part of the node it was
+// found in.
+func (oi *OffsetIndex) LookupLineColumn(lc cursorio.TextLineColumn) (OffsetIndexMatch, bool) {
+	return oi.lookup(cursorio.TextOffset{LineColumn: lc}, compareOffsetLineColumn)
+}
+
+// FindOverlapping returns every node whose (outer) byte offsets overlap the range, ordered by their starting offset.
+// An empty range is treated as a single position.
+func (oi *OffsetIndex) FindOverlapping(r cursorio.TextOffsetRange) []*html.Node {
+	var nodes []*html.Node
+
+	oi.visit(0, len(oi.entries), r.From, r.Until, compareOffsetByte(r.From, r.Until) >= 0, compareOffsetByte, func(e *offsetIndexEntry) {
+		nodes = append(nodes, e.node)
+	})
+
+	return nodes
+}
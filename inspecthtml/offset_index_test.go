@@ -0,0 +1,91 @@
+package inspecthtml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func TestOffsetIndexLookupOffset(t *testing.T) {
+	input := "<html><body>\n<p class=\"lead\">hello <b>world</b></p>\n</body></html>"
+
+	_, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	index := documentOffsets.GetOffsetIndex()
+
+	for _, tc := range []struct {
+		offset     cursorio.TextOffset
+		expectData string
+		expectKind OffsetIndexMatchKind
+		expectAttr int
+	}{
+		{cursorio.TextOffset{Byte: 0, LineColumn: cursorio.TextLineColumn{0, 0}}, "html", OffsetIndexMatchNode, -1},
+		{cursorio.TextOffset{Byte: 2, LineColumn: cursorio.TextLineColumn{0, 2}}, "html", OffsetIndexMatchTagName, -1},
+		{cursorio.TextOffset{Byte: 11, LineColumn: cursorio.TextLineColumn{0, 11}}, "body", OffsetIndexMatchNode, -1},
+		{cursorio.TextOffset{Byte: 12, LineColumn: cursorio.TextLineColumn{0, 12}}, "\n", OffsetIndexMatchNode, -1},
+		{cursorio.TextOffset{Byte: 14, LineColumn: cursorio.TextLineColumn{1, 1}}, "p", OffsetIndexMatchTagName, -1},
+		{cursorio.TextOffset{Byte: 17, LineColumn: cursorio.TextLineColumn{1, 4}}, "p", OffsetIndexMatchAttrKey, 0},
+		{cursorio.TextOffset{Byte: 23, LineColumn: cursorio.TextLineColumn{1, 10}}, "p", OffsetIndexMatchAttrValue, 0},
+		{cursorio.TextOffset{Byte: 30, LineColumn: cursorio.TextLineColumn{1, 17}}, "hello ", OffsetIndexMatchNode, -1},
+		{cursorio.TextOffset{Byte: 40, LineColumn: cursorio.TextLineColumn{1, 27}}, "world", OffsetIndexMatchNode, -1},
+		{cursorio.TextOffset{Byte: 46, LineColumn: cursorio.TextLineColumn{1, 33}}, "b", OffsetIndexMatchEndTag, -1},
+		{cursorio.TextOffset{Byte: 48, LineColumn: cursorio.TextLineColumn{1, 35}}, "p", OffsetIndexMatchEndTag, -1},
+	} {
+		for _, lookup := range []func() (OffsetIndexMatch, bool){
+			func() (OffsetIndexMatch, bool) {
+				return index.LookupOffset(tc.offset)
+			},
+			func() (OffsetIndexMatch, bool) {
+				return index.LookupLineColumn(tc.offset.LineColumn)
+			},
+		} {
+			match, ok := lookup()
+			if !ok {
+				t.Errorf("offset %v: expected match", tc.offset)
+			} else if _a, _e := match.Node.Data, tc.expectData; _a != _e {
+				t.Errorf("offset %v: node: expected %q, got %q", tc.offset, _e, _a)
+			} else if _a, _e := match.Kind, tc.expectKind; _a != _e {
+				t.Errorf("offset %v: kind: expected %v, got %v", tc.offset, _e, _a)
+			} else if _a, _e := match.AttrIndex, tc.expectAttr; _a != _e {
+				t.Errorf("offset %v: attr: expected %v, got %v", tc.offset, _e, _a)
+			}
+		}
+	}
+
+	if _, ok := index.LookupOffset(cursorio.TextOffset{Byte: 1024}); ok {
+		t.Errorf("expected no match after end of document")
+	}
+}
+
+func TestOffsetIndexFindOverlapping(t *testing.T) {
+	// html and body are implied, so they have no metadata
+	input := "<ul><li>one</li><li>two</li><li>three</li></ul>"
+
+	_, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var found []string
+
+	for _, n := range documentOffsets.GetOffsetIndex().FindOverlapping(cursorio.TextOffsetRange{
+		From:  cursorio.TextOffset{Byte: 14},
+		Until: cursorio.TextOffset{Byte: 22},
+	}) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Li {
+			found = append(found, "li")
+		} else {
+			found = append(found, n.Data)
+		}
+	}
+
+	if _a, _e := strings.Join(found, ","), "ul,li,li,two"; _a != _e {
+		t.Errorf("expected %v, got %v", _e, _a)
+	}
+}
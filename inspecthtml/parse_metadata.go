@@ -1,45 +1,330 @@
 package inspecthtml
 
 import (
+	"sync"
+
 	"github.com/dpb587/cursorio-go/cursorio"
 	"golang.org/x/net/html"
 )
 
+// ParseMetadata is the metadata of a parsed document or fragment. It is not modified after parsing, so it is safe for
+// concurrent use by multiple goroutines.
 type ParseMetadata struct {
-	metadataByNode map[*html.Node]*NodeMetadata
+	metadataByNode   map[*html.Node]*NodeMetadata
+	provenanceByNode map[*html.Node]NodeProvenance
+	unmatchedEndTags []UnmatchedEndTag
+	diagnostics      []Diagnostic
+	syntaxTree       *SyntaxTree
+	source           []byte
+	sourceOffset     cursorio.TextOffset
+
+	offsetIndex     *OffsetIndex
+	offsetIndexOnce sync.Once
+
+	columnIndex     [ColumnUnitGrapheme + 1]*columnIndex
+	columnIndexOnce [ColumnUnitGrapheme + 1]sync.Once
+}
+
+// UnmatchedEndTag is an end tag in source which did not close an element.
+type UnmatchedEndTag struct {
+	// TagName is the lowercase tag name.
+	TagName string
+	Offsets cursorio.TextOffsetRange
+}
+
+// GetDiagnostics returns the issues encountered while parsing, ordered by offset.
+func (po *ParseMetadata) GetDiagnostics() []Diagnostic {
+	return po.diagnostics
+}
+
+// GetUnmatchedEndTags returns the end tags in source which did not close an element, ordered by offset. For example,
+// stray end tags, end tags of elements which were already closed, or end tags which upstream converted into an element
+// (e.g. `</p>` without an open p).
+func (po *ParseMetadata) GetUnmatchedEndTags() []UnmatchedEndTag {
+	return po.unmatchedEndTags
+}
+
+// GetSyntaxTree returns the lossless concrete syntax tree of the source, or nil unless it was enabled with
+// ParserConfig.SetSyntaxTree.
+func (po *ParseMetadata) GetSyntaxTree() *SyntaxTree {
+	return po.syntaxTree
+}
+
+// GetOffsetIndex returns an index for looking up nodes by offset. It is built on first use.
+func (po *ParseMetadata) GetOffsetIndex() *OffsetIndex {
+	po.offsetIndexOnce.Do(func() {
+		po.offsetIndex = newOffsetIndex(po)
+	})
+
+	return po.offsetIndex
 }
 
 func (po *ParseMetadata) GetNodeMetadata(n *html.Node) (*NodeMetadata, bool) {
 	v, ok := po.metadataByNode[n]
-	if !ok {
-		return nil, false
-	}
-
-	if n.Type == html.ElementNode && !v.TagSelfClosing && v.EndTagTokenOffsets == nil {
-		// handled on-demand assuming less frequently needed than re-traverse entire tree after rebuildNode
-		if n.LastChild != nil {
-			if po.metadataByNode[n.LastChild] != nil {
-				v.EndTagTokenOffsets = &cursorio.TextOffsetRange{
-					From:  po.metadataByNode[n.LastChild].TokenOffsets.Until,
-					Until: po.metadataByNode[n.LastChild].TokenOffsets.Until,
-				}
-			}
-		} else if n.NextSibling != nil {
-			if po.metadataByNode[n.NextSibling] != nil {
-				v.EndTagTokenOffsets = &cursorio.TextOffsetRange{
-					From:  po.metadataByNode[n.NextSibling].TokenOffsets.From,
-					Until: po.metadataByNode[n.NextSibling].TokenOffsets.From,
-				}
+
+	return v, ok
+}
+
+// GetNodeProvenance returns how the node relates to its source. Nodes without metadata are implied.
+func (po *ParseMetadata) GetNodeProvenance(n *html.Node) NodeProvenance {
+	if v, ok := po.provenanceByNode[n]; ok {
+		return v
+	}
+
+	return NodeProvenanceImplied
+}
+
+// finalize resolves the logical end tags of elements which were closed without one and the provenance of nodes, so the
+// metadata is no longer modified after parsing. The eof offset is used for elements closed by the end of a document or
+// fragment.
+func (po *ParseMetadata) finalize(root *html.Node, eof cursorio.TextOffset) {
+	r := &endTagResolver{
+		metadataByNode: po.metadataByNode,
+		resolving:      map[*html.Node]bool{},
+		eof:            eof,
+	}
+
+	var visitNode func(n *html.Node)
+	visitNode = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			r.resolve(n)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visitNode(c)
+		}
+	}
+
+	visitNode(root)
+
+	c := &provenanceClassifier{
+		metadataByNode:   po.metadataByNode,
+		provenanceByNode: make(map[*html.Node]NodeProvenance, len(po.metadataByNode)),
+		originals:        map[*NodeMetadata]*html.Node{},
+	}
+
+	c.visitOriginals(root)
+	c.classifyChildren(root, nil)
+
+	po.provenanceByNode = c.provenanceByNode
+}
+
+type endTagResolver struct {
+	metadataByNode map[*html.Node]*NodeMetadata
+	resolving      map[*html.Node]bool
+	eof            cursorio.TextOffset
+}
+
+// resolve returns the end tag offsets of an element, resolving a logical, zero-length end tag if it was implied. The
+// end is based on its last child, otherwise its next sibling, otherwise the end of its parent. It returns nil for
+// elements without metadata, void and self-closing elements (which never have an end tag), and elements already being
+// resolved (i.e. a cycle through an implicitly closed parent).
+func (r *endTagResolver) resolve(n *html.Node) *cursorio.TextOffsetRange {
+	v := r.metadataByNode[n]
+	if v == nil || v.TagSelfClosing || isVoidElement(n) || v.EndTagTokenOffsets != nil || r.resolving[n] {
+		if v == nil {
+			return nil
+		}
+
+		return v.EndTagTokenOffsets
+	}
+
+	r.resolving[n] = true
+	defer delete(r.resolving, n)
+
+	var at *cursorio.TextOffset
+
+	if n.LastChild != nil {
+		at = r.outerUntil(n.LastChild)
+	} else if n.NextSibling != nil {
+		at = r.outerFrom(n.NextSibling)
+	}
+
+	v.ImpliedEndTagReason = r.impliedReason(n)
+
+	if at == nil && n.Parent != nil {
+		if r.metadataByNode[n.Parent] != nil {
+			if parentEnd := r.resolve(n.Parent); parentEnd != nil {
+				at = &parentEnd.From
 			}
-		} else if n.Parent != nil {
-			if po.metadataByNode[n.Parent] != nil {
-				v.EndTagTokenOffsets = &cursorio.TextOffsetRange{
-					From:  po.metadataByNode[n.Parent].EndTagTokenOffsets.From,
-					Until: po.metadataByNode[n.Parent].EndTagTokenOffsets.From,
-				}
+		} else if n.Parent.Type == html.DocumentNode {
+			at = &r.eof
+		}
+	}
+
+	if at == nil || at.Byte < v.TokenOffsets.Until.Byte {
+		// an empty element at the end of an implicitly closed parent, or an element moved before its parent's end (e.g.
+		// into head); end immediately after its start tag
+		at = &v.TokenOffsets.Until
+	}
+
+	v.EndTagTokenOffsets = &cursorio.TextOffsetRange{
+		From:  *at,
+		Until: *at,
+	}
+
+	return v.EndTagTokenOffsets
+}
+
+// impliedReason returns why an element without an end tag was closed. When closed along with an ancestor which was
+// itself closed by the end of input, the reason is inherited from that ancestor. It does not depend on whether the
+// ancestors were already resolved.
+func (r *endTagResolver) impliedReason(n *html.Node) ImpliedEndTagReason {
+	if n.NextSibling != nil {
+		return ImpliedEndTagReasonSibling
+	}
+
+	for p := n.Parent; p != nil; p = p.Parent {
+		v := r.metadataByNode[p]
+		if v == nil {
+			continue
+		} else if v.TagSelfClosing || (v.EndTagTokenOffsets != nil && v.ImpliedEndTagReason == 0) {
+			return ImpliedEndTagReasonParent
+		} else if r.impliedReason(p) == ImpliedEndTagReasonEOF {
+			return ImpliedEndTagReasonEOF
+		}
+
+		return ImpliedEndTagReasonParent
+	}
+
+	return ImpliedEndTagReasonEOF
+}
+
+// outerUntil returns the end offset of a node, or of its last descendant with metadata if it has none.
+func (r *endTagResolver) outerUntil(n *html.Node) *cursorio.TextOffset {
+	if v := r.metadataByNode[n]; v != nil {
+		if n.Type == html.ElementNode {
+			if end := r.resolve(n); end != nil {
+				return &end.Until
 			}
 		}
+
+		return &v.TokenOffsets.Until
 	}
 
-	return v, ok
+	for c := n.LastChild; c != nil; c = c.PrevSibling {
+		if until := r.outerUntil(c); until != nil {
+			return until
+		}
+	}
+
+	return nil
+}
+
+// outerFrom returns the start offset of a node, or of its first descendant with metadata if it has none.
+func (r *endTagResolver) outerFrom(n *html.Node) *cursorio.TextOffset {
+	if v := r.metadataByNode[n]; v != nil {
+		return &v.TokenOffsets.From
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if from := r.outerFrom(c); from != nil {
+			return from
+		}
+	}
+
+	return nil
+}
+
+type provenanceClassifier struct {
+	metadataByNode   map[*html.Node]*NodeMetadata
+	provenanceByNode map[*html.Node]NodeProvenance
+	originals        map[*NodeMetadata]*html.Node
+}
+
+// visitOriginals finds the first node, in document order, of all metadata. Any other nodes sharing the metadata are
+// clones.
+func (c *provenanceClassifier) visitOriginals(n *html.Node) {
+	if v := c.metadataByNode[n]; v != nil && c.originals[v] == nil {
+		c.originals[v] = n
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.visitOriginals(child)
+	}
+}
+
+// classifyChildren classifies the children of a node. The parent is the nearest ancestor whose source should contain
+// the children, or nil if unknown.
+func (c *provenanceClassifier) classifyChildren(n *html.Node, parent *NodeMetadata) {
+	// the minimum source offset of each child and its following siblings, in reverse
+	var followingFrom []*cursorio.TextOffset
+
+	for child := n.LastChild; child != nil; child = child.PrevSibling {
+		var from *cursorio.TextOffset
+		if len(followingFrom) > 0 {
+			from = followingFrom[len(followingFrom)-1]
+		}
+
+		if childFrom := c.outerFrom(child); childFrom != nil && (from == nil || childFrom.Byte < from.Byte) {
+			from = childFrom
+		}
+
+		followingFrom = append(followingFrom, from)
+	}
+
+	var parentInner *cursorio.TextOffsetRange
+	if parent != nil {
+		parentInner = parent.GetInnerOffsets()
+	}
+
+	var preceding *NodeMetadata
+
+	for child, i := n.FirstChild, len(followingFrom)-2; child != nil; child, i = child.NextSibling, i-1 {
+		v := c.metadataByNode[child]
+		if v == nil {
+			c.classifyChildren(child, parent)
+
+			continue
+		} else if c.originals[v] != child {
+			c.provenanceByNode[child] = NodeProvenanceCloned
+
+			// the source of a clone does not contain its children
+			c.classifyChildren(child, nil)
+
+			continue
+		}
+
+		if v.Provenance == 0 {
+			v.Provenance = NodeProvenanceExplicit
+		}
+
+		if len(v.MergedStartTags) > 0 {
+			v.Provenance = NodeProvenanceMerged
+		}
+
+		from := v.TokenOffsets.From
+
+		if parentInner != nil && (from.Byte < parentInner.From.Byte || from.Byte >= parentInner.Until.Byte) {
+			// outside of its parent
+			v.Provenance = NodeProvenanceReparented
+		} else if preceding != nil && from.Byte >= preceding.TokenOffsets.From.Byte && from.Byte < preceding.GetOuterOffsets().Until.Byte {
+			// within a preceding sibling (e.g. adoption agency algorithm)
+			v.Provenance = NodeProvenanceReparented
+		} else if i >= 0 && followingFrom[i] != nil && followingFrom[i].Byte < from.Byte {
+			// after a following sibling (e.g. foster parenting)
+			v.Provenance = NodeProvenanceReparented
+		}
+
+		c.provenanceByNode[child] = v.Provenance
+		preceding = v
+
+		c.classifyChildren(child, v)
+	}
+}
+
+// outerFrom returns the start offset of a node, or of its first descendant with metadata if it has none. Clones are
+// ignored since they are not where their source was.
+func (c *provenanceClassifier) outerFrom(n *html.Node) *cursorio.TextOffset {
+	if v := c.metadataByNode[n]; v != nil && c.originals[v] == n {
+		return &v.TokenOffsets.From
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if from := c.outerFrom(child); from != nil {
+			return from
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,210 @@
+package inspecthtml
+
+import (
+	"github.com/dpb587/cursorio-go/cursorio"
+)
+
+// getColumnIndex returns the column index of the unit, which is built on first use. It returns false if the source was
+// not retained or the unit is unknown.
+func (po *ParseMetadata) getColumnIndex(unit ColumnUnit) (*columnIndex, bool) {
+	if po.source == nil || unit < ColumnUnitByte || unit > ColumnUnitGrapheme {
+		return nil, false
+	}
+
+	po.columnIndexOnce[unit].Do(func() {
+		po.columnIndex[unit] = newColumnIndex(po.source, po.sourceOffset, unit)
+	})
+
+	return po.columnIndex[unit], true
+}
+
+// ConvertOffset returns the offset with its line/column counted in the unit. Only the byte of the offset is used, so
+// it may have been converted before. It requires ParserConfig.SetRetainSource, and returns false if the source was not
+// retained or the offset is not within it.
+func (po *ParseMetadata) ConvertOffset(v cursorio.TextOffset, unit ColumnUnit) (cursorio.TextOffset, bool) {
+	ci, ok := po.getColumnIndex(unit)
+	if !ok {
+		return cursorio.TextOffset{}, false
+	}
+
+	return ci.offset(v.Byte)
+}
+
+// ConvertOffsetRange is the same as ConvertOffset, but for both ends of a range.
+func (po *ParseMetadata) ConvertOffsetRange(v cursorio.TextOffsetRange, unit ColumnUnit) (cursorio.TextOffsetRange, bool) {
+	c, ok := po.newColumnConverter(unit)
+	if !ok {
+		return cursorio.TextOffsetRange{}, false
+	}
+
+	c.offsetRange(&v)
+
+	return v, c.ok
+}
+
+// ConvertLineColumn returns the offset of a line/column which was counted in the unit (e.g. a position from an LSP
+// client), such as for use with OffsetIndex.LookupOffset. A column within a unit is at the start of the unit. It
+// returns false if the source was not retained or the line/column is not within it.
+func (po *ParseMetadata) ConvertLineColumn(lc cursorio.TextLineColumn, unit ColumnUnit) (cursorio.TextOffset, bool) {
+	ci, ok := po.getColumnIndex(unit)
+	if !ok {
+		return cursorio.TextOffset{}, false
+	}
+
+	return ci.lineColumn(lc)
+}
+
+// ConvertNodeMetadata returns a copy of the metadata with the line/column of every range counted in the unit,
+// including its attributes, text segments, and merged start tags. It returns false if any range could not be
+// converted (see ConvertOffset).
+func (po *ParseMetadata) ConvertNodeMetadata(v *NodeMetadata, unit ColumnUnit) (*NodeMetadata, bool) {
+	c, ok := po.newColumnConverter(unit)
+	if !ok {
+		return nil, false
+	}
+
+	nv := c.nodeMetadata(v)
+	if !c.ok {
+		return nil, false
+	}
+
+	return nv, true
+}
+
+// ConvertNodeAttributeMetadata is the same as ConvertNodeMetadata, but for the metadata of an attribute.
+func (po *ParseMetadata) ConvertNodeAttributeMetadata(v *NodeAttributeMetadata, unit ColumnUnit) (*NodeAttributeMetadata, bool) {
+	c, ok := po.newColumnConverter(unit)
+	if !ok {
+		return nil, false
+	}
+
+	var mergedStartTag *NodeMetadata
+
+	if v.MergedStartTag != nil {
+		mergedStartTag = c.nodeMetadata(v.MergedStartTag)
+	}
+
+	nv := c.attributeMetadata(v, mergedStartTag)
+	if !c.ok {
+		return nil, false
+	}
+
+	return nv, true
+}
+
+func (po *ParseMetadata) newColumnConverter(unit ColumnUnit) (*columnConverter, bool) {
+	ci, ok := po.getColumnIndex(unit)
+	if !ok {
+		return nil, false
+	}
+
+	return &columnConverter{
+		ci: ci,
+		ok: true,
+	}, true
+}
+
+// columnConverter deeply copies metadata while converting its offsets. Any offset which fails is left as-is and ok is
+// set to false.
+type columnConverter struct {
+	ci *columnIndex
+	ok bool
+}
+
+func (c *columnConverter) offset(v *cursorio.TextOffset) {
+	if nv, ok := c.ci.offset(v.Byte); ok {
+		*v = nv
+	} else {
+		c.ok = false
+	}
+}
+
+func (c *columnConverter) offsetRange(v *cursorio.TextOffsetRange) {
+	c.offset(&v.From)
+	c.offset(&v.Until)
+}
+
+func (c *columnConverter) offsetRangePtr(v *cursorio.TextOffsetRange) *cursorio.TextOffsetRange {
+	if v == nil {
+		return nil
+	}
+
+	nv := *v
+	c.offsetRange(&nv)
+
+	return &nv
+}
+
+func (c *columnConverter) textSegment(v NodeTextSegment) NodeTextSegment {
+	c.offsetRange(&v.Offsets)
+
+	if v.Replacements != nil {
+		replacements := make([]NodeTextReplacement, len(v.Replacements))
+
+		for i, r := range v.Replacements {
+			c.offsetRange(&r.Offsets)
+			replacements[i] = r
+		}
+
+		v.Replacements = replacements
+	}
+
+	return v
+}
+
+func (c *columnConverter) nodeMetadata(v *NodeMetadata) *NodeMetadata {
+	nv := *v
+
+	c.offsetRange(&nv.TokenOffsets)
+	nv.TagNameOffsets = c.offsetRangePtr(v.TagNameOffsets)
+	nv.EndTagTokenOffsets = c.offsetRangePtr(v.EndTagTokenOffsets)
+	nv.DoctypeNameOffsets = c.offsetRangePtr(v.DoctypeNameOffsets)
+	nv.DoctypePublicIdentifierOffsets = c.offsetRangePtr(v.DoctypePublicIdentifierOffsets)
+	nv.DoctypeSystemIdentifierOffsets = c.offsetRangePtr(v.DoctypeSystemIdentifierOffsets)
+
+	var mergedStartTags map[*NodeMetadata]*NodeMetadata
+
+	if v.MergedStartTags != nil {
+		nv.MergedStartTags = make([]*NodeMetadata, len(v.MergedStartTags))
+		mergedStartTags = make(map[*NodeMetadata]*NodeMetadata, len(v.MergedStartTags))
+
+		for i, merged := range v.MergedStartTags {
+			nv.MergedStartTags[i] = c.nodeMetadata(merged)
+			mergedStartTags[merged] = nv.MergedStartTags[i]
+		}
+	}
+
+	if v.TagAttr != nil {
+		nv.TagAttr = make([]*NodeAttributeMetadata, len(v.TagAttr))
+
+		for i, attr := range v.TagAttr {
+			nv.TagAttr[i] = c.attributeMetadata(attr, mergedStartTags[attr.MergedStartTag])
+		}
+	}
+
+	if v.TextSegments != nil {
+		nv.TextSegments = make([]NodeTextSegment, len(v.TextSegments))
+
+		for i, segment := range v.TextSegments {
+			nv.TextSegments[i] = c.textSegment(segment)
+		}
+	}
+
+	return &nv
+}
+
+// attributeMetadata converts the attribute, which refers to the already converted start tag it was merged from.
+func (c *columnConverter) attributeMetadata(v *NodeAttributeMetadata, mergedStartTag *NodeMetadata) *NodeAttributeMetadata {
+	nv := *v
+
+	c.offsetRange(&nv.KeyOffsets)
+	nv.ValueOffsets = c.offsetRangePtr(v.ValueOffsets)
+	nv.MergedStartTag = mergedStartTag
+
+	if v.ValueSegment != nil {
+		segment := c.textSegment(*v.ValueSegment)
+		nv.ValueSegment = &segment
+	}
+
+	return &nv
+}
@@ -0,0 +1,120 @@
+package inspecthtml
+
+import (
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+// GetSource returns the retained source, or nil if it was not retained. It must not be modified.
+func (po *ParseMetadata) GetSource() []byte {
+	return po.source
+}
+
+// GetRawSource returns the retained source of the offsets. It returns false if the source was not retained or the
+// offsets are out of range. The result refers to the retained source, so it must not be modified.
+func (po *ParseMetadata) GetRawSource(offsets cursorio.TextOffsetRange) ([]byte, bool) {
+	if po.source == nil {
+		return nil, false
+	}
+
+	from, until := offsets.From.Byte-po.sourceOffset.Byte, offsets.Until.Byte-po.sourceOffset.Byte
+	if from < 0 || until < from || until > int64(len(po.source)) {
+		return nil, false
+	}
+
+	return po.source[from:until:until], true
+}
+
+// GetNodeRawStartTag returns the source of the start tag of an element. For other nodes, it is the source of their
+// token (e.g. a comment).
+func (po *ParseMetadata) GetNodeRawStartTag(n *html.Node) ([]byte, bool) {
+	v, ok := po.metadataByNode[n]
+	if !ok {
+		return nil, false
+	}
+
+	return po.GetRawSource(v.TokenOffsets)
+}
+
+// GetNodeRawEndTag returns the source of the end tag of an element. It returns false if the end tag was not in source
+// (e.g. void or implicitly closed elements).
+func (po *ParseMetadata) GetNodeRawEndTag(n *html.Node) ([]byte, bool) {
+	v, ok := po.metadataByNode[n]
+	if !ok || v.EndTagTokenOffsets == nil || v.ImpliedEndTagReason != 0 {
+		return nil, false
+	}
+
+	return po.GetRawSource(*v.EndTagTokenOffsets)
+}
+
+// GetNodeRawOuterHTML returns the source from the start of the node through its end tag, if any. Since nodes may have
+// been moved by the DOM Processor, the source may include content which is not a descendant of the node.
+func (po *ParseMetadata) GetNodeRawOuterHTML(n *html.Node) ([]byte, bool) {
+	v, ok := po.metadataByNode[n]
+	if !ok {
+		return nil, false
+	}
+
+	return po.GetRawSource(v.GetOuterOffsets())
+}
+
+// GetNodeRawInnerHTML returns the source between the start and end tag of an element. It returns false for nodes
+// without an inner range (e.g. void elements).
+func (po *ParseMetadata) GetNodeRawInnerHTML(n *html.Node) ([]byte, bool) {
+	v, ok := po.metadataByNode[n]
+	if !ok || !v.HasInner() {
+		return nil, false
+	}
+
+	return po.GetRawSource(*v.GetInnerOffsets())
+}
+
+// GetNodeRawTagName returns the tag name of an element in its original casing.
+func (po *ParseMetadata) GetNodeRawTagName(n *html.Node) ([]byte, bool) {
+	v, ok := po.metadataByNode[n]
+	if !ok || v.TagNameOffsets == nil {
+		return nil, false
+	}
+
+	return po.GetRawSource(*v.TagNameOffsets)
+}
+
+// GetNodeRawAttrKey returns the key of an attribute (by its index within html.Node.Attr) in its original casing.
+func (po *ParseMetadata) GetNodeRawAttrKey(n *html.Node, attrIdx int) ([]byte, bool) {
+	attr, ok := po.getNodeAttrMetadata(n, attrIdx)
+	if !ok {
+		return nil, false
+	}
+
+	return po.GetRawSource(attr.KeyOffsets)
+}
+
+// GetNodeRawAttrValue returns the value of an attribute (by its index within html.Node.Attr) before it was decoded,
+// excluding any quotes. It returns false if the attribute did not have a value in source.
+func (po *ParseMetadata) GetNodeRawAttrValue(n *html.Node, attrIdx int) ([]byte, bool) {
+	attr, ok := po.getNodeAttrMetadata(n, attrIdx)
+	if !ok || attr.ValueSegment == nil {
+		return nil, false
+	}
+
+	return po.GetRawSource(attr.ValueSegment.Offsets)
+}
+
+// GetNodeRawAttrQuotedValue is the same as GetNodeRawAttrValue, but includes any quotes.
+func (po *ParseMetadata) GetNodeRawAttrQuotedValue(n *html.Node, attrIdx int) ([]byte, bool) {
+	attr, ok := po.getNodeAttrMetadata(n, attrIdx)
+	if !ok || attr.ValueOffsets == nil {
+		return nil, false
+	}
+
+	return po.GetRawSource(*attr.ValueOffsets)
+}
+
+func (po *ParseMetadata) getNodeAttrMetadata(n *html.Node, attrIdx int) (*NodeAttributeMetadata, bool) {
+	v, ok := po.metadataByNode[n]
+	if !ok || attrIdx < 0 || attrIdx >= len(v.TagAttr) || attrIdx >= len(n.Attr) {
+		return nil, false
+	}
+
+	return v.TagAttr[attrIdx], true
+}
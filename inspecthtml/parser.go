@@ -1,7 +1,11 @@
 package inspecthtml
 
 import (
+	"fmt"
 	"io"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/dpb587/cursorio-go/cursorio"
 	"golang.org/x/net/html"
@@ -15,25 +19,35 @@ type ParserOption interface {
 type Parser struct {
 	r       *parserReader
 	rActual io.Reader
+	rSource io.Reader
 
-	parseRoot *html.Node
-	parseErr  error
-	offsets   *ParseMetadata
+	tokenizerInterceptor func(t *html.Tokenizer) *html.Tokenizer
+	treeDiagnostics      bool
+
+	// leadingMarkerParent is a pre, listing, or textarea whose first children were markers, so upstream did not trim
+	// the leading newline of the text which followed them
+	leadingMarkerParent *html.Node
+
+	parseRoot  *html.Node
+	parseNodes []*html.Node
+	parseErr   error
+	offsets    *ParseMetadata
 }
 
 func NewParser(r io.Reader, opts ...ParserOption) *Parser {
 	p := &Parser{
 		r: &parserReader{
-			tokenizer:              html.NewTokenizer(r),
-			nodeTagByKey:           map[string]*NodeMetadata{},
-			nodeSwapByKey:          map[string]parserNodeSwap{},
-			endTagOffsetRangeByKey: map[string]cursorio.TextOffsetRange{},
-			wsOffsetRangeByKey:     map[string]cursorio.TextOffsetRange{},
+			tokenizer: html.NewTokenizer(r),
+			scripting: true,
 		},
 	}
 
 	cfg := &ParserConfig{
-		initialOffset: &cursorio.TextOffset{},
+		initialOffset:        &cursorio.TextOffset{},
+		tokenizerDiagnostics: new(bool),
+		treeDiagnostics:      new(bool),
+		syntaxTree:           new(bool),
+		retainSource:         new(bool),
 	}
 
 	for _, opt := range opts {
@@ -41,9 +55,16 @@ func NewParser(r io.Reader, opts ...ParserOption) *Parser {
 	}
 
 	p.r.doc = cursorio.NewTextWriter(*cfg.initialOffset)
-
-	if cfg.tokenizerInterceptor != nil {
-		p.r.tokenizer = cfg.tokenizerInterceptor(p.r.tokenizer)
+	p.r.tokenizerDiagnostics = *cfg.tokenizerDiagnostics
+	p.r.syntaxTree = *cfg.syntaxTree
+	p.r.retainSource = *cfg.retainSource
+	p.r.sourceOffset = *cfg.initialOffset
+	p.treeDiagnostics = *cfg.treeDiagnostics
+	p.rSource = r
+	p.tokenizerInterceptor = cfg.tokenizerInterceptor
+
+	if p.tokenizerInterceptor != nil {
+		p.r.tokenizer = p.tokenizerInterceptor(p.r.tokenizer)
 	}
 
 	if cfg.readerInterceptor != nil {
@@ -68,6 +89,8 @@ func (p *Parser) Parse() (*html.Node, *ParseMetadata, error) {
 
 func (p *Parser) ParseWithOptions(opts ...html.ParseOption) (*html.Node, *ParseMetadata, error) {
 	if p.parseRoot == nil && p.parseErr == nil {
+		p.r.scripting = isScriptingEnabled(opts...)
+
 		p.parseRoot, p.parseErr = html.ParseWithOptions(p.rActual, opts...)
 		if p.parseErr == nil {
 			p.rebuild(p.parseRoot)
@@ -77,12 +100,109 @@ func (p *Parser) ParseWithOptions(opts ...html.ParseOption) (*html.Node, *ParseM
 	return p.parseRoot, p.offsets, p.parseErr
 }
 
+func (p *Parser) ParseFragment(context *html.Node) ([]*html.Node, *ParseMetadata, error) {
+	return p.ParseFragmentWithOptions(context)
+}
+
+func (p *Parser) ParseFragmentWithOptions(context *html.Node, opts ...html.ParseOption) ([]*html.Node, *ParseMetadata, error) {
+	if p.offsets == nil && p.parseErr == nil {
+		p.r.scripting = isScriptingEnabled(opts...)
+		p.r.fragment = true
+
+		if context != nil && context.Type == html.ElementNode && context.Namespace != "" {
+			attrKeys := make([]string, len(context.Attr))
+			attrValues := make([]string, len(context.Attr))
+
+			for i, attr := range context.Attr {
+				attrKeys[i], attrValues[i] = attr.Key, attr.Val
+			}
+
+			foreignContext := newParserForeignElement(strings.ToLower(context.Data), context.Namespace, attrKeys, attrValues)
+			p.r.foreignContext = &foreignContext
+		}
+
+		if context != nil && context.Type == html.ElementNode && context.Namespace == "" {
+			// match the tokenizer state which upstream will use for the fragment (e.g. raw text of a textarea)
+			p.r.tokenizer = html.NewTokenizerFragment(p.rSource, context.Data)
+			if p.tokenizerInterceptor != nil {
+				p.r.tokenizer = p.tokenizerInterceptor(p.r.tokenizer)
+			}
+
+			p.r.nodeRawTextMode = isRawTextAtom(context.DataAtom)
+		}
+
+		p.parseNodes, p.parseErr = html.ParseFragmentWithOptions(p.rActual, context, opts...)
+		if p.parseErr == nil {
+			p.parseNodes = p.rebuildFragment(p.parseNodes)
+		}
+	}
+
+	return p.parseNodes, p.offsets, p.parseErr
+}
+
+func (p *Parser) rebuildFragment(nodes []*html.Node) []*html.Node {
+	// temporarily reattach the detached nodes so siblings and implied end tags can be resolved
+	root := &html.Node{
+		Type: html.DocumentNode,
+	}
+
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+
+	p.rebuild(root)
+
+	var result []*html.Node
+
+	for c := root.FirstChild; c != nil; {
+		next := c.NextSibling
+		root.RemoveChild(c)
+		result = append(result, c)
+		c = next
+	}
+
+	return result
+}
+
 func (p *Parser) rebuild(root *html.Node) {
 	p.offsets = &ParseMetadata{
-		metadataByNode: map[*html.Node]*NodeMetadata{},
+		metadataByNode: make(map[*html.Node]*NodeMetadata, len(p.r.nodes)),
 	}
 
 	p.rebuildNode(root)
+	p.rebuildMergedStartTags(root)
+	p.rebuildUnmatchedEndTags()
+	p.offsets.finalize(root, p.r.doc.GetTextOffset())
+
+	if p.treeDiagnostics {
+		p.rebuildTreeDiagnostics(root)
+	}
+
+	if p.r.syntaxTree {
+		p.rebuildSyntaxTree(root)
+	}
+
+	if p.r.retainSource {
+		p.offsets.source = p.r.source
+		p.offsets.sourceOffset = p.r.sourceOffset
+
+		if p.offsets.source == nil {
+			// still retained, although the input was empty
+			p.offsets.source = []byte{}
+		}
+	}
+
+	p.offsets.diagnostics = make([]Diagnostic, len(p.r.diagnostics))
+	copy(p.offsets.diagnostics, p.r.diagnostics)
+
+	sort.SliceStable(p.offsets.diagnostics, func(i, j int) bool {
+		return p.offsets.diagnostics[i].Offsets.From.Byte < p.offsets.diagnostics[j].Offsets.From.Byte
+	})
+}
+
+// isLeadingNewlineAtom reports whether a leading newline of the element's text is ignored.
+func isLeadingNewlineAtom(a atom.Atom) bool {
+	return a == atom.Pre || a == atom.Listing || a == atom.Textarea
 }
 
 func (p *Parser) rebuildNode(n *html.Node) {
@@ -91,18 +211,26 @@ func (p *Parser) rebuildNode(n *html.Node) {
 		var from int
 		var isTextRef bool
 		var expanded []*html.Node
+		var expandedKeys []int // -1 for text which was not smuggled (i.e. whitespace)
 
 		appendTextRef := func(i int) {
-			swap := p.r.nodeSwapByKey[n.Data[from+1:i]]
+			key := p.r.lookupNodeIndex(n.Data[from+1 : i])
+
+			var swap parserNode
+			if key > -1 {
+				swap = p.r.nodes[key]
+			}
 
 			inject := &html.Node{
 				Type: html.TextNode,
-				Data: swap.original,
+				Data: swap.data,
 			}
 
 			expanded = append(expanded, inject)
-			p.offsets.metadataByNode[inject] = &NodeMetadata{
-				TokenOffsets: swap.offsetRange,
+			expandedKeys = append(expandedKeys, key)
+
+			if swap.metadata != nil {
+				p.offsets.metadataByNode[inject] = swap.metadata
 			}
 		}
 
@@ -126,6 +254,7 @@ func (p *Parser) rebuildNode(n *html.Node) {
 					}
 
 					expanded = append(expanded, inject)
+					expandedKeys = append(expandedKeys, -1)
 				}
 
 				from = i
@@ -142,6 +271,7 @@ func (p *Parser) rebuildNode(n *html.Node) {
 			}
 
 			expanded = append(expanded, inject)
+			expandedKeys = append(expandedKeys, -1)
 		}
 
 		if len(expanded) > 0 {
@@ -170,14 +300,27 @@ func (p *Parser) rebuildNode(n *html.Node) {
 				}
 			}
 
+			for i, c := range expanded {
+				if expandedKeys[i] > -1 {
+					continue
+				} else if i > 0 && expandedKeys[i-1] > -1 {
+					p.resolveMergedWhitespace(c, expandedKeys[i-1], 1)
+				} else if i < len(expanded)-1 && expandedKeys[i+1] > -1 {
+					p.resolveMergedWhitespace(c, expandedKeys[i+1], -1)
+				}
+			}
+
 			// assumes only the first text node may contain the newlines that must be trimmed next; unlikely bug?
 			n = expanded[0]
 		}
 
-		if n.Parent != nil && n.Parent.FirstChild == n &&
-			(n.Parent.DataAtom == atom.Textarea ||
-				n.Parent.DataAtom == atom.Pre ||
-				n.Parent.DataAtom == atom.Listing) {
+		// only smuggled text, or text after markers, needs trimming; upstream has already trimmed any other text
+		smuggled := len(expanded) > 0 && expandedKeys[0] > -1
+
+		if (smuggled || n.Parent == p.leadingMarkerParent) &&
+			n.Parent != nil && n.Parent.FirstChild == n && isLeadingNewlineAtom(n.Parent.DataAtom) {
+			dataLen := len(n.Data)
+
 			if len(n.Data) > 0 && n.Data[0] == '\r' {
 				n.Data = n.Data[1:]
 			}
@@ -188,6 +331,11 @@ func (p *Parser) rebuildNode(n *html.Node) {
 
 			if len(n.Data) == 0 {
 				n.Parent.RemoveChild(n)
+			} else if trimmed := dataLen - len(n.Data); trimmed > 0 && smuggled {
+				// otherwise, the metadata is trimmed once resolved by its marker
+				if v := p.r.nodes[expandedKeys[0]]; v.metadata != nil {
+					p.trimTextSegments(v.metadata, v.data, trimmed)
+				}
 			}
 		}
 
@@ -195,33 +343,59 @@ func (p *Parser) rebuildNode(n *html.Node) {
 	case html.CommentNode:
 		switch n.Data[0] {
 		case 'c':
-			pnt := p.r.nodeSwapByKey[n.Data[1:]]
-			n.Data = pnt.original
+			pnt := p.r.lookupNode(n.Data[1:])
+			n.Data = pnt.data
 
-			p.offsets.metadataByNode[n] = &NodeMetadata{
-				TokenOffsets: pnt.offsetRange,
+			if pnt.metadata != nil {
+				p.offsets.metadataByNode[n] = pnt.metadata
 			}
 
 			return
 		case 'e':
-			if p.offsets.metadataByNode[n.PrevSibling] != nil {
-				// if it was already set, html parser must have reordered nodes
-				// first encountered offset should be most accurate
-				if p.offsets.metadataByNode[n.PrevSibling].EndTagTokenOffsets == nil {
-					v := p.r.endTagOffsetRangeByKey[n.Data[1:]]
+			key := p.r.lookupNodeIndex(n.Data[1:])
+			if key < 0 {
+				break
+			}
+
+			endTag := &p.r.nodes[key]
+
+			if prev := n.PrevSibling; prev != nil && prev.Type == html.ElementNode && endTag.offsets != nil && isEndTagNameMatch(prev, endTag.data) && !isVoidElement(prev) {
+				if metadata := p.offsets.metadataByNode[prev]; metadata == nil {
+					if prev.FirstChild == nil && (prev.DataAtom == atom.P || prev.DataAtom == atom.Br) {
+						// injected by the end tag itself (i.e. </p> without an open p, or </br>)
+					} else {
+						// missing meta; html parser must have injected/restarted a previously open tag
+						// rather than fake TokenOffsets + TagNameOffsets, drop the metadata
+						endTag.claimed = true
+					}
+				} else if metadata.EndTagTokenOffsets == nil {
+					metadata.EndTagTokenOffsets = endTag.offsets
+					endTag.claimed = true
+				}
 
-					p.offsets.metadataByNode[n.PrevSibling].EndTagTokenOffsets = &v
+				// otherwise, already closed by an earlier end tag
+			} else if fostered := p.findFosterParentedEndTagElement(n, endTag); fostered != nil {
+				p.offsets.metadataByNode[fostered].EndTagTokenOffsets = endTag.offsets
+				endTag.claimed = true
+			}
+		case 'd':
+			if n.PrevSibling != nil && n.PrevSibling.Type == html.DoctypeNode && p.offsets.metadataByNode[n.PrevSibling] == nil {
+				if v := p.r.lookupNode(n.Data[1:]).metadata; v != nil {
+					p.offsets.metadataByNode[n.PrevSibling] = v
 				}
-			} else {
-				// missing meta; html parser must have injected/restarted a previously open tag
-				// rather than fake TokenOffsets + TagNameOffsets, drop the metadata
 			}
 		case 'w':
 			if n.PrevSibling != nil && n.PrevSibling.Type == html.TextNode && p.offsets.metadataByNode[n.PrevSibling] == nil {
-				v := p.r.wsOffsetRangeByKey[n.Data[1:]]
-
-				p.offsets.metadataByNode[n.PrevSibling] = &NodeMetadata{
-					TokenOffsets: v,
+				if key := p.r.lookupNodeIndex(n.Data[1:]); key > -1 {
+					if v := &p.r.nodes[key]; !v.claimed && v.metadata != nil && strings.HasSuffix(v.data, n.PrevSibling.Data) {
+						v.claimed = true
+						p.offsets.metadataByNode[n.PrevSibling] = v.metadata
+
+						if trimmed := len(v.data) - len(n.PrevSibling.Data); trimmed > 0 {
+							// leading newline of a pre element (or similar) was already trimmed
+							p.trimTextSegments(v.metadata, v.data, trimmed)
+						}
+					}
 				}
 			}
 		default:
@@ -229,6 +403,10 @@ func (p *Parser) rebuildNode(n *html.Node) {
 			return
 		}
 
+		if n.PrevSibling == nil && n.Parent != nil && isLeadingNewlineAtom(n.Parent.DataAtom) {
+			p.leadingMarkerParent = n.Parent
+		}
+
 		if n.NextSibling != nil {
 			n.NextSibling.PrevSibling = n.PrevSibling
 		} else if n.Parent != nil {
@@ -248,8 +426,9 @@ func (p *Parser) rebuildNode(n *html.Node) {
 		}
 
 		if firstAttr := n.Attr[0]; firstAttr.Key == "o" {
-			if metadata := p.r.nodeTagByKey[firstAttr.Val]; metadata != nil {
-				p.offsets.metadataByNode[n] = metadata
+			if key := p.r.lookupNodeIndex(firstAttr.Val); key > -1 && p.r.nodes[key].metadata != nil {
+				p.r.nodes[key].claimed = true
+				p.offsets.metadataByNode[n] = p.r.nodes[key].metadata
 				n.Attr = n.Attr[1:]
 			}
 		}
@@ -265,3 +444,314 @@ func (p *Parser) rebuildNode(n *html.Node) {
 		}
 	}
 }
+
+// findFosterParentedEndTagElement returns the element closed by an end tag whose marker was inserted within a table. The
+// element was foster parented before the table, but the marker is a comment which is not, so it is never its sibling.
+// The element must still have been open, so no content of the table may start between it and the end tag (e.g. a tr,
+// which closes it).
+func (p *Parser) findFosterParentedEndTagElement(marker *html.Node, endTag *parserNode) *html.Node {
+	if endTag.offsets == nil {
+		return nil
+	}
+
+	table := marker.Parent
+
+	for table != nil && table.DataAtom != atom.Table {
+		switch table.DataAtom {
+		case atom.Tbody, atom.Tfoot, atom.Thead, atom.Tr:
+			table = table.Parent
+		default:
+			return nil
+		}
+	}
+
+	if table == nil {
+		return nil
+	}
+
+	endTagFrom := endTag.offsets.From.Byte
+
+	for c := table.PrevSibling; c != nil; c = c.PrevSibling {
+		v := p.offsets.metadataByNode[c]
+		if v == nil || v.TokenOffsets.From.Byte >= endTagFrom {
+			// e.g. whitespace, or foster parented after the end tag
+			continue
+		} else if c.Type != html.ElementNode || v.EndTagTokenOffsets != nil || !isEndTagNameMatch(c, endTag.data) {
+			return nil
+		}
+
+		from := v.TokenOffsets.From.Byte
+
+		var closed bool
+
+		var visitNode func(n *html.Node)
+		visitNode = func(n *html.Node) {
+			if cv := p.offsets.metadataByNode[n]; cv != nil && from < cv.TokenOffsets.From.Byte && cv.TokenOffsets.From.Byte < endTagFrom {
+				closed = true
+
+				return
+			}
+
+			for cc := n.FirstChild; cc != nil && !closed; cc = cc.NextSibling {
+				visitNode(cc)
+			}
+		}
+
+		visitNode(table)
+
+		if closed {
+			return nil
+		}
+
+		return c
+	}
+
+	return nil
+}
+
+// rebuildUnmatchedEndTags collects the end tags which did not close an element. Unless claimed by an element, they were
+// either ignored upstream or their marker was dropped.
+func (p *Parser) rebuildUnmatchedEndTags() {
+	for _, v := range p.r.nodes {
+		if v.kind != 'e' || v.claimed || v.offsets == nil {
+			continue
+		}
+
+		p.offsets.unmatchedEndTags = append(p.offsets.unmatchedEndTags, UnmatchedEndTag{
+			TagName: v.data,
+			Offsets: *v.offsets,
+		})
+
+		p.r.report(Diagnostic{
+			Code:     DiagnosticCodeEndTagUnmatched,
+			Severity: DiagnosticSeverityWarning,
+			Message:  fmt.Sprintf("end tag did not close an element: %s", v.data),
+			Offsets:  *v.offsets,
+		})
+	}
+}
+
+// rebuildMergedStartTags resolves any additional html and body start tags, which upstream merged into the existing
+// element by appending the attributes it did not already have.
+func (p *Parser) rebuildMergedStartTags(root *html.Node) {
+	var htmlNode, bodyNode *html.Node
+
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Html {
+			htmlNode = c
+		}
+	}
+
+	if htmlNode == nil {
+		return
+	}
+
+	for c := htmlNode.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Body {
+			bodyNode = c
+		}
+	}
+
+	for _, n := range []*html.Node{htmlNode, bodyNode} {
+		metadata := p.offsets.metadataByNode[n]
+		if metadata == nil || len(metadata.TagAttr) > len(n.Attr) {
+			continue
+		}
+
+		// the smuggled key was always first, so upstream never merged it
+		attrKeys := map[string]bool{"o": true}
+
+		for _, attr := range n.Attr[:len(metadata.TagAttr)] {
+			attrKeys[attr.Key] = true
+		}
+
+		for key := range p.r.nodes {
+			v := &p.r.nodes[key]
+			if v.kind != 'o' || v.claimed || v.metadata == nil || v.data != n.Data {
+				continue
+			}
+
+			var mergedAttr []*NodeAttributeMetadata
+			var mergedAttrKeys []string
+			var ignored bool
+
+			for attrIdx, attrKey := range v.attrKeys {
+				if attrKeys[attrKey] || slices.Contains(mergedAttrKeys, attrKey) {
+					continue
+				}
+
+				nodeAttrIdx := len(metadata.TagAttr) + len(mergedAttr)
+				if attrIdx >= len(v.metadata.TagAttr) || nodeAttrIdx >= len(n.Attr) || n.Attr[nodeAttrIdx].Key != attrKey {
+					// ignored upstream (e.g. within a template); although tags without new attributes cannot be detected
+					ignored = true
+
+					break
+				}
+
+				attrMetadata := *v.metadata.TagAttr[attrIdx]
+				attrMetadata.MergedStartTag = v.metadata
+
+				mergedAttr = append(mergedAttr, &attrMetadata)
+				mergedAttrKeys = append(mergedAttrKeys, attrKey)
+			}
+
+			if ignored {
+				continue
+			}
+
+			v.claimed = true
+
+			for _, attrKey := range mergedAttrKeys {
+				attrKeys[attrKey] = true
+			}
+
+			metadata.MergedStartTags = append(metadata.MergedStartTags, v.metadata)
+			metadata.TagAttr = append(metadata.TagAttr, mergedAttr...)
+		}
+	}
+}
+
+// resolveMergedWhitespace attempts to find the metadata of whitespace which upstream merged with the text of a
+// neighboring token, in which case the trailing comments of the whitespace were placed elsewhere. Starting from the
+// neighbor, tokens are visited in the direction of step and whitespace is matched against the data. End tags and
+// comments may be skipped since they do not contribute text.
+func (p *Parser) resolveMergedWhitespace(n *html.Node, neighborKey int, step int) {
+	remaining := n.Data
+
+	var keys []int
+	var segments []NodeTextSegment
+
+	for key := neighborKey + step; len(remaining) > 0 && key > 0 && key < len(p.r.nodes); key += step {
+		v := p.r.nodes[key]
+
+		if v.kind == 'e' || v.kind == 'c' {
+			continue
+		} else if v.kind != 'w' || v.claimed {
+			break
+		}
+
+		var dataFrom int
+
+		if step > 0 {
+			if !strings.HasPrefix(remaining, v.data) {
+				break
+			}
+
+			dataFrom = len(n.Data) - len(remaining)
+			remaining = remaining[len(v.data):]
+		} else {
+			if !strings.HasSuffix(remaining, v.data) {
+				break
+			}
+
+			remaining = remaining[:len(remaining)-len(v.data)]
+			dataFrom = len(remaining)
+		}
+
+		keys = append(keys, key)
+
+		for _, segment := range v.metadata.TextSegments {
+			segments = append(segments, shiftTextSegment(segment, dataFrom))
+		}
+	}
+
+	if len(remaining) > 0 || len(segments) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		p.r.nodes[key].claimed = true
+	}
+
+	if step < 0 {
+		slices.Reverse(segments)
+	}
+
+	if len(segments) == 1 {
+		p.offsets.metadataByNode[n] = p.r.nodes[keys[0]].metadata
+
+		return
+	}
+
+	p.offsets.metadataByNode[n] = &NodeMetadata{
+		Provenance: NodeProvenanceMerged,
+		TokenOffsets: cursorio.TextOffsetRange{
+			From:  segments[0].Offsets.From,
+			Until: segments[len(segments)-1].Offsets.Until,
+		},
+		TextSegments: segments,
+	}
+}
+
+// trimTextSegments updates the segments after the leading bytes of data were trimmed (e.g. the first newline of a pre
+// element). The data argument is the original data of the token. The token offsets are not changed.
+func (p *Parser) trimTextSegments(metadata *NodeMetadata, data string, trimmed int) {
+	segments := make([]NodeTextSegment, 0, len(metadata.TextSegments))
+
+	for _, segment := range metadata.TextSegments {
+		if segment.DataUntil <= trimmed {
+			continue
+		} else if segment.DataFrom < trimmed {
+			segment.Offsets.From, _ = segment.GetDataOffset(data, trimmed)
+			segment.DataFrom = trimmed
+
+			var replacements []NodeTextReplacement
+
+			for _, r := range segment.Replacements {
+				if r.DataFrom >= trimmed {
+					replacements = append(replacements, r)
+				}
+			}
+
+			segment.Replacements = replacements
+		}
+
+		segments = append(segments, shiftTextSegment(segment, -trimmed))
+	}
+
+	metadata.TextSegments = segments
+}
+
+// shiftTextSegment returns a copy of the segment with its data ranges (and those of its replacements) moved by delta.
+func shiftTextSegment(segment NodeTextSegment, delta int) NodeTextSegment {
+	segment.DataFrom += delta
+	segment.DataUntil += delta
+
+	if len(segment.Replacements) > 0 {
+		replacements := make([]NodeTextReplacement, len(segment.Replacements))
+
+		for i, r := range segment.Replacements {
+			r.DataFrom += delta
+			r.DataUntil += delta
+			replacements[i] = r
+		}
+
+		segment.Replacements = replacements
+	}
+
+	return segment
+}
+
+// isVoidElement reports whether an element is a void HTML element, which never has an end tag (e.g. </br> is instead
+// converted into another br, and </input> is ignored).
+func isVoidElement(n *html.Node) bool {
+	return n.Type == html.ElementNode && n.Namespace == "" && isVoidAtom(n.DataAtom)
+}
+
+// isEndTagNameMatch reports whether the end tag name would have closed the element. Upstream allows any heading end tag
+// to close any heading element.
+func isEndTagNameMatch(n *html.Node, tagName string) bool {
+	if strings.EqualFold(n.Data, tagName) {
+		return true
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		switch atom.Lookup([]byte(strings.ToLower(tagName))) {
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			return true
+		}
+	}
+
+	return false
+}
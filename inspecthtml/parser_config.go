@@ -11,6 +11,10 @@ type ParserConfig struct {
 	initialOffset        *cursorio.TextOffset
 	tokenizerInterceptor func(t *html.Tokenizer) *html.Tokenizer
 	readerInterceptor    func(r io.Reader) io.Reader
+	tokenizerDiagnostics *bool
+	treeDiagnostics      *bool
+	syntaxTree           *bool
+	retainSource         *bool
 }
 
 var _ ParserOption = ParserConfig{}
@@ -27,6 +31,22 @@ func (c ParserConfig) apply(o *ParserConfig) {
 	if c.readerInterceptor != nil {
 		o.readerInterceptor = c.readerInterceptor
 	}
+
+	if c.tokenizerDiagnostics != nil {
+		o.tokenizerDiagnostics = c.tokenizerDiagnostics
+	}
+
+	if c.treeDiagnostics != nil {
+		o.treeDiagnostics = c.treeDiagnostics
+	}
+
+	if c.syntaxTree != nil {
+		o.syntaxTree = c.syntaxTree
+	}
+
+	if c.retainSource != nil {
+		o.retainSource = c.retainSource
+	}
 }
 
 func (c ParserConfig) SetInitialOffset(v cursorio.TextOffset) ParserConfig {
@@ -46,3 +66,35 @@ func (c ParserConfig) SetReaderInterceptor(f func(r io.Reader) io.Reader) Parser
 
 	return c
 }
+
+// SetTokenizerDiagnostics enables reporting the parse errors of the tokenizer (e.g. duplicate-attribute) as diagnostics.
+// Only errors which can be observed from the tokens are reported, using the codes of the spec.
+func (c ParserConfig) SetTokenizerDiagnostics(v bool) ParserConfig {
+	c.tokenizerDiagnostics = &v
+
+	return c
+}
+
+// SetTreeDiagnostics enables reporting where the DOM Processor recovered from malformed markup (e.g. unclosed,
+// misnested, or foster-parented nodes) as diagnostics which refer to the affected node.
+func (c ParserConfig) SetTreeDiagnostics(v bool) ParserConfig {
+	c.treeDiagnostics = &v
+
+	return c
+}
+
+// SetSyntaxTree enables building a lossless concrete syntax tree of the source, which is available from
+// ParseMetadata.GetSyntaxTree.
+func (c ParserConfig) SetSyntaxTree(v bool) ParserConfig {
+	c.syntaxTree = &v
+
+	return c
+}
+
+// SetRetainSource enables retaining the source as it is read, so raw slices of nodes are available from ParseMetadata
+// (e.g. GetNodeRawOuterHTML) without keeping the original input.
+func (c ParserConfig) SetRetainSource(v bool) ParserConfig {
+	c.retainSource = &v
+
+	return c
+}
@@ -3,8 +3,8 @@ package inspecthtml
 import (
 	"bytes"
 	"fmt"
-	"os"
-	"regexp"
+	"io"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -14,15 +14,17 @@ import (
 	"golang.org/x/net/html/atom"
 )
 
-var reTagName = regexp.MustCompile(`^<([^\s/>]+)`)
-var reAttrKeyValue = regexp.MustCompile(`.*?[\s<>]*([^=\s/<>]+)((\s*=\s*)(.))?`)
-var reAttrValueDoubleQuote = regexp.MustCompile(`.*?"`)
-var reAttrValueSingleQuote = regexp.MustCompile(`.*?'`)
-var reAttrValueUnquoted = regexp.MustCompile(`[^\s>]+`)
+// parserNode captures the source of a smuggled token. Its key is the index within parserReader.nodes.
+type parserNode struct {
+	kind     byte                      // same as the smuggled marker (i.e. o, c, d, e, t, or w)
+	metadata *NodeMetadata             // start tags, doctypes, comments, text, and whitespace
+	data     string                    // comment, text, and whitespace data; start and end tag name
+	attrKeys []string                  // start tags of html and body, which may be merged
+	offsets  *cursorio.TextOffsetRange // end tags
 
-type parserNodeSwap struct {
-	original    string
-	offsetRange cursorio.TextOffsetRange
+	// claimed is used by whitespace which may be resolved by either its trailing comment or its neighbors, and by start
+	// tags which may be merged into another element
+	claimed bool
 }
 
 type parserReader struct {
@@ -33,173 +35,413 @@ type parserReader struct {
 	buf  []byte
 	bufi int
 
-	nodeIdx                int64
-	nodeRawTextMode        bool
-	nodeTagByKey           map[string]*NodeMetadata
-	nodeSwapByKey          map[string]parserNodeSwap
-	endTagOffsetRangeByKey map[string]cursorio.TextOffsetRange
-	wsOffsetRangeByKey     map[string]cursorio.TextOffsetRange
+	nodes           []parserNode
+	nodeRawTextMode bool
+
+	// scripting is the scripting flag of the upstream parser; when disabled, noscript is not raw text
+	scripting bool
+
+	// tokenizerDiagnostics enables reporting parse errors of the tokenizer
+	tokenizerDiagnostics bool
+
+	fragment       bool
+	foreign        []parserForeignElement
+	openHTML       []string              // HTML elements outside of foreign content
+	foreignContext *parserForeignElement // fragment context, if it was foreign
+
+	// frameset is whether a frameset replaced the body upstream, after which most start tags are ignored; it is
+	// ignored itself once the body has content (i.e. the frameset-ok flag is not set)
+	frameset      bool
+	framesetNotOK bool
+
+	diagnostics []Diagnostic
+
+	// syntaxTree records the syntax node of each token, along with the key of the node appended for it
+	syntaxTree  bool
+	syntaxNodes []parserSyntaxNode
+
+	// retainSource records the raw source of each token, which starts at the initial offset
+	retainSource bool
+	source       []byte
+	sourceOffset cursorio.TextOffset
+
+	// collectTokens records each token for a Tokenizer, which does not use the smuggled buffer or nodes
+	collectTokens bool
+	tokens        []parserToken
+}
+
+// parserSyntaxNode is the syntax node of a token. Its key is the node which was expected to be appended for the token,
+// but it may not have been (e.g. dropped text), so the offsets of the node must be verified.
+type parserSyntaxNode struct {
+	node    *SyntaxNode
+	nodeKey int
+}
+
+// parserToken is a token which was read from source, along with its metadata.
+type parserToken struct {
+	token    html.Token
+	metadata *NodeMetadata
+}
+
+// https://html.spec.whatwg.org/multipage/parsing.html#parsing-html-fragments
+func isRawTextAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Script, atom.Style, atom.Textarea, atom.Title, atom.Plaintext, atom.Iframe, atom.Xmp, atom.Noembed, atom.Noframes, atom.Noscript:
+		return true
+	}
+
+	return false
+}
+
+// isScriptingEnabled reports whether the scripting flag of the upstream parser is enabled by the options. The flag is
+// not exported, so rely on how a noscript element is parsed.
+func isScriptingEnabled(opts ...html.ParseOption) bool {
+	if len(opts) == 0 {
+		return true
+	}
+
+	doc, err := html.ParseWithOptions(strings.NewReader("<body><noscript><i>"), opts...)
+	if err != nil {
+		return true
+	}
+
+	body := doc.FirstChild.LastChild
+	if body == nil || body.FirstChild == nil || body.FirstChild.FirstChild == nil {
+		return true
+	}
+
+	return body.FirstChild.FirstChild.Type != html.ElementNode
+}
+
+func (r *parserReader) report(d Diagnostic) {
+	r.diagnostics = append(r.diagnostics, d)
+}
+
+// appendNode records the node and returns its key.
+func (r *parserReader) appendNode(v parserNode) int64 {
+	if len(r.nodes) == 0 {
+		// reserve the zero key to avoid any ambiguity with unset values
+		r.nodes = append(r.nodes, parserNode{})
+	}
+
+	r.nodes = append(r.nodes, v)
+
+	return int64(len(r.nodes) - 1)
+}
+
+// appendSyntaxNode records the syntax node of a token which has not been written yet.
+func (r *parserReader) appendSyntaxNode(tt html.TokenType, raw []byte) {
+	r.syntaxNodes = append(r.syntaxNodes, parserSyntaxNode{
+		node:    newSyntaxNode(tt, raw, r.doc.GetTextOffset()),
+		nodeKey: max(len(r.nodes), 1),
+	})
+}
+
+// lookupNodeIndex returns the index of a key within nodes, or -1 if the key is unknown.
+func (r *parserReader) lookupNodeIndex(key string) int {
+	i, err := strconv.Atoi(key)
+	if err != nil || i <= 0 || i >= len(r.nodes) {
+		return -1
+	}
+
+	return i
+}
+
+// lookupNode returns the node of a key, or the zero value if the key is unknown.
+func (r *parserReader) lookupNode(key string) parserNode {
+	i := r.lookupNodeIndex(key)
+	if i == -1 {
+		return parserNode{}
+	}
+
+	return r.nodes[i]
 }
 
 func (r *parserReader) Read(p []byte) (int, error) {
-	if r.err != nil {
-		return 0, r.err
-	} else if r.bufi >= len(r.buf) {
-		r.err = r.next()
-		if r.err != nil {
-			return 0, r.err
+	var l int
+
+	// fill as much as possible to minimize the calls made by the upstream tokenizer
+	for l < len(p) {
+		if r.bufi >= len(r.buf) {
+			if r.err == nil {
+				r.err = r.next()
+			}
+
+			if r.err != nil {
+				break
+			}
+
+			continue
 		}
-	}
 
-	var l = copy(p, r.buf[r.bufi:])
+		n := copy(p[l:], r.buf[r.bufi:])
+		r.bufi += n
+		l += n
+	}
 
-	r.bufi += l
+	if l > 0 {
+		return l, nil
+	}
 
-	return l, nil
+	return 0, r.err
 }
 
 func (r *parserReader) next() error {
+	r.buf = r.buf[:0]
 	r.bufi = 0
 
+	// same as upstream, which only allows CDATA within foreign content
+	r.tokenizer.AllowCDATA(len(r.foreign) > 0 && r.foreign[len(r.foreign)-1].namespace != "")
+
 	tt := r.tokenizer.Next()
 	if tt == html.ErrorToken {
-		return r.tokenizer.Err()
+		err := r.tokenizer.Err()
+
+		if raw := r.tokenizer.Raw(); err == io.EOF && len(raw) > 0 {
+			if r.retainSource {
+				r.source = append(r.source, raw...)
+			}
+
+			if r.syntaxTree {
+				r.appendSyntaxNode(tt, raw)
+			}
+
+			if r.tokenizerDiagnostics {
+				// same as upstream, which drops an incomplete tag
+				r.reportTokenErrors(raw, r.doc.GetTextOffset(), []tokenError{{DiagnosticCodeEOFInTag, 0, len(raw), "end of file in tag"}})
+			}
+		}
+
+		return err
 	}
 
 	// copy and avoid append reusing tokenizer's byte slice
-	raw := []byte(string(r.tokenizer.Raw()))
+	raw := bytes.Clone(r.tokenizer.Raw())
+
+	if r.retainSource {
+		r.source = append(r.source, raw...)
+	}
+
+	if r.syntaxTree {
+		r.appendSyntaxNode(tt, raw)
+	}
 
 	switch tt {
 	case html.SelfClosingTagToken, html.StartTagToken:
-		rawCutset := raw
-
-		docOffset := r.doc.GetTextOffset()
 		tagProfile := &NodeMetadata{
 			TokenOffsets: cursorio.TextOffsetRange{
-				From: docOffset,
+				From: r.doc.GetTextOffset(),
 			},
 			TagSelfClosing: tt == html.SelfClosingTagToken,
 		}
 
-		tagNameMatcher := reTagName.FindSubmatchIndex(rawCutset)
-		if tagNameMatcher != nil {
-			r.doc.Write(rawCutset[:tagNameMatcher[2]])
+		nameRange, attrRanges := scanStartTag(raw)
 
-			tagNameOffsets := r.doc.WriteForOffsetRange(rawCutset[tagNameMatcher[2]:tagNameMatcher[3]])
-			tagProfile.TagNameOffsets = &tagNameOffsets
+		r.doc.Write(raw[:nameRange[0]])
 
-			rawCutset = rawCutset[tagNameMatcher[3]:]
-		}
+		tagNameOffsets := r.doc.WriteForOffsetRange(raw[nameRange[0]:nameRange[1]])
+		tagProfile.TagNameOffsets = &tagNameOffsets
+
+		rawCursor := nameRange[1]
+
+		var attrKeys, attrValues []string
+
+		rawTagName, hasAttr := r.tokenizer.TagName()
 
-		_, hasAttr := r.tokenizer.TagName()
 		for hasAttr {
-			attrKey, attrValue, more := r.tokenizer.TagAttr()
+			var attrKey, attrValue []byte
 
-			rawAttrMatcher := reAttrKeyValue.FindSubmatchIndex(rawCutset)
+			attrKey, attrValue, hasAttr = r.tokenizer.TagAttr()
+			attrKeys = append(attrKeys, string(attrKey))
+			attrValues = append(attrValues, string(attrValue))
+		}
 
-			if rawAttrMatcher == nil {
-				// <script async src="https://example.com/asset?shop="quoteful.example.com"></script>
+		attrCount := len(attrValues)
 
-				// ignore
-				// risky to not advance cursor; possible early regex match for next attribute?
-				tagProfile.TagAttr = append(tagProfile.TagAttr, nil)
-			} else {
-				r.doc.Write(rawCutset[:rawAttrMatcher[2]])
+		for attrIdx, attrRange := range attrRanges[:min(attrCount, len(attrRanges))] {
+			r.doc.Write(raw[rawCursor:attrRange.key[0]])
 
-				tagAttrProfile := NodeAttributeMetadata{
-					KeyOffsets: r.doc.WriteForOffsetRange(rawCutset[rawAttrMatcher[2]:rawAttrMatcher[3]]),
-				}
+			tagAttrProfile := &NodeAttributeMetadata{
+				KeyOffsets: r.doc.WriteForOffsetRange(raw[attrRange.key[0]:attrRange.key[1]]),
+			}
 
-				if rawAttrMatcher[4] > -1 {
-					r.doc.Write(rawCutset[rawAttrMatcher[6]:rawAttrMatcher[7]])
-					rawCutset = rawCutset[rawAttrMatcher[8]:]
-
-					var consumeLen int
-
-					if rawCutset[0] == '"' {
-						closeMatcher := reAttrValueDoubleQuote.FindSubmatchIndex(rawCutset[1:])
-
-						if closeMatcher == nil {
-							// weird
-						} else {
-							consumeLen = closeMatcher[1] + 1
-						}
-					} else if rawCutset[0] == '\'' {
-						closeMatcher := reAttrValueSingleQuote.FindSubmatchIndex(rawCutset[1:])
-
-						if closeMatcher == nil {
-							// weird
-						} else {
-							consumeLen = closeMatcher[1] + 1
-						}
-					} else if !unicode.IsSpace(rune(rawCutset[0])) && rawCutset[0] != '>' {
-						closeMatcher := reAttrValueUnquoted.FindSubmatchIndex(rawCutset)
-
-						if closeMatcher == nil {
-							// weird
-						} else {
-							consumeLen = closeMatcher[1]
-						}
-					}
+			rawCursor = attrRange.key[1]
 
-					if consumeLen > 0 {
-						valueOffsetRange := r.doc.WriteForOffsetRange(rawCutset[:consumeLen])
-						tagAttrProfile.ValueOffsets = &valueOffsetRange
+			if attrRange.value[0] > -1 {
+				r.doc.Write(raw[rawCursor:attrRange.value[0]])
 
-						rawCutset = rawCutset[consumeLen:]
-					}
-				} else if len(attrValue) > 0 {
-					// an edge case worth fixing; almost panic-worthy; subsequent attributes may no longer be correct
-					fmt.Fprintf(os.Stderr, "inspecthtml: regex attr failed (raw=%q, key=%q, val=%q)\n", string(rawCutset), string(attrKey), string(attrValue))
-				} else {
-					rawCutset = rawCutset[rawAttrMatcher[3]:]
-				}
+				valueOffsets := r.doc.WriteForOffsetRange(raw[attrRange.value[0]:attrRange.value[1]])
+				tagAttrProfile.ValueOffsets = &valueOffsets
+				tagAttrProfile.ValueSegment = newValueSegment(raw[attrRange.value[0]:attrRange.value[1]], valueOffsets, attrValues[attrIdx])
 
-				tagProfile.TagAttr = append(tagProfile.TagAttr, &tagAttrProfile)
+				rawCursor = attrRange.value[1]
 			}
 
-			hasAttr = more
+			tagProfile.TagAttr = append(tagProfile.TagAttr, tagAttrProfile)
 		}
 
-		r.doc.Write(rawCutset)
+		r.doc.Write(raw[rawCursor:])
 
 		tagProfile.TokenOffsets.Until = r.doc.GetTextOffset()
 
-		r.nodeIdx++
-		nodeKey := strconv.FormatInt(r.nodeIdx, 10)
+		if attrCount != len(attrRanges) {
+			// should not happen since the scanner mirrors upstream; keep attributes aligned with an empty range
+			for len(tagProfile.TagAttr) < attrCount {
+				tagProfile.TagAttr = append(tagProfile.TagAttr, &NodeAttributeMetadata{
+					KeyOffsets: cursorio.TextOffsetRange{
+						From:  tagProfile.TokenOffsets.Until,
+						Until: tagProfile.TokenOffsets.Until,
+					},
+				})
+			}
+
+			r.report(Diagnostic{
+				Code:     DiagnosticCodeAttrOffsetsUnavailable,
+				Severity: DiagnosticSeverityError,
+				Message:  fmt.Sprintf("scanned %d attributes, but tokenizer reported %d", len(attrRanges), attrCount),
+				Offsets:  tagProfile.TokenOffsets,
+			})
+		}
+
+		tagName := strings.ToLower(string(raw[nameRange[0]:nameRange[1]]))
+
+		startTag := parserNode{
+			kind:     'o',
+			metadata: tagProfile,
+			data:     tagName,
+		}
+
+		if tagName == "html" || tagName == "body" {
+			startTag.attrKeys = attrKeys
+		}
+
+		nodeKey := r.appendNode(startTag)
 
-		r.nodeTagByKey[nodeKey] = tagProfile
+		tagAtom := atom.Lookup([]byte(tagName))
+
+		ignoredRawText := r.isIgnoredRawTextInSelect(tagAtom)
+		isForeign := r.pushForeignStartTag(tagName, attrKeys, attrValues, tt == html.SelfClosingTagToken)
+
+		if r.collectTokens {
+			r.tokens = append(r.tokens, parserToken{
+				token:    newTagToken(tt, rawTagName, attrKeys, attrValues),
+				metadata: tagProfile,
+			})
+		}
 
-		if tagNameMatcher != nil {
-			switch atom.Lookup(bytes.ToLower(raw[tagNameMatcher[2]:tagNameMatcher[3]])) {
-			// https://html.spec.whatwg.org/multipage/parsing.html#parsing-html-fragments
-			case atom.Script, atom.Style, atom.Textarea, atom.Title, atom.Plaintext, atom.Iframe, atom.Xmp, atom.Noembed, atom.Noframes, atom.Noscript:
-				r.nodeRawTextMode = true
+		if r.tokenizerDiagnostics {
+			tokenErrors := scanStartTagErrors(raw, nameRange, attrRanges, attrKeys)
+
+			for _, tagAttrProfile := range tagProfile.TagAttr {
+				if tagAttrProfile.ValueSegment != nil {
+					tokenErrors = scanCharacterReferenceErrors(tokenErrors, raw, tagProfile.TokenOffsets.From, tagAttrProfile.ValueSegment.Replacements)
+				}
 			}
+
+			if !isForeign && tt == html.SelfClosingTagToken && !isVoidAtom(tagAtom) {
+				tokenErrors = append(tokenErrors, tokenError{DiagnosticCodeNonVoidHTMLElementStartTagWithTrailingSolidus, len(raw) - 2, len(raw) - 1, fmt.Sprintf("non-void element with trailing solidus: %s", tagName)})
+			}
+
+			r.reportTokenErrors(raw, tagProfile.TokenOffsets.From, tokenErrors)
+		}
+
+		if isForeign {
+			// same as upstream, which parses the content of foreign elements (e.g. svg title) as markup
+			r.tokenizer.NextIsNotRawText()
+		} else if ignoredRawText {
+			// same as upstream, which ignores the tag and parses its content as markup
+			r.tokenizer.NextIsNotRawText()
+		} else if tagAtom == atom.Noscript && !r.scripting {
+			// same as upstream, which parses its content as markup
+			r.tokenizer.NextIsNotRawText()
+		} else if isRawTextAtom(tagAtom) {
+			r.nodeRawTextMode = true
 		}
 
 		// always first attribute to avoid mangling that may happen upstream for malformed user input
 		// including trailing space to avoid any accidental overlap with malformed tags (e.g., `<body</div>`)
-		oAttr := fmt.Appendf(nil, " o=%q ", nodeKey)
-		if tagNameMatcher != nil {
-			insertAt := tagNameMatcher[3]
-			r.buf = append(raw[:insertAt:insertAt], oAttr...)
-			r.buf = append(r.buf, raw[insertAt:]...)
-		} else {
-			r.buf = raw
-		}
+		r.buf = append(r.buf[:0], raw[:nameRange[1]]...)
+		r.buf = append(r.buf, ` o="`...)
+		r.buf = strconv.AppendInt(r.buf, nodeKey, 10)
+		r.buf = append(r.buf, `" `...)
+		r.buf = append(r.buf, raw[nameRange[1]:]...)
 	case html.EndTagToken:
-		r.nodeIdx++
-		nodeKey := strconv.FormatInt(r.nodeIdx, 10)
+		tagName, _ := r.tokenizer.TagName()
+
+		offsets := r.doc.WriteForOffsetRange(raw)
+
+		if r.collectTokens {
+			// upstream does not read the attributes of end tags
+			r.tokens = append(r.tokens, parserToken{
+				token:    newTagToken(tt, tagName, nil, nil),
+				metadata: newEndTagMetadata(raw, offsets),
+			})
+		}
+
+		nodeKey := r.appendNode(parserNode{
+			kind:    'e',
+			data:    string(tagName),
+			offsets: &offsets,
+		})
+
+		if r.tokenizerDiagnostics {
+			r.reportTokenErrors(raw, offsets.From, scanEndTagErrors(raw))
+		}
 
-		r.endTagOffsetRangeByKey[nodeKey] = r.doc.WriteForOffsetRange(raw)
-		r.buf = append(raw, []byte("<!--e"+nodeKey+"-->")...)
+		r.buf = appendMarkerComment(append(r.buf[:0], raw...), 'e', nodeKey)
 
 		r.nodeRawTextMode = false
-	case html.CommentToken:
-		r.nodeIdx++
-		nodeKey := strconv.FormatInt(r.nodeIdx, 10)
+		r.popForeignEndTag(string(tagName))
+	case html.DoctypeToken:
+		doctypeProfile := &NodeMetadata{
+			TokenOffsets: cursorio.TextOffsetRange{
+				From: r.doc.GetTextOffset(),
+			},
+		}
+
+		var rawCursor int
+
+		writeOffsetRange := func(v [2]int) *cursorio.TextOffsetRange {
+			if v[0] < 0 {
+				return nil
+			}
+
+			r.doc.Write(raw[rawCursor:v[0]])
+			offsetRange := r.doc.WriteForOffsetRange(raw[v[0]:v[1]])
+			rawCursor = v[1]
+
+			return &offsetRange
+		}
+
+		if r.collectTokens {
+			r.tokens = append(r.tokens, parserToken{
+				token:    r.tokenizer.Token(),
+				metadata: doctypeProfile,
+			})
+		}
+
+		nameRange, publicRange, systemRange := scanDoctype(raw)
+
+		if r.tokenizerDiagnostics {
+			r.reportTokenErrors(raw, doctypeProfile.TokenOffsets.From, scanDoctypeErrors(raw, nameRange))
+		}
+
+		doctypeProfile.DoctypeNameOffsets = writeOffsetRange(nameRange)
+		doctypeProfile.DoctypePublicIdentifierOffsets = writeOffsetRange(publicRange)
+		doctypeProfile.DoctypeSystemIdentifierOffsets = writeOffsetRange(systemRange)
+
+		r.doc.Write(raw[rawCursor:])
+
+		doctypeProfile.TokenOffsets.Until = r.doc.GetTextOffset()
+
+		nodeKey := r.appendNode(parserNode{
+			kind:     'd',
+			metadata: doctypeProfile,
+		})
 
+		// the doctype node cannot carry attributes, so rely on a trailing comment to identify it
+		r.buf = appendMarkerComment(append(r.buf[:0], raw...), 'd', nodeKey)
+	case html.CommentToken:
 		var commentContent string
 
 		if !bytes.HasPrefix(raw, []byte("<!--")) {
@@ -230,22 +472,94 @@ func (r *parserReader) next() error {
 			commentContent = html.UnescapeString(commentContent)
 		}
 
-		r.nodeSwapByKey[nodeKey] = parserNodeSwap{
-			original:    commentContent,
-			offsetRange: r.doc.WriteForOffsetRange(raw),
+		if r.tokenizerDiagnostics {
+			r.reportTokenErrors(raw, r.doc.GetTextOffset(), scanCommentErrors(raw))
+		}
+
+		commentProfile := &NodeMetadata{
+			TokenOffsets: r.doc.WriteForOffsetRange(raw),
+		}
+
+		if r.collectTokens {
+			r.tokens = append(r.tokens, parserToken{
+				token: html.Token{
+					Type: html.CommentToken,
+					Data: commentContent,
+				},
+				metadata: commentProfile,
+			})
 		}
 
-		r.buf = []byte("<!--c" + nodeKey + "-->")
+		nodeKey := r.appendNode(parserNode{
+			kind:     'c',
+			metadata: commentProfile,
+			data:     commentContent,
+		})
+
+		r.buf = appendMarkerComment(r.buf[:0], 'c', nodeKey)
 	case html.TextToken:
 		original := r.tokenizer.Token().Data
+		offsets := r.doc.WriteForOffsetRange(raw)
+
+		if !r.nodeRawTextMode && strings.Trim(original, "\t\n\f\r \x00") != "" {
+			// same as upstream, where content of the body prevents a frameset from replacing it
+			r.framesetNotOK = true
+		}
+
+		// prefer the expected decoding, but the others are still possible (e.g. noscript depends on the scripting flag)
+		decodings := textDecodingsData
+		if r.nodeRawTextMode {
+			decodings = textDecodingsRawText
+		}
+
+		dataRaw, dataOffsets := raw, offsets
+
+		if bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+			// only tokenized as text within foreign content; its data excludes the markers
+			w := cursorio.NewTextWriter(offsets.From)
+			w.Write(raw[:9])
+
+			dataRaw = bytes.TrimSuffix(raw[9:], []byte("]]>"))
+			dataOffsets = w.WriteForOffsetRange(dataRaw)
+			decodings = textDecodingsCDATA
+		} else if strings.ContainsRune(original, 0) && r.isForeignContent(html.TextToken, "") {
+			// same as upstream, which replaces (rather than drops) NUL characters within foreign content
+			original = strings.ReplaceAll(original, "\x00", "\ufffd")
+			decodings = textDecodingsCDATA
+		}
+
+		replacements, mapped := mapTextReplacements(dataRaw, dataOffsets.From, original, decodings...)
+
+		if r.tokenizerDiagnostics {
+			r.reportTokenErrors(raw, offsets.From, scanCharacterReferenceErrors(scanTextErrors(raw, r.nodeRawTextMode), raw, offsets.From, replacements))
+		}
+
+		if r.collectTokens {
+			r.tokens = append(r.tokens, parserToken{
+				token: html.Token{
+					Type: html.TextToken,
+					Data: original,
+				},
+				metadata: &NodeMetadata{
+					TokenOffsets: offsets,
+					TextSegments: []NodeTextSegment{
+						{
+							DataUntil:    len(original),
+							Offsets:      dataOffsets,
+							Replacements: slices.Clone(replacements),
+						},
+					},
+				},
+			})
+		}
 
 		if !r.nodeRawTextMode {
 			// The upstream html.Parse has complex logic for WS (dropping before <head>, preserving in <head>, reparenting
 			// after </body>, active formatting etc.). Rather than duplicating and maintaining the logic, propagate it and
 			// rely on a trailing comment for backfilling the whitespace text node if it remains.
 			//
-			// It is possible this whitespace gets squashed with other text nodes, in which case the offsets range currently
-			// will be lost.
+			// It is possible this whitespace gets squashed with other text nodes, in which case the trailing comment is
+			// placed elsewhere and the rebuild relies on neighboring tokens instead.
 			if !strings.ContainsFunc(original, func(r rune) bool {
 				if uint32(r) <= unicode.MaxLatin1 {
 					switch r {
@@ -258,36 +572,534 @@ func (r *parserReader) next() error {
 
 				return !unicode.Is(unicode.White_Space, r)
 			}) {
-				r.nodeIdx++
-				nodeKey := strconv.FormatInt(r.nodeIdx, 10)
+				nodeKey := r.appendNode(parserNode{
+					kind: 'w',
+					metadata: &NodeMetadata{
+						TokenOffsets: offsets,
+						TextSegments: []NodeTextSegment{
+							{
+								DataUntil:    len(original),
+								Offsets:      dataOffsets,
+								Replacements: replacements,
+							},
+						},
+					},
+					data: original,
+				})
 
-				r.wsOffsetRangeByKey[nodeKey] = r.doc.WriteForOffsetRange(raw)
-				r.buf = append(raw, []byte("<!--w"+nodeKey+"-->")...)
+				r.buf = appendMarkerComment(append(r.buf[:0], raw...), 'w', nodeKey)
 
 				return nil
 			}
 		}
 
+		var segments []NodeTextSegment
+
 		// approximate behavior of upstream parser; it does not seem to care about other control characters?
 		// see https://www.w3.org/International/questions/qa-controls.en.html#support
-		original = strings.ReplaceAll(original, "\x00", "")
+		if strings.ContainsRune(original, 0) {
+			if mapped {
+				segments = splitTextSegments(original, dataOffsets, replacements)
+				original = strings.ReplaceAll(original, "\x00", "")
+			} else {
+				original = strings.ReplaceAll(original, "\x00", "")
+				replacements[0].DataUntil = len(original)
+				replacements[0].Data = original
+			}
+
+			// a Tokenizer retains them within the token, same as upstream
+			if !r.collectTokens {
+				r.report(Diagnostic{
+					Code:     DiagnosticCodeTextNullDropped,
+					Severity: DiagnosticSeverityWarning,
+					Message:  "unexpected null character dropped from text",
+					Offsets:  offsets,
+				})
+			}
+		}
+
 		if len(original) == 0 {
-			// quietly drop the token
+			// drop the token; its source offsets were still written
 			return r.next()
 		}
 
-		r.nodeIdx++
-		nodeKey := strconv.FormatInt(r.nodeIdx, 10)
-		r.nodeSwapByKey[nodeKey] = parserNodeSwap{
-			original:    original,
-			offsetRange: r.doc.WriteForOffsetRange(raw),
+		if segments == nil {
+			segments = []NodeTextSegment{
+				{
+					DataUntil:    len(original),
+					Offsets:      dataOffsets,
+					Replacements: replacements,
+				},
+			}
 		}
 
-		r.buf = []byte("t" + nodeKey)
+		nodeKey := r.appendNode(parserNode{
+			kind: 't',
+			metadata: &NodeMetadata{
+				TokenOffsets: offsets,
+				TextSegments: segments,
+			},
+			data: original,
+		})
+
+		r.buf = strconv.AppendInt(append(r.buf[:0], 't'), nodeKey, 10)
 	default:
 		r.doc.Write(raw)
-		r.buf = raw
+		r.buf = append(r.buf[:0], raw...)
 	}
 
 	return nil
 }
+
+// textDecoding describes how the tokenizer converts the source of text into data.
+type textDecoding struct {
+	unescape   bool
+	attribute  bool
+	convertNUL bool
+}
+
+var (
+	textDecodingData    = textDecoding{unescape: true}
+	textDecodingRCDATA  = textDecoding{unescape: true, convertNUL: true}
+	textDecodingRawText = textDecoding{convertNUL: true}
+	textDecodingAttr    = textDecoding{unescape: true, attribute: true}
+
+	textDecodingsData    = []textDecoding{textDecodingData, textDecodingRCDATA, textDecodingRawText}
+	textDecodingsRawText = []textDecoding{textDecodingRawText, textDecodingRCDATA, textDecodingData}
+	textDecodingsCDATA   = []textDecoding{textDecodingRCDATA}
+)
+
+// decodeText mirrors the tokenizer's conversion of raw text into data while recording the source of any parts which
+// were replaced (i.e. newline normalization, NUL characters, and character references).
+func decodeText(raw []byte, from cursorio.TextOffset, d textDecoding) (string, []NodeTextReplacement) {
+	w := cursorio.NewTextWriter(from)
+
+	var data []byte
+	var replacements []NodeTextReplacement
+	var identityFrom int
+
+	replace := func(i, n int, v string, characterReference bool) {
+		w.Write(raw[identityFrom:i])
+		data = append(data, raw[identityFrom:i]...)
+
+		replacements = append(replacements, NodeTextReplacement{
+			DataFrom:           len(data),
+			DataUntil:          len(data) + len(v),
+			Offsets:            w.WriteForOffsetRange(raw[i : i+n]),
+			Data:               v,
+			CharacterReference: characterReference,
+		})
+
+		data = append(data, v...)
+		identityFrom = i + n
+	}
+
+	for i := 0; i < len(raw); {
+		switch c := raw[i]; {
+		case c == '\r':
+			n := 1
+			if i+1 < len(raw) && raw[i+1] == '\n' {
+				n = 2
+			}
+
+			replace(i, n, "\n", false)
+			i += n
+		case c == 0 && d.convertNUL:
+			replace(i, 1, "\ufffd", false)
+			i++
+		case c == '&' && d.unescape:
+			if n := scanCharacterReference(raw[i:], d.attribute); n > 0 {
+				replace(i, n, html.UnescapeString(string(raw[i:i+n])), true)
+				i += n
+			} else {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+
+	if replacements == nil {
+		return string(raw), nil
+	}
+
+	return string(append(data, raw[identityFrom:]...)), replacements
+}
+
+// mapTextReplacements returns the replacements of the first decoding which results in data. If none do, a single
+// replacement is used for all of the data.
+func mapTextReplacements(raw []byte, from cursorio.TextOffset, data string, decodings ...textDecoding) ([]NodeTextReplacement, bool) {
+	for _, d := range decodings {
+		if decoded, replacements := decodeText(raw, from, d); decoded == data {
+			return replacements, true
+		}
+	}
+
+	return []NodeTextReplacement{
+		{
+			DataUntil: len(data),
+			Offsets:   cursorio.NewTextWriter(from).WriteForOffsetRange(raw),
+			Data:      data,
+		},
+	}, false
+}
+
+// scanCharacterReference returns the length of the character reference at the start of s, or 0 if there is none. It
+// mirrors the upstream unescapeEntity behavior.
+func scanCharacterReference(s []byte, attribute bool) int {
+	if len(s) <= 1 {
+		return 0
+	}
+
+	i := 1
+
+	if s[i] == '#' {
+		if len(s) <= 3 {
+			return 0
+		}
+
+		i++
+
+		hex := s[i] == 'x' || s[i] == 'X'
+		if hex {
+			i++
+		}
+
+		for i < len(s) {
+			c := s[i]
+			i++
+
+			if '0' <= c && c <= '9' || hex && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				continue
+			}
+
+			if c != ';' {
+				i--
+			}
+
+			break
+		}
+
+		if i <= 3 {
+			return 0
+		}
+
+		return i
+	}
+
+	for i < len(s) {
+		c := s[i]
+		i++
+
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			continue
+		}
+
+		if c != ';' {
+			i--
+		}
+
+		break
+	}
+
+	name := string(s[1:i])
+	if name == "" {
+		return 0
+	} else if attribute && name[len(name)-1] != ';' && len(s) > i && s[i] == '=' {
+		return 0
+	} else if isEntityName(name) {
+		return i
+	} else if !attribute {
+		for j := min(len(name)-1, 6); j > 1; j-- {
+			if isEntityName(name[:j]) {
+				return j + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// isEntityName reports whether the name (including any trailing semicolon) is a named character reference. The
+// upstream table is not exported, so rely on how it would be unescaped.
+func isEntityName(name string) bool {
+	decoded := html.UnescapeString("&" + name)
+	if decoded == "&"+name {
+		return false
+	}
+
+	// otherwise, distinguish from a match of a shorter name which leaves the remainder
+	for j := min(len(name)-1, 6); j > 1; j-- {
+		if prefix := html.UnescapeString("&" + name[:j]); prefix != "&"+name[:j] && prefix+name[j:] == decoded {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newValueSegment returns the segment of an attribute value, excluding any quotes.
+func newValueSegment(raw []byte, offsets cursorio.TextOffsetRange, value string) *NodeTextSegment {
+	w := cursorio.NewTextWriter(offsets.From)
+
+	if len(raw) > 0 && (raw[0] == '"' || raw[0] == '\'') {
+		w.Write(raw[:1])
+
+		if len(raw) > 1 && raw[len(raw)-1] == raw[0] {
+			raw = raw[1 : len(raw)-1]
+		} else {
+			// unterminated
+			raw = raw[1:]
+		}
+	}
+
+	segment := &NodeTextSegment{
+		DataUntil: len(value),
+		Offsets:   w.WriteForOffsetRange(raw),
+	}
+
+	segment.Replacements, _ = mapTextReplacements(raw, segment.Offsets.From, value, textDecodingAttr)
+
+	return segment
+}
+
+// splitTextSegments returns the segments of data between any NUL characters, which are dropped from the data. The
+// replacements are relative to data.
+func splitTextSegments(data string, offsets cursorio.TextOffsetRange, replacements []NodeTextReplacement) []NodeTextSegment {
+	whole := NodeTextSegment{
+		DataUntil:    len(data),
+		Offsets:      offsets,
+		Replacements: replacements,
+	}
+
+	var segments []NodeTextSegment
+	var dropped int
+
+	for from := 0; from < len(data); {
+		until := strings.IndexByte(data[from:], 0)
+		if until == -1 {
+			until = len(data)
+		} else {
+			until += from
+		}
+
+		if from < until {
+			segment := NodeTextSegment{
+				DataFrom:  from - dropped,
+				DataUntil: until - dropped,
+			}
+
+			segment.Offsets.From, _ = whole.GetDataOffset(data, from)
+			segment.Offsets.Until, _ = whole.GetDataOffset(data, until)
+
+			for _, r := range replacements {
+				if r.DataFrom >= from && r.DataUntil <= until {
+					r.DataFrom -= dropped
+					r.DataUntil -= dropped
+					segment.Replacements = append(segment.Replacements, r)
+				}
+			}
+
+			segments = append(segments, segment)
+		}
+
+		// skip the NUL
+		from = until + 1
+		dropped++
+	}
+
+	return segments
+}
+
+func appendMarkerComment(buf []byte, kind byte, nodeKey int64) []byte {
+	buf = append(buf, "<!--"...)
+	buf = append(buf, kind)
+	buf = strconv.AppendInt(buf, nodeKey, 10)
+
+	return append(buf, "-->"...)
+}
+
+type scannedAttr struct {
+	key   [2]int
+	value [2]int
+}
+
+func isTagSpace(c byte) bool {
+	switch c {
+	case ' ', '\n', '\r', '\t', '\f':
+		return true
+	}
+
+	return false
+}
+
+// scanStartTag returns the raw index ranges of the tag name and attributes of a start tag token. Value ranges include
+// their quotes and are -1 when there was no value. It mirrors the upstream readTag behavior, so the attributes are
+// the same (and in the same order) as those of TagAttr.
+func scanStartTag(raw []byte) (nameRange [2]int, attrRanges []scannedAttr) {
+	i := 1
+
+	skipSpace := func() {
+		for i < len(raw) && isTagSpace(raw[i]) {
+			i++
+		}
+	}
+
+	// readTagName; the first byte is always part of the name
+	nameRange[0] = i
+	i = min(i+1, len(raw))
+
+	for i < len(raw) && !isTagSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' {
+		i++
+	}
+
+	nameRange[1] = i
+
+	skipSpace()
+
+	for i < len(raw) && raw[i] != '>' {
+		attr := scannedAttr{
+			key:   [2]int{i, i},
+			value: [2]int{-1, -1},
+		}
+
+		// readTagAttrKey
+		for ; i < len(raw); i++ {
+			c := raw[i]
+
+			if c == '=' && i == attr.key[0] {
+				// an equals sign before the name begins is part of the name
+				continue
+			} else if c == '=' || c == '/' || c == '>' || isTagSpace(c) {
+				break
+			}
+		}
+
+		attr.key[1] = i
+
+		// readTagAttrVal
+		skipSpace()
+
+		if i < len(raw) && raw[i] == '/' {
+			i++
+		} else if i < len(raw) && raw[i] == '=' {
+			i++
+
+			skipSpace()
+
+			if i < len(raw) {
+				switch quote := raw[i]; quote {
+				case '>':
+					// no value
+				case '\'', '"':
+					attr.value[0] = i
+					i++
+
+					for i < len(raw) && raw[i] != quote {
+						i++
+					}
+
+					// include the closing quote, unless it was unterminated
+					i = min(i+1, len(raw))
+					attr.value[1] = i
+				default:
+					attr.value[0] = i
+					i++
+
+					for i < len(raw) && !isTagSpace(raw[i]) && raw[i] != '>' {
+						i++
+					}
+
+					attr.value[1] = i
+				}
+			}
+		}
+
+		if attr.key[0] != attr.key[1] {
+			attrRanges = append(attrRanges, attr)
+		}
+
+		skipSpace()
+	}
+
+	return nameRange, attrRanges
+}
+
+// scanDoctype returns the raw index ranges of the name, public identifier, and system identifier of a doctype token.
+// Identifier ranges include their quotes. Unavailable ranges are -1. It mirrors the upstream parseDoctype behavior.
+func scanDoctype(raw []byte) (nameRange, publicRange, systemRange [2]int) {
+	nameRange, publicRange, systemRange = [2]int{-1, -1}, [2]int{-1, -1}, [2]int{-1, -1}
+
+	isSpace := func(c byte) bool {
+		switch c {
+		case ' ', '\t', '\r', '\n', '\f':
+			return true
+		}
+
+		return false
+	}
+
+	// <!DOCTYPE
+	i := len("<!doctype")
+	if len(raw) < i {
+		return
+	}
+
+	end := len(raw)
+	if raw[end-1] == '>' {
+		end--
+	}
+
+	for i < end && isSpace(raw[i]) {
+		i++
+	}
+
+	if i < end {
+		nameFrom := i
+		for i < end && !isSpace(raw[i]) {
+			i++
+		}
+
+		nameRange = [2]int{nameFrom, i}
+	}
+
+	for i < end && isSpace(raw[i]) {
+		i++
+	}
+
+	if end-i < 6 {
+		return
+	}
+
+	key := strings.ToLower(string(raw[i : i+6]))
+	i += 6
+
+	for key == "public" || key == "system" {
+		for i < end && isSpace(raw[i]) {
+			i++
+		}
+
+		if i >= end || (raw[i] != '"' && raw[i] != '\'') {
+			break
+		}
+
+		idFrom := i
+
+		q := bytes.IndexByte(raw[i+1:end], raw[i])
+		if q == -1 {
+			i = end
+		} else {
+			i += q + 2
+		}
+
+		if key == "public" {
+			publicRange = [2]int{idFrom, i}
+			key = "system"
+		} else {
+			systemRange = [2]int{idFrom, i}
+			key = ""
+		}
+	}
+
+	return
+}
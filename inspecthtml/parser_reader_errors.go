@@ -0,0 +1,342 @@
+package inspecthtml
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+)
+
+// tokenError is a parse error of the tokenizer within a raw token, as a range of byte indices.
+type tokenError struct {
+	code    DiagnosticCode
+	from    int
+	until   int
+	message string
+}
+
+// reportTokenErrors reports the parse errors of a raw token which started at the offset.
+func (r *parserReader) reportTokenErrors(raw []byte, from cursorio.TextOffset, errs []tokenError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].from < errs[j].from
+	})
+
+	w := cursorio.NewTextWriter(from)
+	var cursor int
+
+	for _, e := range errs {
+		w.Write(raw[cursor:e.from])
+		cursor = e.from
+
+		r.report(Diagnostic{
+			Code:     e.code,
+			Severity: DiagnosticSeverityWarning,
+			Message:  e.message,
+			Offsets:  cursorio.NewTextWriter(w.GetTextOffset()).WriteForOffsetRange(raw[e.from:e.until]),
+		})
+	}
+}
+
+// scanNullErrors returns an error for each NUL character.
+func scanNullErrors(errs []tokenError, raw []byte, from, until int) []tokenError {
+	for i := from; i < until; i++ {
+		if raw[i] == 0 {
+			errs = append(errs, tokenError{DiagnosticCodeUnexpectedNullCharacter, i, i + 1, "unexpected null character"})
+		}
+	}
+
+	return errs
+}
+
+// scanStartTagErrors returns the errors of a start tag token. The ranges are the same as scanStartTag, and attrKeys are
+// the names reported by the tokenizer.
+func scanStartTagErrors(raw []byte, nameRange [2]int, attrRanges []scannedAttr, attrKeys []string) []tokenError {
+	errs := scanNullErrors(nil, raw, 0, len(raw))
+	errs = scanTagAttrErrors(errs, raw, nameRange, attrRanges)
+
+	seen := map[string]bool{}
+
+	for i, attrKey := range attrKeys[:min(len(attrKeys), len(attrRanges))] {
+		if seen[attrKey] {
+			errs = append(errs, tokenError{DiagnosticCodeDuplicateAttribute, attrRanges[i].key[0], attrRanges[i].key[1], fmt.Sprintf("duplicate attribute: %s", attrKey)})
+		}
+
+		seen[attrKey] = true
+	}
+
+	return errs
+}
+
+// scanEndTagErrors returns the errors of an end tag token.
+func scanEndTagErrors(raw []byte) []tokenError {
+	errs := scanNullErrors(nil, raw, 0, len(raw))
+
+	// same rules as a start tag, after the solidus
+	nameRange, attrRanges := scanStartTag(raw[1:])
+	nameRange[0]++
+	nameRange[1]++
+
+	for i := range attrRanges {
+		attrRanges[i].key[0]++
+		attrRanges[i].key[1]++
+
+		if attrRanges[i].value[0] > -1 {
+			attrRanges[i].value[0]++
+			attrRanges[i].value[1]++
+		}
+	}
+
+	errs = scanTagAttrErrors(errs, raw, nameRange, attrRanges)
+
+	if len(attrRanges) > 0 {
+		last := attrRanges[len(attrRanges)-1]
+
+		until := last.key[1]
+		if last.value[0] > -1 {
+			until = last.value[1]
+		}
+
+		errs = append(errs, tokenError{DiagnosticCodeEndTagWithAttributes, attrRanges[0].key[0], until, "end tag with attributes"})
+	}
+
+	if bytes.HasSuffix(raw, []byte("/>")) {
+		// unless the solidus was part of an unquoted value
+		if len(attrRanges) == 0 || attrRanges[len(attrRanges)-1].value[1] != len(raw)-1 {
+			errs = append(errs, tokenError{DiagnosticCodeEndTagWithTrailingSolidus, len(raw) - 2, len(raw) - 1, "end tag with trailing solidus"})
+		}
+	}
+
+	return errs
+}
+
+// scanTagAttrErrors returns the errors of the attributes of a tag, and the space between them.
+func scanTagAttrErrors(errs []tokenError, raw []byte, nameRange [2]int, attrRanges []scannedAttr) []tokenError {
+	cursor := nameRange[1]
+
+	// a trailing solidus is expected for self-closing tags
+	end := len(raw)
+	if bytes.HasSuffix(raw, []byte("/>")) {
+		end -= 2
+	}
+
+	scanGap := func(until int) {
+		for ; cursor < until; cursor++ {
+			switch raw[cursor] {
+			case '/':
+				if cursor < end {
+					errs = append(errs, tokenError{DiagnosticCodeUnexpectedSolidusInTag, cursor, cursor + 1, "unexpected solidus in tag"})
+				}
+			case '=':
+				next := cursor + 1
+				for next < len(raw) && isTagSpace(raw[next]) {
+					next++
+				}
+
+				if next == len(raw) || raw[next] == '>' {
+					errs = append(errs, tokenError{DiagnosticCodeMissingAttributeValue, cursor, cursor + 1, "missing attribute value"})
+				}
+			}
+		}
+	}
+
+	for i, attr := range attrRanges {
+		scanGap(attr.key[0])
+
+		if raw[attr.key[0]] == '=' {
+			errs = append(errs, tokenError{DiagnosticCodeUnexpectedEqualsSignBeforeAttributeName, attr.key[0], attr.key[0] + 1, "unexpected equals sign before attribute name"})
+		}
+
+		for j := attr.key[0]; j < attr.key[1]; j++ {
+			switch raw[j] {
+			case '"', '\'', '<':
+				errs = append(errs, tokenError{DiagnosticCodeUnexpectedCharacterInAttributeName, j, j + 1, fmt.Sprintf("unexpected character in attribute name: %c", raw[j])})
+			}
+		}
+
+		if i > 0 {
+			if prev := attrRanges[i-1]; prev.value[0] > -1 && prev.value[1] == attr.key[0] && (raw[prev.value[0]] == '"' || raw[prev.value[0]] == '\'') {
+				errs = append(errs, tokenError{DiagnosticCodeMissingWhitespaceBetweenAttributes, attr.key[0], attr.key[0] + 1, "missing whitespace between attributes"})
+			}
+		}
+
+		cursor = attr.key[1]
+
+		if attr.value[0] < 0 {
+			continue
+		}
+
+		scanGap(attr.value[0])
+
+		if c := raw[attr.value[0]]; c != '"' && c != '\'' {
+			for j := attr.value[0]; j < attr.value[1]; j++ {
+				switch raw[j] {
+				case '"', '\'', '<', '=', '`':
+					errs = append(errs, tokenError{DiagnosticCodeUnexpectedCharacterInUnquotedAttributeValue, j, j + 1, fmt.Sprintf("unexpected character in unquoted attribute value: %c", raw[j])})
+				}
+			}
+		}
+
+		cursor = attr.value[1]
+	}
+
+	scanGap(end)
+
+	return errs
+}
+
+// scanCommentErrors returns the errors of a comment token, including bogus comments.
+func scanCommentErrors(raw []byte) []tokenError {
+	errs := scanNullErrors(nil, raw, 0, len(raw))
+
+	switch {
+	case bytes.HasPrefix(raw, []byte("<?")):
+		return append(errs, tokenError{DiagnosticCodeUnexpectedQuestionMarkInsteadOfTagName, 1, 2, "unexpected question mark instead of tag name"})
+	case bytes.Equal(raw, []byte("</>")):
+		return append(errs, tokenError{DiagnosticCodeMissingEndTagName, 0, 3, "missing end tag name"})
+	case bytes.HasPrefix(raw, []byte("</")):
+		return append(errs, tokenError{DiagnosticCodeInvalidFirstCharacterOfTagName, 2, 3, "invalid first character of tag name"})
+	case bytes.HasPrefix(raw, []byte("<![CDATA[")):
+		return append(errs, tokenError{DiagnosticCodeCDATAInHTMLContent, 0, 9, "CDATA section in HTML content"})
+	case !bytes.HasPrefix(raw, []byte("<!--")):
+		return append(errs, tokenError{DiagnosticCodeIncorrectlyOpenedComment, 0, 2, "incorrectly opened comment"})
+	case bytes.Equal(raw, []byte("<!-->")), bytes.Equal(raw, []byte("<!--->")):
+		return append(errs, tokenError{DiagnosticCodeAbruptClosingOfEmptyComment, 0, len(raw), "abrupt closing of empty comment"})
+	}
+
+	end := len(raw)
+
+	if bytes.HasSuffix(raw, []byte("--!>")) && len(raw) >= 8 {
+		end -= 4
+		errs = append(errs, tokenError{DiagnosticCodeIncorrectlyClosedComment, end, len(raw), "incorrectly closed comment"})
+	} else if bytes.HasSuffix(raw, []byte("-->")) && len(raw) >= 7 {
+		end -= 3
+	} else {
+		errs = append(errs, tokenError{DiagnosticCodeEOFInComment, len(raw), len(raw), "end of file in comment"})
+	}
+
+	for i := 4; i < end; {
+		j := bytes.Index(raw[i:end], []byte("<!--"))
+		if j < 0 {
+			break
+		}
+
+		i += j
+
+		// unless it was immediately closed
+		if i+4 < end {
+			errs = append(errs, tokenError{DiagnosticCodeNestedComment, i, i + 4, "nested comment"})
+		}
+
+		i += 4
+	}
+
+	return errs
+}
+
+// scanDoctypeErrors returns the errors of a doctype token. The name range is the same as scanDoctype.
+func scanDoctypeErrors(raw []byte, nameRange [2]int) []tokenError {
+	errs := scanNullErrors(nil, raw, 0, len(raw))
+
+	if len(raw) > 9 && !isTagSpace(raw[9]) && raw[9] != '>' {
+		errs = append(errs, tokenError{DiagnosticCodeMissingWhitespaceBeforeDoctypeName, 9, 10, "missing whitespace before doctype name"})
+	}
+
+	if nameRange[0] < 0 {
+		errs = append(errs, tokenError{DiagnosticCodeMissingDoctypeName, 0, len(raw), "missing doctype name"})
+	}
+
+	if len(raw) == 0 || raw[len(raw)-1] != '>' {
+		errs = append(errs, tokenError{DiagnosticCodeEOFInDoctype, len(raw), len(raw), "end of file in doctype"})
+	}
+
+	return errs
+}
+
+// scanTextErrors returns the errors of a text token. Within raw text (or RCDATA), a less-than sign is not an error.
+func scanTextErrors(raw []byte, rawText bool) []tokenError {
+	if bytes.HasPrefix(raw, []byte("<![CDATA[")) {
+		// same as upstream, which allows anything within a CDATA section
+		return nil
+	}
+
+	errs := scanNullErrors(nil, raw, 0, len(raw))
+
+	if rawText {
+		return errs
+	}
+
+	for i := bytes.IndexByte(raw, '<'); i > -1 && i < len(raw); {
+		if i+1 == len(raw) || raw[i+1] == '/' && i+2 == len(raw) {
+			// a text token only ends with a less-than sign at EOF
+			errs = append(errs, tokenError{DiagnosticCodeEOFBeforeTagName, i, len(raw), "end of file before tag name"})
+		} else if c := raw[i+1]; c != '/' && c != '!' && c != '?' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			errs = append(errs, tokenError{DiagnosticCodeInvalidFirstCharacterOfTagName, i + 1, i + 2, "invalid first character of tag name"})
+		}
+
+		j := bytes.IndexByte(raw[i+1:], '<')
+		if j < 0 {
+			break
+		}
+
+		i += j + 1
+	}
+
+	return errs
+}
+
+// scanCharacterReferenceErrors returns the errors of the character references within the replacements. The raw token
+// started at the offset.
+func scanCharacterReferenceErrors(errs []tokenError, raw []byte, from cursorio.TextOffset, replacements []NodeTextReplacement) []tokenError {
+	for _, r := range replacements {
+		if !r.CharacterReference {
+			continue
+		}
+
+		i, j := int(r.Offsets.From.Byte-from.Byte), int(r.Offsets.Until.Byte-from.Byte)
+		if i < 0 || j > len(raw) || i >= j {
+			continue
+		}
+
+		ref := raw[i:j]
+
+		if ref[len(ref)-1] != ';' {
+			errs = append(errs, tokenError{DiagnosticCodeMissingSemicolonAfterCharacterReference, i, j, "missing semicolon after character reference"})
+		}
+
+		if len(ref) < 3 || ref[1] != '#' {
+			continue
+		}
+
+		digits, base := bytes.TrimSuffix(ref[2:], []byte(";")), 10
+		if len(digits) > 0 && (digits[0] == 'x' || digits[0] == 'X') {
+			digits, base = digits[1:], 16
+		}
+
+		v, err := strconv.ParseUint(string(digits), base, 32)
+		if err != nil {
+			// overflow
+			v = 0x110000
+		}
+
+		switch {
+		case v == 0:
+			errs = append(errs, tokenError{DiagnosticCodeNullCharacterReference, i, j, "null character reference"})
+		case v > 0x10ffff:
+			errs = append(errs, tokenError{DiagnosticCodeCharacterReferenceOutsideUnicodeRange, i, j, "character reference outside unicode range"})
+		case 0xd800 <= v && v <= 0xdfff:
+			errs = append(errs, tokenError{DiagnosticCodeSurrogateCharacterReference, i, j, "surrogate character reference"})
+		case 0xfdd0 <= v && v <= 0xfdef, v&0xfffe == 0xfffe:
+			errs = append(errs, tokenError{DiagnosticCodeNoncharacterCharacterReference, i, j, "noncharacter character reference"})
+		case v == 0x0d, v < 0x20 && v != '\t' && v != '\n' && v != '\f', 0x7f <= v && v <= 0x9f:
+			errs = append(errs, tokenError{DiagnosticCodeControlCharacterReference, i, j, "control character reference"})
+		}
+	}
+
+	return errs
+}
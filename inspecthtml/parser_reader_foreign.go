@@ -0,0 +1,541 @@
+package inspecthtml
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// parserForeignElement is an open element of foreign content (i.e. SVG or MathML), or an HTML element within one of its
+// integration points. It approximates the stack of open elements upstream, which is needed since the tokenizer state
+// (i.e. raw text and CDATA) depends on it.
+type parserForeignElement struct {
+	name      string // lowercase, as tokenized
+	namespace string // svg, math, or empty for HTML
+
+	htmlIntegrationPoint       bool
+	mathMLTextIntegrationPoint bool
+}
+
+// https://html.spec.whatwg.org/multipage/parsing.html#parsing-main-inforeign
+var foreignBreakoutAtoms = map[atom.Atom]bool{
+	atom.B: true, atom.Big: true, atom.Blockquote: true, atom.Body: true, atom.Br: true, atom.Center: true,
+	atom.Code: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Em: true, atom.Embed: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Head: true,
+	atom.Hr: true, atom.I: true, atom.Img: true, atom.Li: true, atom.Listing: true, atom.Menu: true, atom.Meta: true,
+	atom.Nobr: true, atom.Ol: true, atom.P: true, atom.Pre: true, atom.Ruby: true, atom.S: true, atom.Small: true,
+	atom.Span: true, atom.Strong: true, atom.Strike: true, atom.Sub: true, atom.Sup: true, atom.Table: true,
+	atom.Tt: true, atom.U: true, atom.Ul: true, atom.Var: true,
+}
+
+// https://html.spec.whatwg.org/multipage/syntax.html#void-elements
+func isVoidAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img, atom.Input, atom.Keygen, atom.Link,
+		atom.Meta, atom.Param, atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+
+	return false
+}
+
+func newParserForeignElement(name, namespace string, attrKeys, attrValues []string) parserForeignElement {
+	e := parserForeignElement{
+		name:      name,
+		namespace: namespace,
+	}
+
+	switch namespace {
+	case "svg":
+		switch name {
+		case "desc", "foreignobject", "title":
+			e.htmlIntegrationPoint = true
+		}
+	case "math":
+		switch name {
+		case "mi", "mo", "mn", "ms", "mtext":
+			e.mathMLTextIntegrationPoint = true
+		case "annotation-xml":
+			for i, key := range attrKeys {
+				if key == "encoding" && (strings.EqualFold(attrValues[i], "text/html") || strings.EqualFold(attrValues[i], "application/xhtml+xml")) {
+					e.htmlIntegrationPoint = true
+				}
+			}
+		}
+	}
+
+	return e
+}
+
+// adjustedCurrentForeignElement returns the current element, or the fragment context if there are none. It returns nil
+// when not within foreign content.
+func (r *parserReader) adjustedCurrentForeignElement() *parserForeignElement {
+	if len(r.foreign) > 0 {
+		return &r.foreign[len(r.foreign)-1]
+	}
+
+	return r.foreignContext
+}
+
+// isForeignContent reports whether a token would be processed by the rules for foreign content. For start tags, name is
+// the lowercase tag name.
+func (r *parserReader) isForeignContent(tt html.TokenType, name string) bool {
+	e := r.adjustedCurrentForeignElement()
+	if e == nil || e.namespace == "" {
+		return false
+	}
+
+	if e.mathMLTextIntegrationPoint {
+		if tt == html.StartTagToken && name != "mglyph" && name != "malignmark" {
+			return false
+		} else if tt == html.TextToken {
+			return false
+		}
+	}
+
+	if e.namespace == "math" && e.name == "annotation-xml" && tt == html.StartTagToken && name == "svg" {
+		return false
+	}
+
+	if e.htmlIntegrationPoint && (tt == html.StartTagToken || tt == html.TextToken) {
+		return false
+	}
+
+	return true
+}
+
+// pushForeignStartTag updates the open foreign elements for a start tag and reports whether the element is foreign, in
+// which case its content is never raw text.
+//
+// The open HTML elements follow the elements which are closed implicitly upstream (e.g. a p by a div), but they are
+// still an approximation which may disagree with html.Parse for:
+//   - a select within an integration point, which is tracked with the foreign elements rather than as a select;
+//   - elements closed by the adoption agency algorithm, or by the end tag of an implied element (e.g. </tbody>);
+//   - a p closed by a table, which depends on the quirks mode of the document;
+//   - a form ignored within another form, or closed by </form> without its descendants;
+//   - a frameset within a template, which upstream may still use to replace the body;
+//   - the context element of a fragment, which is not an open element.
+func (r *parserReader) pushForeignStartTag(name string, attrKeys, attrValues []string, selfClosing bool) bool {
+	tagAtom := atom.Lookup([]byte(name))
+
+	if r.isForeignContent(html.StartTagToken, name) {
+		breakout := foreignBreakoutAtoms[tagAtom]
+		if tagAtom == atom.Font {
+			for _, key := range attrKeys {
+				switch key {
+				case "color", "face", "size":
+					breakout = true
+				}
+			}
+		}
+
+		if !breakout || r.fragment {
+			namespace := r.adjustedCurrentForeignElement().namespace
+
+			if !selfClosing {
+				r.foreign = append(r.foreign, newParserForeignElement(name, namespace, attrKeys, attrValues))
+			}
+
+			return true
+		}
+
+		// the element is reprocessed as HTML
+		r.popForeignBreakout()
+	}
+
+	if i := r.openSelectIndex(); i >= 0 {
+		switch tagAtom {
+		case atom.Select:
+			// same as upstream, which closes the select rather than opening another
+			r.openHTML = r.openHTML[:i]
+
+			return false
+		case atom.Input, atom.Keygen, atom.Textarea:
+			// same as upstream, which closes the select and then reprocesses the tag
+			r.openHTML = r.openHTML[:i]
+		case atom.Caption, atom.Table, atom.Tbody, atom.Tfoot, atom.Thead, atom.Tr, atom.Td, atom.Th:
+			if !slices.Contains(r.openHTML[:i], "table") {
+				// same as upstream, which ignores them within a select outside of a table
+				return false
+			}
+
+			// same as upstream, which closes a select within a table and then reprocesses the tag
+			r.openHTML = r.openHTML[:i]
+		case atom.Option, atom.Optgroup, atom.Script, atom.Template:
+			// inserted within the select
+		default:
+			// same as upstream, which ignores other start tags within a select (including svg and math)
+			return false
+		}
+	}
+
+	if r.frameset {
+		switch tagAtom {
+		case atom.Frameset, atom.Noframes:
+			r.openHTML = append(r.openHTML, name)
+		}
+
+		// same as upstream, which ignores other start tags within or after a frameset (including svg and math)
+		return false
+	} else if tagAtom == atom.Frameset {
+		if !r.framesetNotOK && !r.fragment && !slices.Contains(r.openHTML, "template") {
+			// same as upstream, which replaces the body (and any of its open elements) with the frameset
+			r.frameset = true
+			r.foreign = r.foreign[:0]
+			r.openHTML = append(r.openHTML[:0], name)
+		}
+
+		return false
+	} else if tagAtom == atom.Frame {
+		// same as upstream, which ignores it outside of a frameset
+		return false
+	} else if isFramesetNotOKStartTag(tagAtom, attrKeys, attrValues) {
+		r.framesetNotOK = true
+	}
+
+	switch tagAtom {
+	case atom.Svg, atom.Math:
+		if !selfClosing {
+			r.foreign = append(r.foreign, newParserForeignElement(name, name, attrKeys, attrValues))
+		}
+
+		return true
+	}
+
+	if len(r.foreign) > 0 {
+		if !isVoidAtom(tagAtom) {
+			// within an integration point; track it to know when the integration point is closed
+			r.foreign = append(r.foreign, newParserForeignElement(name, "", attrKeys, attrValues))
+		}
+
+		return false
+	}
+
+	if r.isIgnoredTableStartTag(tagAtom) {
+		return false
+	}
+
+	r.closeImpliedOpenHTML(tagAtom)
+
+	switch {
+	case tagAtom == atom.Html, tagAtom == atom.Head, tagAtom == atom.Body:
+		// same as upstream, where they are below any other open elements (or ignored within the body)
+	case !isVoidAtom(tagAtom):
+		r.openHTML = append(r.openHTML, name)
+	}
+
+	return false
+}
+
+// isIgnoredTableStartTag reports whether a start tag of the table structure is ignored upstream since it is not within
+// a table (e.g. a td within a div). The context of a fragment is unknown, so they are never ignored within one.
+func (r *parserReader) isIgnoredTableStartTag(tagAtom atom.Atom) bool {
+	switch tagAtom {
+	case atom.Caption, atom.Colgroup, atom.Tbody, atom.Td, atom.Tfoot, atom.Th, atom.Thead, atom.Tr:
+		if r.fragment {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for i := len(r.openHTML) - 1; i >= 0; i-- {
+		switch r.openHTML[i] {
+		case "table":
+			return false
+		case "template":
+			// the content of a template may be the structure of a table, unless it already has other content
+			return i != len(r.openHTML)-1
+		}
+	}
+
+	return true
+}
+
+// closeImpliedOpenHTML closes the HTML elements which are closed implicitly upstream by a start tag (e.g. a p by a div,
+// or an li by another li), so they do not affect how later tags are tracked.
+func (r *parserReader) closeImpliedOpenHTML(tagAtom atom.Atom) {
+	switch tagAtom {
+	case atom.Li:
+		r.closeOpenHTMLListItem("li")
+	case atom.Dd, atom.Dt:
+		r.closeOpenHTMLListItem("dd", "dt")
+	case atom.Button:
+		r.closeOpenHTMLInScope("button", isDefaultScopeAtom)
+	case atom.Table:
+		// within a table (rather than a cell or caption), upstream closes the current table before opening another
+		for i := len(r.openHTML) - 1; i >= 0; i-- {
+			switch r.openHTML[i] {
+			case "caption", "td", "th", "template":
+				return
+			case "table":
+				r.openHTML = r.openHTML[:i]
+
+				return
+			}
+		}
+
+		return
+	}
+
+	if isClosingPAtom(tagAtom) {
+		r.closeOpenHTMLInScope("p", isButtonScopeAtom)
+	}
+
+	if n := len(r.openHTML); n > 0 && isHeadingAtom(tagAtom) && isHeadingAtom(atom.Lookup([]byte(r.openHTML[n-1]))) {
+		// same as upstream, which does not nest headings
+		r.openHTML = r.openHTML[:n-1]
+	}
+}
+
+// closeOpenHTMLInScope closes the most recent HTML element of the name, unless an element of the scope is more recent.
+func (r *parserReader) closeOpenHTMLInScope(name string, isScopeAtom func(a atom.Atom) bool) {
+	for i := len(r.openHTML) - 1; i >= 0; i-- {
+		if r.openHTML[i] == name {
+			r.openHTML = r.openHTML[:i]
+
+			return
+		} else if isScopeAtom(atom.Lookup([]byte(r.openHTML[i]))) {
+			return
+		}
+	}
+}
+
+// closeOpenHTMLListItem closes the most recent HTML element of the names, unless a special element (other than address,
+// div, or p) is more recent. Any p is then closed, the same as other elements which close a p.
+//
+// https://html.spec.whatwg.org/multipage/parsing.html#parsing-main-inbody
+func (r *parserReader) closeOpenHTMLListItem(names ...string) {
+	for i := len(r.openHTML) - 1; i >= 0; i-- {
+		if slices.Contains(names, r.openHTML[i]) {
+			r.openHTML = r.openHTML[:i]
+
+			break
+		} else if a := atom.Lookup([]byte(r.openHTML[i])); isSpecialAtom(a) && a != atom.Address && a != atom.Div && a != atom.P {
+			break
+		}
+	}
+
+	r.closeOpenHTMLInScope("p", isButtonScopeAtom)
+}
+
+// openSelectIndex returns the index of an open select within openHTML, or -1 if there is none. Elements within a select
+// are mostly ignored upstream, including the start of foreign content. A template starts new content, so a select outside
+// of it is ignored.
+func (r *parserReader) openSelectIndex() int {
+	if len(r.foreign) > 0 {
+		return -1
+	}
+
+	for i := len(r.openHTML) - 1; i >= 0; i-- {
+		switch r.openHTML[i] {
+		case "select":
+			return i
+		case "template":
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// isIgnoredRawTextInSelect reports whether a start tag of a raw text element is ignored upstream since it is within a
+// select, in which case its content is not raw text.
+func (r *parserReader) isIgnoredRawTextInSelect(tagAtom atom.Atom) bool {
+	switch tagAtom {
+	case atom.Iframe, atom.Noembed, atom.Noframes, atom.Noscript, atom.Plaintext, atom.Style, atom.Title, atom.Xmp:
+		return r.openSelectIndex() >= 0
+	}
+
+	return false
+}
+
+// popForeignBreakout closes foreign elements until an HTML element or integration point.
+func (r *parserReader) popForeignBreakout() {
+	for len(r.foreign) > 0 {
+		if e := r.foreign[len(r.foreign)-1]; e.namespace == "" || e.htmlIntegrationPoint || e.mathMLTextIntegrationPoint {
+			break
+		}
+
+		r.foreign = r.foreign[:len(r.foreign)-1]
+	}
+}
+
+// popForeignEndTag updates the open foreign elements for an end tag. The name is the lowercase tag name.
+func (r *parserReader) popForeignEndTag(name string) {
+	if len(r.foreign) == 0 {
+		r.popOpenHTML(name)
+
+		return
+	}
+
+	if name == "br" || name == "p" {
+		// same as upstream, which reprocesses them as HTML (i.e. a br start tag or an implied p start tag)
+		r.popForeignBreakout()
+	} else if r.foreign[len(r.foreign)-1].namespace != "" {
+		// foreign content; otherwise, or if nothing matched before reaching an HTML element, it is processed as HTML
+		for i := len(r.foreign) - 1; i >= 0; i-- {
+			if r.foreign[i].name == name {
+				r.foreign = r.foreign[:i]
+
+				return
+			} else if i > 0 && r.foreign[i-1].namespace == "" {
+				break
+			}
+		}
+	}
+
+	for i := len(r.foreign) - 1; i >= 0; i-- {
+		if e := r.foreign[i]; e.htmlIntegrationPoint || e.mathMLTextIntegrationPoint {
+			// special elements; the end tag is ignored
+			return
+		} else if e.namespace == "" && e.name == name {
+			r.foreign = r.foreign[:i]
+
+			return
+		}
+	}
+
+	// otherwise, it closes an element outside of the foreign content if one is open; or it is ignored
+	if r.popOpenHTML(name) {
+		r.foreign = r.foreign[:0]
+	}
+}
+
+// popOpenHTML closes the most recent HTML element of the name and reports whether there was one. It is ignored when the
+// element is not in scope, or when within a select which ignores the end tag.
+func (r *parserReader) popOpenHTML(name string) bool {
+	nameAtom := atom.Lookup([]byte(name))
+	nameSpecial := isSpecialAtom(nameAtom)
+	nameTable := isTableStructureAtom(nameAtom)
+
+	if i := r.openSelectIndex(); i >= 0 {
+		switch {
+		case nameAtom == atom.Select, nameAtom == atom.Option, nameAtom == atom.Optgroup, nameAtom == atom.Template:
+			// processed within the select
+		case nameAtom == atom.Script:
+			// same as upstream, which closes it after its raw text
+		case nameTable && slices.Contains(r.openHTML[:i], "table"):
+			// same as upstream, which closes a select within a table and then reprocesses the tag
+		default:
+			// same as upstream, which ignores other end tags within a select
+			return false
+		}
+	}
+
+	for i := len(r.openHTML) - 1; i >= 0; i-- {
+		a := atom.Lookup([]byte(r.openHTML[i]))
+
+		if r.openHTML[i] == name || isHeadingAtom(nameAtom) && isHeadingAtom(a) {
+			// same as upstream, where the end tag of any heading closes another
+			r.openHTML = r.openHTML[:i]
+
+			return true
+		}
+
+		if nameTable {
+			// same as upstream, which closes the current cell or section to reach it in table scope
+			if a == atom.Html || a == atom.Table || a == atom.Template {
+				return false
+			}
+		} else if nameSpecial && isDefaultScopeAtom(a) || !nameSpecial && isSpecialAtom(a) {
+			return false
+		}
+	}
+
+	return false
+}
+
+// isFramesetNotOKStartTag reports whether a start tag is content of the body which prevents a frameset from replacing
+// it, the same as the frameset-ok flag upstream.
+//
+// https://html.spec.whatwg.org/multipage/parsing.html#frameset-ok-flag
+func isFramesetNotOKStartTag(a atom.Atom, attrKeys, attrValues []string) bool {
+	switch a {
+	case atom.Input:
+		for i, key := range attrKeys {
+			if key == "type" && strings.EqualFold(attrValues[i], "hidden") {
+				return false
+			}
+		}
+
+		return true
+	case atom.Applet, atom.Area, atom.Body, atom.Br, atom.Button, atom.Dd, atom.Dt, atom.Embed, atom.Hr, atom.Iframe,
+		atom.Image, atom.Img, atom.Keygen, atom.Li, atom.Listing, atom.Marquee, atom.Object, atom.Pre, atom.Select,
+		atom.Table, atom.Textarea, atom.Wbr, atom.Xmp:
+		return true
+	}
+
+	return false
+}
+
+// isTableStructureAtom reports whether an end tag is processed by the table insertion modes, which use table scope.
+func isTableStructureAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Caption, atom.Table, atom.Tbody, atom.Tfoot, atom.Thead, atom.Tr, atom.Td, atom.Th:
+		return true
+	}
+
+	return false
+}
+
+// isClosingPAtom reports whether a start tag closes a p in button scope. The table is excluded since it depends on the
+// quirks mode of the document.
+//
+// https://html.spec.whatwg.org/multipage/parsing.html#parsing-main-inbody
+func isClosingPAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Address, atom.Article, atom.Aside, atom.Blockquote, atom.Center, atom.Details, atom.Dialog, atom.Dir,
+		atom.Div, atom.Dl, atom.Fieldset, atom.Figcaption, atom.Figure, atom.Footer, atom.Header, atom.Hgroup, atom.Main,
+		atom.Menu, atom.Nav, atom.Ol, atom.P, atom.Search, atom.Section, atom.Summary, atom.Ul, atom.H1, atom.H2, atom.H3,
+		atom.H4, atom.H5, atom.H6, atom.Pre, atom.Listing, atom.Plaintext, atom.Hr, atom.Xmp:
+		return true
+	}
+
+	return false
+}
+
+func isHeadingAtom(a atom.Atom) bool {
+	switch a {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+
+	return false
+}
+
+// https://html.spec.whatwg.org/multipage/parsing.html#has-an-element-in-button-scope
+func isButtonScopeAtom(a atom.Atom) bool {
+	return a == atom.Button || isDefaultScopeAtom(a)
+}
+
+// https://html.spec.whatwg.org/multipage/parsing.html#has-an-element-in-scope
+func isDefaultScopeAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Applet, atom.Caption, atom.Html, atom.Table, atom.Td, atom.Th, atom.Marquee, atom.Object, atom.Template:
+		return true
+	}
+
+	return false
+}
+
+// https://html.spec.whatwg.org/multipage/parsing.html#special
+func isSpecialAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Address, atom.Applet, atom.Area, atom.Article, atom.Aside, atom.Base, atom.Basefont, atom.Bgsound,
+		atom.Blockquote, atom.Body, atom.Br, atom.Button, atom.Caption, atom.Center, atom.Col, atom.Colgroup, atom.Dd,
+		atom.Details, atom.Dir, atom.Div, atom.Dl, atom.Dt, atom.Embed, atom.Fieldset, atom.Figcaption, atom.Figure,
+		atom.Footer, atom.Form, atom.Frame, atom.Frameset, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Head, atom.Header, atom.Hgroup, atom.Hr, atom.Html, atom.Iframe, atom.Img, atom.Input, atom.Keygen,
+		atom.Li, atom.Link, atom.Listing, atom.Main, atom.Marquee, atom.Menu, atom.Meta, atom.Nav, atom.Noembed,
+		atom.Noframes, atom.Noscript, atom.Object, atom.Ol, atom.P, atom.Param, atom.Plaintext, atom.Pre, atom.Script,
+		atom.Section, atom.Select, atom.Source, atom.Style, atom.Summary, atom.Table, atom.Tbody, atom.Td,
+		atom.Template, atom.Textarea, atom.Tfoot, atom.Th, atom.Thead, atom.Title, atom.Tr, atom.Track, atom.Ul,
+		atom.Wbr, atom.Xmp:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package inspecthtml
+
+import (
+	"sort"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+// rebuildSyntaxTree links the syntax nodes which were recorded by the reader to the nodes they resulted in. It relies on
+// the provenance and merged start tags, so the metadata must already be finalized.
+func (p *Parser) rebuildSyntaxTree(root *html.Node) {
+	nodeByMetadata := map[*NodeMetadata]*html.Node{}
+	nodeByEndTag := map[*cursorio.TextOffsetRange]*html.Node{}
+
+	// text may have been merged (e.g. with whitespace), in which case the metadata is not the same as the token's
+	var textSegments []parserSyntaxTextSegment
+
+	var visitNode func(n *html.Node)
+	visitNode = func(n *html.Node) {
+		if v, ok := p.offsets.GetNodeMetadata(n); ok {
+			if v.EndTagTokenOffsets != nil && v.ImpliedEndTagReason == 0 {
+				// clones share the end tag of their original, but it was the last one which was closed by it
+				nodeByEndTag[v.EndTagTokenOffsets] = n
+			}
+
+			if _, known := nodeByMetadata[v]; !known && p.offsets.GetNodeProvenance(n) != NodeProvenanceCloned {
+				nodeByMetadata[v] = n
+			}
+
+			for _, merged := range v.MergedStartTags {
+				nodeByMetadata[merged] = n
+			}
+
+			for _, segment := range v.TextSegments {
+				textSegments = append(textSegments, parserSyntaxTextSegment{
+					offsets: segment.Offsets,
+					node:    n,
+				})
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visitNode(c)
+		}
+	}
+
+	visitNode(root)
+
+	// segments do not overlap, so they are also ordered by their end
+	sort.Slice(textSegments, func(i, j int) bool {
+		return textSegments[i].offsets.From.Byte < textSegments[j].offsets.From.Byte
+	})
+
+	tree := &SyntaxTree{
+		Nodes: make([]*SyntaxNode, len(p.r.syntaxNodes)),
+	}
+
+	for i, sn := range p.r.syntaxNodes {
+		tree.Nodes[i] = sn.node
+
+		var metadata *NodeMetadata
+
+		if sn.nodeKey < len(p.r.nodes) {
+			v := p.r.nodes[sn.nodeKey]
+
+			if v.metadata != nil && v.metadata.TokenOffsets.From.Byte == sn.node.Offsets.From.Byte {
+				metadata = v.metadata
+				sn.node.Node = nodeByMetadata[v.metadata]
+			} else if v.offsets != nil && v.offsets.From.Byte == sn.node.Offsets.From.Byte {
+				sn.node.Node = nodeByEndTag[v.offsets]
+			}
+		}
+
+		if sn.node.Node == nil && sn.node.Type == html.TextToken {
+			from, until := sn.node.Offsets.From.Byte, sn.node.Offsets.Until.Byte
+
+			j := sort.Search(len(textSegments), func(j int) bool {
+				return textSegments[j].offsets.Until.Byte > from
+			})
+
+			if j < len(textSegments) && textSegments[j].offsets.From.Byte < until {
+				sn.node.Node = textSegments[j].node
+			}
+		}
+
+		p.linkSyntaxNode(sn.node, metadata)
+	}
+
+	p.offsets.syntaxTree = tree
+}
+
+// linkSyntaxNode updates the tokens to refer to the node. The attribute index of tokens is converted from the order
+// within the start tag to the index within the attributes of the node, based on the offsets of its name.
+func (p *Parser) linkSyntaxNode(sn *SyntaxNode, metadata *NodeMetadata) {
+	var attrIndexByKeyFrom map[int64]int
+
+	if sn.Node != nil && metadata != nil && (sn.Type == html.StartTagToken || sn.Type == html.SelfClosingTagToken) {
+		if v, ok := p.offsets.GetNodeMetadata(sn.Node); ok {
+			attrIndexByKeyFrom = make(map[int64]int, len(v.TagAttr))
+
+			for attrIdx, attr := range v.TagAttr[:min(len(v.TagAttr), len(sn.Node.Attr))] {
+				attrIndexByKeyFrom[attr.KeyOffsets.From.Byte] = attrIdx
+			}
+		}
+	}
+
+	// the tokens of an attribute start with its name
+	attrIndex := -1
+
+	for i := range sn.Tokens {
+		token := &sn.Tokens[i]
+		token.Node = sn.Node
+
+		if token.AttrIndex < 0 {
+			continue
+		} else if token.Kind == SyntaxTokenAttrName {
+			attrIndex = -1
+
+			if v, ok := attrIndexByKeyFrom[token.Offsets.From.Byte]; ok {
+				attrIndex = v
+			}
+		}
+
+		token.AttrIndex = attrIndex
+	}
+}
+
+type parserSyntaxTextSegment struct {
+	offsets cursorio.TextOffsetRange
+	node    *html.Node
+}
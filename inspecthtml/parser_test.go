@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 
 	"github.com/dpb587/cursorio-go/cursorio"
 	"golang.org/x/net/html"
@@ -361,6 +363,115 @@ func TestReaderTagAttrNullOther(t *testing.T) {
 	}
 }
 
+func TestReaderTagAttrMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected [][2]string
+	}{
+		{
+			name:     "quote within quoted value",
+			input:    `<script async src="https://example.com/asset?shop="quoteful.example.com"></script>`,
+			expected: [][2]string{{"async", ""}, {"src", `"https://example.com/asset?shop="`}, {`quoteful.example.com"`, ""}},
+		},
+		{
+			name:     "duplicate",
+			input:    `<p title=a title="b">`,
+			expected: [][2]string{{"title", "a"}, {"title", `"b"`}},
+		},
+		{
+			name:     "solidus within unquoted value",
+			input:    `<a href=/path/to/ data-x=y/>`,
+			expected: [][2]string{{"href", "/path/to/"}, {"data-x", "y/"}},
+		},
+		{
+			name:     "missing whitespace",
+			input:    `<p a="x"b='y'c=z>`,
+			expected: [][2]string{{"a", `"x"`}, {"b", `'y'`}, {"c", "z"}},
+		},
+		{
+			name:     "solidus between",
+			input:    `<p a/b / c = "d">`,
+			expected: [][2]string{{"a", ""}, {"b", ""}, {"c", `"d"`}},
+		},
+		{
+			name:     "leading equals",
+			input:    `<p =a=b ==c>`,
+			expected: [][2]string{{"=a", "b"}, {"=", "c"}},
+		},
+		{
+			name:     "empty value before end",
+			input:    `<p a=>x`,
+			expected: [][2]string{{"a", ""}},
+		},
+		{
+			name:     "unterminated quote",
+			input:    `<p a="x>y`,
+			expected: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if _a, _e := len(documentOffsets.GetDiagnostics()), 0; _a != _e {
+				t.Fatalf("diagnostics: expected %v, got %v", _e, documentOffsets.GetDiagnostics())
+			}
+
+			var found bool
+
+			visitNode(document, func(n *html.Node) {
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok || n.Type != html.ElementNode || found {
+					return
+				}
+
+				found = true
+
+				if _a, _e := len(np.TagAttr), len(n.Attr); _a != _e {
+					t.Fatalf("attr metadata: expected %v, got %v", _e, _a)
+				} else if _a, _e := len(np.TagAttr), len(tc.expected); _a != _e {
+					t.Fatalf("attr: expected %v, got %v", _e, _a)
+				}
+
+				for i, e := range tc.expected {
+					attr := np.TagAttr[i]
+
+					if _a, _e := tc.input[attr.KeyOffsets.From.Byte:attr.KeyOffsets.Until.Byte], e[0]; _a != _e {
+						t.Errorf("attr %d: key: expected %q, got %q", i, _e, _a)
+					}
+
+					var value string
+					if attr.ValueOffsets != nil {
+						value = tc.input[attr.ValueOffsets.From.Byte:attr.ValueOffsets.Until.Byte]
+					}
+
+					if _a, _e := value, e[1]; _a != _e {
+						t.Errorf("attr %d: value: expected %q, got %q", i, _e, _a)
+					}
+				}
+			})
+
+			if len(tc.expected) > 0 && !found {
+				t.Fatal("expected element")
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
 func TestReaderTagAttrUnquoted(t *testing.T) {
 	document, documentOffsets, err := Parse(strings.NewReader("<html><body><p title=none>hello</p></body></html>"))
 	if err != nil {
@@ -2500,6 +2611,38 @@ func TestReaderPreLeadingNewline(t *testing.T) {
 	}
 }
 
+func TestReaderPreLeadingNewlineAfterIgnored(t *testing.T) {
+	// ignored end tags are not children, so the newline is still leading upstream
+	for _, input := range []string{
+		"<pre></span>\n<b>x</b></pre>",
+		"<pre></title>\n",
+		"<pre></span>\nx</pre>",
+		"<listing></title></b>\r\n\n<i>x</i></listing>",
+		"<pre><!--c-->\nx</pre>",
+		"<pre></span><!--c-->\n<b>x</b></pre>",
+	} {
+		t.Run(input, func(t *testing.T) {
+			document, _, err := Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %q, got %q", _e, _a)
+			}
+		})
+	}
+}
+
 func TestReaderBOMTextMerge(t *testing.T) {
 	// A UTF-8 BOM (\xef\xbb\xbf / U+FEFF) is not in the HTML whitespace set
 	// (" \t\r\n\f"), so the standard html.Parse treats it as non-whitespace text
@@ -2700,3 +2843,1811 @@ func TestReaderCommentEndBang(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFragment(t *testing.T) {
+	input := "<li class=\"a\">one<li>two"
+	context := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Ul,
+		Data:     "ul",
+	}
+
+	nodes, documentOffsets, err := ParseFragment(strings.NewReader(input), context)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := len(nodes), 2; _a != _e {
+		t.Fatalf("nodes: expected %v, got %v", _e, _a)
+	}
+
+	for i, expectedOuter := range []cursorio.TextOffsetRange{
+		{
+			From:  cursorio.TextOffset{Byte: 0, LineColumn: cursorio.TextLineColumn{0, 0}},
+			Until: cursorio.TextOffset{Byte: 17, LineColumn: cursorio.TextLineColumn{0, 17}},
+		},
+		{
+			From:  cursorio.TextOffset{Byte: 17, LineColumn: cursorio.TextLineColumn{0, 17}},
+			Until: cursorio.TextOffset{Byte: 24, LineColumn: cursorio.TextLineColumn{0, 24}},
+		},
+	} {
+		np, ok := documentOffsets.GetNodeMetadata(nodes[i])
+		if !ok {
+			t.Fatalf("node[%d]: expected metadata", i)
+		} else if _a, _e := np.GetOuterOffsets(), expectedOuter; _a != _e {
+			t.Errorf("node[%d]: outer: expected %v, got %v", i, _e, _a)
+		} else if nodes[i].Parent != nil || nodes[i].PrevSibling != nil || nodes[i].NextSibling != nil {
+			t.Errorf("node[%d]: expected detached node", i)
+		}
+	}
+
+	np, ok := documentOffsets.GetNodeMetadata(nodes[0].FirstChild)
+	if !ok {
+		t.Fatal("expected text metadata")
+	} else if _a, _e := np.TokenOffsets, (cursorio.TextOffsetRange{
+		From:  cursorio.TextOffset{Byte: 14, LineColumn: cursorio.TextLineColumn{0, 14}},
+		Until: cursorio.TextOffset{Byte: 17, LineColumn: cursorio.TextLineColumn{0, 17}},
+	}); _a != _e {
+		t.Errorf("text: expected %v, got %v", _e, _a)
+	}
+
+	var rendered = &bytes.Buffer{}
+	for _, n := range nodes {
+		if err := html.Render(rendered, n); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _a, _e := rendered.String(), "<li class=\"a\">one</li><li>two</li>"; _a != _e {
+		t.Errorf("rendered: expected %v, got %v", _e, _a)
+	}
+}
+
+func TestParseFragmentEmpty(t *testing.T) {
+	context := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Div,
+		Data:     "div",
+	}
+
+	parser := NewParser(strings.NewReader(""))
+
+	nodes, documentOffsets, err := parser.ParseFragment(context)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := len(nodes), 0; _a != _e {
+		t.Fatalf("nodes: expected %v, got %v", _e, _a)
+	} else if documentOffsets == nil {
+		t.Fatal("expected metadata")
+	}
+
+	// the result is cached, even though upstream returned no nodes
+	_, cachedOffsets, err := parser.ParseFragment(context)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if cachedOffsets != documentOffsets {
+		t.Errorf("expected cached metadata")
+	}
+}
+
+func TestParseFragmentRenderMatch(t *testing.T) {
+	for _, tc := range []struct {
+		context string
+		input   string
+	}{
+		{"div", "hello <b>world</b> <!-- comment -->\n"},
+		{"textarea", "\n<b>raw</b> &amp; text"},
+		{"title", "a <i>title</i>"},
+		{"table", "text<tr><td>cell</td></tr>"},
+		{"body", "  <p>one<p>two  "},
+	} {
+		t.Run(tc.context, func(t *testing.T) {
+			context := &html.Node{
+				Type:     html.ElementNode,
+				DataAtom: atom.Lookup([]byte(tc.context)),
+				Data:     tc.context,
+			}
+
+			htmlNodes, err := html.ParseFragment(strings.NewReader(tc.input), context)
+			if err != nil {
+				t.Fatalf("html parse error: %v", err)
+			}
+
+			htmlRender := &bytes.Buffer{}
+			for _, n := range htmlNodes {
+				if err := html.Render(htmlRender, n); err != nil {
+					t.Fatalf("html render error: %v", err)
+				}
+			}
+
+			nodes, documentOffsets, err := ParseFragment(strings.NewReader(tc.input), context)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			inspectRender := &bytes.Buffer{}
+			for _, n := range nodes {
+				if err := html.Render(inspectRender, n); err != nil {
+					t.Fatalf("inspecthtml render error: %v", err)
+				}
+
+				visitNode(n, func(n *html.Node) {
+					if n.Type == html.ElementNode {
+						if np, ok := documentOffsets.GetNodeMetadata(n); ok && np.EndTagTokenOffsets == nil && !np.TagSelfClosing {
+							t.Errorf("%s: expected end tag offsets", dumpTraversal(n))
+						}
+					}
+				})
+			}
+
+			if htmlRender.String() != inspectRender.String() {
+				t.Fatalf("render mismatch:\n  html:        %q\n  inspecthtml: %q", htmlRender.String(), inspectRender.String())
+			}
+		})
+	}
+}
+
+func TestReaderDoctype(t *testing.T) {
+	input := `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" 'http://www.w3.org/TR/html4/strict.dtd'>` + "\n<html><body></body></html>"
+
+	document, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	doctype := document.FirstChild
+	if doctype == nil || doctype.Type != html.DoctypeNode {
+		t.Fatalf("expected doctype node")
+	}
+
+	np, ok := documentOffsets.GetNodeMetadata(doctype)
+	if !ok {
+		t.Fatal("expected metadata")
+	} else if _a, _e := np.TokenOffsets, (cursorio.TextOffsetRange{
+		From:  cursorio.TextOffset{Byte: 0, LineColumn: cursorio.TextLineColumn{0, 0}},
+		Until: cursorio.TextOffset{Byte: 90, LineColumn: cursorio.TextLineColumn{0, 90}},
+	}); _a != _e {
+		t.Errorf("token: expected %v, got %v", _e, _a)
+	} else if _a, _e := np.DoctypeNameOffsets, (cursorio.TextOffsetRange{
+		From:  cursorio.TextOffset{Byte: 10, LineColumn: cursorio.TextLineColumn{0, 10}},
+		Until: cursorio.TextOffset{Byte: 14, LineColumn: cursorio.TextLineColumn{0, 14}},
+	}); _a == nil || *_a != _e {
+		t.Errorf("name: expected %v, got %v", _e, _a)
+	} else if _a, _e := np.DoctypePublicIdentifierOffsets, (cursorio.TextOffsetRange{
+		From:  cursorio.TextOffset{Byte: 22, LineColumn: cursorio.TextLineColumn{0, 22}},
+		Until: cursorio.TextOffset{Byte: 49, LineColumn: cursorio.TextLineColumn{0, 49}},
+	}); _a == nil || *_a != _e {
+		t.Errorf("public: expected %v, got %v", _e, _a)
+	} else if _a, _e := np.DoctypeSystemIdentifierOffsets, (cursorio.TextOffsetRange{
+		From:  cursorio.TextOffset{Byte: 50, LineColumn: cursorio.TextLineColumn{0, 50}},
+		Until: cursorio.TextOffset{Byte: 89, LineColumn: cursorio.TextLineColumn{0, 89}},
+	}); _a == nil || *_a != _e {
+		t.Errorf("system: expected %v, got %v", _e, _a)
+	}
+
+	htmlRoot, _ := html.Parse(strings.NewReader(input))
+	htmlRender := &bytes.Buffer{}
+	if err := html.Render(htmlRender, htmlRoot); err != nil {
+		t.Fatalf("html render error: %v", err)
+	}
+
+	inspectRender := &bytes.Buffer{}
+	if err := html.Render(inspectRender, document); err != nil {
+		t.Fatalf("inspecthtml render error: %v", err)
+	}
+
+	if htmlRender.String() != inspectRender.String() {
+		t.Fatalf("render mismatch:\n  html:        %q\n  inspecthtml: %q", htmlRender.String(), inspectRender.String())
+	}
+}
+
+func TestReaderDoctypeIgnored(t *testing.T) {
+	input := "<!doctype html><html><body><!doctype other></body></html>"
+
+	document, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var doctypeCount int
+
+	visitNode(document, func(n *html.Node) {
+		if n.Type != html.DoctypeNode {
+			return
+		}
+
+		doctypeCount++
+
+		np, ok := documentOffsets.GetNodeMetadata(n)
+		if !ok {
+			t.Fatal("expected metadata")
+		} else if _a, _e := np.TokenOffsets.Until.Byte, int64(15); int64(_a) != _e {
+			t.Errorf("token until: expected %v, got %v", _e, _a)
+		} else if np.DoctypePublicIdentifierOffsets != nil || np.DoctypeSystemIdentifierOffsets != nil {
+			t.Errorf("identifiers: expected nil")
+		}
+	})
+
+	if doctypeCount != 1 {
+		t.Fatalf("expected exactly 1 doctype, got %d", doctypeCount)
+	}
+
+	var rendered = &bytes.Buffer{}
+	err = html.Render(rendered, document)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := rendered.String(), "<!DOCTYPE html><html><head></head><body></body></html>"; _a != _e {
+		t.Errorf("rendered: expected %v, got %v", _e, _a)
+	}
+}
+
+func TestDiagnosticsNone(t *testing.T) {
+	_, documentOffsets, err := Parse(strings.NewReader("<!doctype html><html><body><p>x</p></body></html>\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _a, _e := len(documentOffsets.GetDiagnostics()), 0; _a != _e {
+		t.Fatalf("diagnostics: expected %v, got %v", _e, documentOffsets.GetDiagnostics())
+	}
+}
+
+func TestDiagnosticsEndTagUnmatched(t *testing.T) {
+	input := "<div><b>x</span></b></b><h1>y</h2></div>"
+
+	document, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	diagnostics := documentOffsets.GetDiagnostics()
+	if _a, _e := len(diagnostics), 2; _a != _e {
+		t.Fatalf("diagnostics: expected %v, got %v", _e, diagnostics)
+	}
+
+	for i, e := range []string{"</span>", "</b>"} {
+		d := diagnostics[i]
+
+		if _a, _e := d.Code, DiagnosticCodeEndTagUnmatched; _a != _e {
+			t.Errorf("diagnostic %d: code: expected %v, got %v", i, _e, _a)
+		} else if _a, _e := d.Severity, DiagnosticSeverityWarning; _a != _e {
+			t.Errorf("diagnostic %d: severity: expected %v, got %v", i, _e, _a)
+		} else if _a, _e := input[d.Offsets.From.Byte:d.Offsets.Until.Byte], e; _a != _e {
+			t.Errorf("diagnostic %d: offsets: expected %v, got %v", i, _e, _a)
+		}
+	}
+
+	visitNode(document, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.Data != "b" {
+			return
+		}
+
+		np, ok := documentOffsets.GetNodeMetadata(n)
+		if !ok {
+			t.Fatal("expected metadata")
+		} else if _a, _e := np.EndTagTokenOffsets.OffsetRangeString(), "L1C17:L1C21;0x10:0x14"; _a != _e {
+			t.Errorf("end tag: expected %v, got %v", _e, _a)
+		}
+	})
+}
+
+func TestParseMetadataUnmatchedEndTags(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "stray",
+			input:    "</div>x<div></span></div>",
+			expected: []string{"div </div>", "span </span>"},
+		},
+		{
+			name:     "already closed",
+			input:    "<p>a</p></p>",
+			expected: []string{"p </p>"},
+		},
+		{
+			name:     "converted into element",
+			input:    "a</br>b</p>",
+			expected: []string{"br </br>", "p </p>"},
+		},
+		{
+			name:     "after html",
+			input:    "<html><body></body></html></body></html>",
+			expected: []string{"body </body>", "html </html>"},
+		},
+		{
+			name:     "implied element",
+			input:    "<table><tr><td>x</td></tr></tbody></table>",
+			expected: nil,
+		},
+		{
+			name:     "foster parented",
+			input:    "<table><div>z</div><tr><td>a</table>",
+			expected: nil,
+		},
+		{
+			name:     "foster parented after being closed",
+			input:    "<table><div>z<tr></div><td>a</table>",
+			expected: []string{"div </div>"},
+		},
+		{
+			name:     "void element",
+			input:    "<input></input>",
+			expected: []string{"input </input>"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			for _, endTag := range documentOffsets.GetUnmatchedEndTags() {
+				actual = append(actual, fmt.Sprintf("%s %s", endTag.TagName, tc.input[endTag.Offsets.From.Byte:endTag.Offsets.Until.Byte]))
+			}
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("end tags: expected\n%s\ngot\n%s", _e, _a)
+			}
+
+			var diagnostics int
+
+			for _, d := range documentOffsets.GetDiagnostics() {
+				if d.Code == DiagnosticCodeEndTagUnmatched {
+					diagnostics++
+				}
+			}
+
+			if _a, _e := diagnostics, len(tc.expected); _a != _e {
+				t.Errorf("diagnostics: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+func TestDiagnosticsTokenizer(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "duplicate attribute",
+			input:    `<p class=a class=b>`,
+			expected: []string{`duplicate-attribute "class"`},
+		},
+		{
+			name:     "missing whitespace between attributes",
+			input:    `<p a="x"b='y'>`,
+			expected: []string{`missing-whitespace-between-attributes "b"`},
+		},
+		{
+			name:     "unexpected null character",
+			input:    "<p\x00a=\"\x00\">",
+			expected: []string{`unexpected-null-character "\x00"`, `unexpected-null-character "\x00"`},
+		},
+		{
+			name:     "eof in tag",
+			input:    `a<div class=b`,
+			expected: []string{`eof-in-tag "<div class=b"`},
+		},
+		{
+			name:     "abrupt closing of empty comment",
+			input:    `<!-->`,
+			expected: []string{`abrupt-closing-of-empty-comment "<!-->"`},
+		},
+		{
+			name:     "incorrectly closed comment",
+			input:    `<!--a--!>`,
+			expected: []string{`incorrectly-closed-comment "--!>"`},
+		},
+		{
+			name:     "end tag with attributes",
+			input:    `<p></p a=b>`,
+			expected: []string{`end-tag-with-attributes "a=b"`},
+		},
+		{
+			name:     "non-void element with trailing solidus",
+			input:    `<div/><br/><svg><g/></svg>`,
+			expected: []string{`non-void-html-element-start-tag-with-trailing-solidus "/"`},
+		},
+		{
+			name:     "unquoted attribute value",
+			input:    `<p a=b"c d=>`,
+			expected: []string{`unexpected-character-in-unquoted-attribute-value "\""`, `missing-attribute-value "="`},
+		},
+		{
+			name:     "character references",
+			input:    `&amp &#0; <p title="&#xD800;">`,
+			expected: []string{`missing-semicolon-after-character-reference "&amp"`, `null-character-reference "&#0;"`, `surrogate-character-reference "&#xD800;"`},
+		},
+		{
+			name:     "text",
+			input:    `a<3 b<`,
+			expected: []string{`invalid-first-character-of-tag-name "3"`, `eof-before-tag-name "<"`},
+		},
+		{
+			name:     "raw text",
+			input:    `<script>a<3 &amp</script>`,
+			expected: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, documentOffsets, err := NewParser(strings.NewReader(tc.input), ParserConfig{}.SetTokenizerDiagnostics(true)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			for _, d := range documentOffsets.GetDiagnostics() {
+				if d.Code == DiagnosticCodeTextNullDropped {
+					continue
+				}
+
+				actual = append(actual, fmt.Sprintf("%s %q", d.Code, tc.input[d.Offsets.From.Byte:d.Offsets.Until.Byte]))
+			}
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("diagnostics: expected\n%s\ngot\n%s", _e, _a)
+			}
+
+			// disabled by default
+			_, documentOffsets, err = Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, d := range documentOffsets.GetDiagnostics() {
+				if d.Code != DiagnosticCodeTextNullDropped {
+					t.Errorf("diagnostics: unexpected %v", d)
+				}
+			}
+		})
+	}
+}
+
+func TestDiagnosticsTree(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "unclosed",
+			input: `<div><span>x</div><section>`,
+			expected: []string{
+				`element-implicitly-closed span "<span>"`,
+				`element-unclosed section "<section>"`,
+			},
+		},
+		{
+			name:     "p closed by block",
+			input:    `<p>a<p>b<div>c</div>`,
+			expected: []string{`element-implicitly-closed p "<p>"`},
+		},
+		{
+			name:     "foster parenting",
+			input:    `<table>a<tr><td>b</td></tr></table>`,
+			expected: []string{`node-foster-parented #text "a"`},
+		},
+		{
+			name:     "foster parenting with end tag",
+			input:    `<table><div>z</div><tr><td>a</table>`,
+			expected: []string{`node-foster-parented div "<div>"`},
+		},
+		{
+			name:  "adoption agency",
+			input: `<b>1<p>2</b>3</p>`,
+			expected: []string{
+				`element-reconstructed b "<b>"`,
+				`element-misnested p "<p>"`,
+			},
+		},
+		{
+			name:     "moved into head",
+			input:    `<head></head><meta>`,
+			expected: []string{`node-reparented meta "<meta>"`},
+		},
+		{
+			name:     "optional end tags",
+			input:    `<ul><li>a<li>b</ul><table><tr><td>c</table>`,
+			expected: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, documentOffsets, err := NewParser(strings.NewReader(tc.input), ParserConfig{}.SetTreeDiagnostics(true)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			for _, d := range documentOffsets.GetDiagnostics() {
+				if d.Node == nil {
+					t.Fatalf("diagnostic: expected node: %v", d)
+				}
+
+				actual = append(actual, fmt.Sprintf("%s %s %q", d.Code, describeTreeNode(d.Node), tc.input[d.Offsets.From.Byte:d.Offsets.Until.Byte]))
+			}
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("diagnostics: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsTreeMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "closed by parent",
+			input:    `<div><span>x</div>`,
+			expected: []string{`element was implicitly closed by the end of its parent: span`},
+		},
+		{
+			name:     "closed by sibling",
+			input:    `<p>a<div>b</div>`,
+			expected: []string{`element was implicitly closed by a following div: p`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, documentOffsets, err := NewParser(strings.NewReader(tc.input), ParserConfig{}.SetTreeDiagnostics(true)).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			for _, d := range documentOffsets.GetDiagnostics() {
+				actual = append(actual, d.Message)
+			}
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("messages: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsTextNull(t *testing.T) {
+	input := "<p>\x00</p>a\x00b"
+
+	document, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	diagnostics := documentOffsets.GetDiagnostics()
+	if _a, _e := len(diagnostics), 2; _a != _e {
+		t.Fatalf("diagnostics: expected %v, got %v", _e, diagnostics)
+	}
+
+	for i, e := range []string{"L1C4:L1C5;0x3:0x4", "L1C9:L1C12;0x8:0xb"} {
+		if _a, _e := diagnostics[i].Code, DiagnosticCodeTextNullDropped; _a != _e {
+			t.Errorf("diagnostic %d: code: expected %v, got %v", i, _e, _a)
+		} else if _a, _e := diagnostics[i].Offsets.OffsetRangeString(), e; _a != _e {
+			t.Errorf("diagnostic %d: offsets: expected %v, got %v", i, _e, _a)
+		}
+	}
+
+	// offsets after a dropped token must continue to match source
+	visitNode(document, func(n *html.Node) {
+		if n.Type != html.TextNode {
+			return
+		}
+
+		np, ok := documentOffsets.GetNodeMetadata(n)
+		if !ok {
+			t.Fatal("expected metadata")
+		} else if _a, _e := np.TokenOffsets.OffsetRangeString(), "L1C9:L1C12;0x8:0xb"; _a != _e {
+			t.Errorf("text: expected %v, got %v", _e, _a)
+		}
+	})
+}
+
+func benchmarkInput() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("<!doctype html>\n<html lang=\"en\">\n<head>\n  <meta charset=\"utf-8\">\n  <title>Benchmark</title>\n  <link rel=\"stylesheet\" href=\"/style.css\">\n</head>\n<body>\n")
+
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&buf, "  <div class=\"item item-%d\" data-index=%d>\n", i, i)
+		fmt.Fprintf(&buf, "    <h2 id='heading-%d'>Item <em>%d</em> &amp; more</h2>\n", i, i)
+		buf.WriteString("    <!-- description -->\n")
+		buf.WriteString("    <p>Lorem ipsum <a href=\"https://example.com/?a=1&amp;b=2\">dolor</a> sit amet.<br>\n    Consectetur <strong>adipiscing</strong> elit.\n")
+		buf.WriteString("    <ul><li>one<li>two<li>three</ul>\n")
+		buf.WriteString("  </div>\n")
+	}
+
+	buf.WriteString("<script>var x = 1 < 2;</script>\n</body>\n</html>\n")
+
+	return buf.Bytes()
+}
+
+// BenchmarkParse is compared across revisions to measure a change of the parser (e.g. with benchstat).
+func BenchmarkParse(b *testing.B) {
+	input := benchmarkInput()
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_, _, err := Parse(bytes.NewReader(input))
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkParseUpstream is the lower bound of BenchmarkParse, without any offsets.
+func BenchmarkParseUpstream(b *testing.B) {
+	input := benchmarkInput()
+
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		_, err := html.Parse(bytes.NewReader(input))
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestReaderTextSegments(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "single",
+			input:    "<p>a &amp; b</p>",
+			expected: []string{`"a & b" 0:5=L1C4:L1C13;0x3:0xc`},
+		},
+		{
+			name:     "null dropped",
+			input:    "<p>a\x00b&amp;c\x00</p>",
+			expected: []string{`"ab&c" 0:1=L1C4:L1C5;0x3:0x4 1:4=L1C6:L1C13;0x5:0xc`},
+		},
+		{
+			name:  "merged whitespace",
+			input: "<body>x</body>\n<!-- -->\n</html>",
+			expected: []string{
+				`"x" 0:1=L1C7:L1C8;0x6:0x7`,
+				`"\n\n" 0:1=L1C15:L2C1;0xe:0xf 1:2=L2C9:L3C1;0x17:0x18`,
+			},
+		},
+		{
+			name:     "pre newline",
+			input:    "<pre>\r\nabc</pre>",
+			expected: []string{`"abc" 0:3=L2C1:L2C4;0x7:0xa`},
+		},
+		{
+			name:     "pre newline reference",
+			input:    "<pre>&#10;abc</pre>",
+			expected: []string{`"abc" 0:3=L1C11:L1C14;0xa:0xd`},
+		},
+		{
+			name:     "pre whitespace",
+			input:    "<pre>\n\n</pre>",
+			expected: []string{`"\n" 0:1=L2C1:L3C1;0x6:0x7`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				if n.Type != html.TextNode {
+					return
+				}
+
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok {
+					actual = append(actual, fmt.Sprintf("%q", n.Data))
+
+					return
+				}
+
+				v := fmt.Sprintf("%q", n.Data)
+
+				for _, segment := range np.TextSegments {
+					v += fmt.Sprintf(" %d:%d=%s", segment.DataFrom, segment.DataUntil, segment.Offsets.OffsetRangeString())
+				}
+
+				actual = append(actual, v)
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("segments: expected\n%s\ngot\n%s", _e, _a)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderTextReplacements(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "named",
+			input:    "<p>a &amp; b</p>",
+			expected: []string{`"a & b" 2:3=L1C6:L1C11;0x5:0xa`},
+		},
+		{
+			name:     "numeric",
+			input:    "<p>&#60;&#x3e;</p>",
+			expected: []string{`"<>" 0:1=L1C4:L1C9;0x3:0x8 1:2=L1C9:L1C15;0x8:0xe`},
+		},
+		{
+			name:     "legacy prefix",
+			input:    "<p>&notit;</p>",
+			expected: []string{`"¬it;" 0:2=L1C4:L1C8;0x3:0x7`},
+		},
+		{
+			name:     "newline",
+			input:    "<p>a\r\nb</p>",
+			expected: []string{`"a\nb" 1:2=L1C5:L2C1;0x4:0x6`},
+		},
+		{
+			name:     "raw text",
+			input:    "<script>&amp;\x00</script>",
+			expected: []string{`"&amp;�" 5:8=L1C14:L1C15;0xd:0xe`},
+		},
+		{
+			name:  "attribute",
+			input: `<a href="?a=1&amp;b=2&amp=3" title=x&lt;>y</a>`,
+			expected: []string{
+				`href="?a=1&b=2&amp=3" 4:5=L1C14:L1C19;0xd:0x12`,
+				`title="x<" 1:2=L1C37:L1C41;0x24:0x28`,
+				`"y"`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok {
+					return
+				}
+
+				switch n.Type {
+				case html.TextNode:
+					v := fmt.Sprintf("%q", n.Data)
+
+					for _, segment := range np.TextSegments {
+						for _, r := range segment.Replacements {
+							v += fmt.Sprintf(" %d:%d=%s", r.DataFrom, r.DataUntil, r.Offsets.OffsetRangeString())
+						}
+					}
+
+					actual = append(actual, v)
+				case html.ElementNode:
+					for attrIdx, attr := range np.TagAttr {
+						if attr.ValueSegment == nil {
+							continue
+						}
+
+						v := fmt.Sprintf("%s=%q", n.Attr[attrIdx].Key, n.Attr[attrIdx].Val)
+
+						for _, r := range attr.ValueSegment.Replacements {
+							v += fmt.Sprintf(" %d:%d=%s", r.DataFrom, r.DataUntil, r.Offsets.OffsetRangeString())
+						}
+
+						actual = append(actual, v)
+					}
+				}
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("replacements: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderTextDataOffset(t *testing.T) {
+	input := "<p title=\"a &amp; b\">x\r\ny &lt;\x00z</p>"
+
+	document, documentOffsets, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := document.FirstChild.LastChild.FirstChild
+
+	pMetadata, ok := documentOffsets.GetNodeMetadata(p)
+	if !ok {
+		t.Fatalf("expected metadata")
+	}
+
+	text := p.FirstChild
+
+	textMetadata, ok := documentOffsets.GetNodeMetadata(text)
+	if !ok {
+		t.Fatalf("expected metadata")
+	}
+
+	for _, tc := range []struct {
+		data     string
+		offsetFn func(i int) (cursorio.TextOffset, bool)
+		indexFn  func(o cursorio.TextOffset) (int, bool)
+		expected []string
+	}{
+		{
+			data: p.Attr[0].Val,
+			offsetFn: func(i int) (cursorio.TextOffset, bool) {
+				return pMetadata.TagAttr[0].GetValueDataOffset(p.Attr[0].Val, i)
+			},
+			indexFn:  pMetadata.TagAttr[0].GetValueDataIndex,
+			expected: []string{"L1C11;0xa", "L1C12;0xb", "L1C13;0xc", "L1C18;0x11", "L1C19;0x12", "L1C20;0x13"},
+		},
+		{
+			data: text.Data,
+			offsetFn: func(i int) (cursorio.TextOffset, bool) {
+				return textMetadata.GetTextDataOffset(text.Data, i)
+			},
+			indexFn:  textMetadata.GetTextDataIndex,
+			expected: []string{"L1C22;0x15", "L1C23;0x16", "L2C1;0x18", "L2C2;0x19", "L2C3;0x1a", "L2C8;0x1f", "L2C9;0x20"},
+		},
+	} {
+		var actual []string
+
+		for i := 0; i <= len(tc.data); i++ {
+			offset, ok := tc.offsetFn(i)
+			if !ok {
+				t.Fatalf("%q: expected offset of %d", tc.data, i)
+			}
+
+			actual = append(actual, offset.OffsetString())
+
+			if index, ok := tc.indexFn(offset); !ok || index != i {
+				t.Errorf("%q: expected index %d of %s, got %d", tc.data, i, offset.OffsetString(), index)
+			}
+		}
+
+		if _a, _e := strings.Join(actual, " "), strings.Join(tc.expected, " "); _a != _e {
+			t.Errorf("%q: expected %s, got %s", tc.data, _e, _a)
+		}
+	}
+}
+
+func TestParseMetadataImpliedEndTags(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "nested at eof",
+			input:    "<div><p><b>x",
+			expected: []string{"div L1C13;0xc eof", "p L1C13;0xc eof", "b L1C13;0xc eof"},
+		},
+		{
+			name:     "empty within end tag",
+			input:    "<div><p></div>",
+			expected: []string{"div L1C9;0x8", "p L1C9;0x8 parent"},
+		},
+		{
+			name:     "siblings",
+			input:    "<ul><li>a<li><p></ul>",
+			expected: []string{"ul L1C17;0x10", "li L1C10;0x9 sibling", "li L1C17;0x10 parent", "p L1C17;0x10 parent"},
+		},
+		{
+			name:     "empty at eof",
+			input:    "<p>",
+			expected: []string{"p L1C4;0x3 eof"},
+		},
+		{
+			name:     "within parent closed by sibling",
+			input:    "<ul><li><span>a<li>b",
+			expected: []string{"ul L1C21;0x14 eof", "li L1C16;0xf sibling", "span L1C16;0xf parent", "li L1C21;0x14 eof"},
+		},
+		{
+			name:     "within explicit body at eof",
+			input:    "<body><div><p>x",
+			expected: []string{"body L1C16;0xf eof", "div L1C16;0xf eof", "p L1C16;0xf eof"},
+		},
+		{
+			name:     "void and self-closing",
+			input:    "<br><br/><input></input><svg><path/></svg>",
+			expected: []string{"br nil", "br nil", "input nil", "svg L1C37;0x24", "path nil"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok || n.Type != html.ElementNode {
+					return
+				} else if np.EndTagTokenOffsets == nil {
+					actual = append(actual, fmt.Sprintf("%s nil", n.Data))
+
+					return
+				}
+
+				line := fmt.Sprintf("%s %s", n.Data, np.EndTagTokenOffsets.From.OffsetString())
+				if np.ImpliedEndTagReason != 0 {
+					line += " " + np.ImpliedEndTagReason.String()
+				}
+
+				actual = append(actual, line)
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("end tags: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestParseMetadataNodeProvenance(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "implied end tags",
+			input: "<ul><li>a<li>b</ul><div><p>c",
+			expected: []string{
+				`html implied`,
+				`head implied`,
+				`body implied`,
+				`ul explicit`,
+				`li explicit sibling`,
+				`"a" explicit`,
+				`li explicit parent`,
+				`"b" explicit`,
+				`div explicit eof`,
+				`p explicit eof`,
+				`"c" explicit`,
+			},
+		},
+		{
+			name:  "foster parenting",
+			input: "<table>a<tr><td>b</td></tr></table>",
+			expected: []string{
+				`html implied`,
+				`head implied`,
+				`body implied`,
+				`"a" reparented`,
+				`table explicit`,
+				`tbody implied`,
+				`tr explicit`,
+				`td explicit`,
+				`"b" explicit`,
+			},
+		},
+		{
+			name:  "adoption agency",
+			input: "<b>a<p>b</b>c</p>",
+			expected: []string{
+				`html implied`,
+				`head implied`,
+				`body implied`,
+				`b explicit`,
+				`"a" explicit`,
+				`p reparented`,
+				`b cloned`,
+				`"b" explicit`,
+				`"c" explicit`,
+			},
+		},
+		{
+			name:  "moved into head",
+			input: "<head></head><meta>",
+			expected: []string{
+				`html implied`,
+				`head explicit`,
+				`meta reparented`,
+				`body implied`,
+			},
+		},
+		{
+			name:  "merged",
+			input: "<body>a<body id=b>",
+			expected: []string{
+				`html implied`,
+				`head implied`,
+				`body merged eof`,
+				`"a" explicit`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				var line string
+
+				switch n.Type {
+				case html.ElementNode:
+					line = fmt.Sprintf("%s %s", n.Data, documentOffsets.GetNodeProvenance(n))
+				case html.TextNode:
+					line = fmt.Sprintf("%q %s", n.Data, documentOffsets.GetNodeProvenance(n))
+				default:
+					return
+				}
+
+				if np, ok := documentOffsets.GetNodeMetadata(n); ok && np.ImpliedEndTagReason != 0 && documentOffsets.GetNodeProvenance(n) != NodeProvenanceCloned {
+					line += " " + np.ImpliedEndTagReason.String()
+				}
+
+				actual = append(actual, line)
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("provenance: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestParseMetadataConcurrentReaders(t *testing.T) {
+	document, documentOffsets, err := Parse(strings.NewReader("<div><p><b>x<p>y</div><ul><li>z"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			visitNode(document, func(n *html.Node) {
+				if np, ok := documentOffsets.GetNodeMetadata(n); ok {
+					_ = np.GetOuterOffsets()
+				}
+			})
+
+			documentOffsets.GetOffsetIndex()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestReaderNoscriptScripting(t *testing.T) {
+	input := `<html><head><noscript><link href="a.css"></noscript></head><body><noscript><p class="x">a &amp; b</p><!-- c --></noscript></body></html>`
+
+	for _, tc := range []struct {
+		name      string
+		scripting bool
+		expected  []string
+	}{
+		{
+			name:      "enabled",
+			scripting: true,
+			expected: []string{
+				`noscript L1C13:L1C23;0xc:0x16`,
+				`"<link href=\"a.css\">" L1C23:L1C42;0x16:0x29`,
+				`noscript L1C66:L1C76;0x41:0x4b`,
+				`"<p class=\"x\">a &amp; b</p><!-- c -->" L1C76:L1C112;0x4b:0x6f`,
+			},
+		},
+		{
+			name:      "disabled",
+			scripting: false,
+			expected: []string{
+				`noscript L1C13:L1C23;0xc:0x16`,
+				`link L1C23:L1C42;0x16:0x29 href=L1C34:L1C41;0x21:0x28`,
+				`noscript L1C66:L1C76;0x41:0x4b`,
+				`p L1C76:L1C89;0x4b:0x58 class=L1C85:L1C88;0x54:0x57`,
+				`"a & b" L1C89:L1C98;0x58:0x61`,
+				`" c " L1C102:L1C112;0x65:0x6f`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []html.ParseOption{html.ParseOptionEnableScripting(tc.scripting)}
+
+			document, documentOffsets, err := ParseWithOptions(strings.NewReader(input), opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				var withinNoscript bool
+
+				for a := n; a != nil; a = a.Parent {
+					withinNoscript = withinNoscript || a.DataAtom == atom.Noscript
+				}
+
+				if !withinNoscript {
+					return
+				}
+
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok {
+					actual = append(actual, fmt.Sprintf("%q", n.Data))
+
+					return
+				}
+
+				switch n.Type {
+				case html.ElementNode:
+					v := fmt.Sprintf("%s %s", n.Data, np.TokenOffsets.OffsetRangeString())
+
+					for attrIdx, attr := range np.TagAttr {
+						v += fmt.Sprintf(" %s=%s", n.Attr[attrIdx].Key, attr.ValueOffsets.OffsetRangeString())
+					}
+
+					actual = append(actual, v)
+				default:
+					actual = append(actual, fmt.Sprintf("%q %s", n.Data, np.TokenOffsets.OffsetRangeString()))
+				}
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("nodes: expected\n%s\ngot\n%s", _e, _a)
+			}
+
+			expectedDocument, err := html.ParseWithOptions(strings.NewReader(input), opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderForeignContent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "svg style",
+			input: `<svg><style>a<!-- c --><g/></style></svg>`,
+			expected: []string{
+				`svg L1C1:L1C6;0x0:0x5`,
+				`style L1C6:L1C13;0x5:0xc`,
+				`"a" L1C13:L1C14;0xc:0xd`,
+				`" c " L1C14:L1C24;0xd:0x17`,
+				`g L1C24:L1C28;0x17:0x1b`,
+			},
+		},
+		{
+			name:  "svg title",
+			input: `<svg><title>a &amp; <tspan>b</tspan></title></svg>`,
+			expected: []string{
+				`svg L1C1:L1C6;0x0:0x5`,
+				`title L1C6:L1C13;0x5:0xc`,
+				`"a & " L1C13:L1C21;0xc:0x14`,
+				`tspan L1C21:L1C28;0x14:0x1b`,
+				`"b" L1C28:L1C29;0x1b:0x1c`,
+			},
+		},
+		{
+			name:  "integration point",
+			input: `<svg><foreignObject><style><b></style></foreignObject></svg>`,
+			expected: []string{
+				`svg L1C1:L1C6;0x0:0x5`,
+				`foreignObject L1C6:L1C21;0x5:0x14`,
+				`style L1C21:L1C28;0x14:0x1b`,
+				`"<b>" L1C28:L1C31;0x1b:0x1e`,
+			},
+		},
+		{
+			name:  "math text integration point",
+			input: `<math><mi><title><i></title></mi></math>`,
+			expected: []string{
+				`math L1C1:L1C7;0x0:0x6`,
+				`mi L1C7:L1C11;0x6:0xa`,
+				`title L1C11:L1C18;0xa:0x11`,
+				`"<i>" L1C18:L1C21;0x11:0x14`,
+			},
+		},
+		{
+			name:  "breakout",
+			input: `<svg><p><style><i></style></svg>`,
+			expected: []string{
+				`svg L1C1:L1C6;0x0:0x5`,
+				`p L1C6:L1C9;0x5:0x8`,
+				`style L1C9:L1C16;0x8:0xf`,
+				`"<i>" L1C16:L1C19;0xf:0x12`,
+			},
+		},
+		{
+			name:  "closed",
+			input: `<svg><g></g></svg><style><i></style>`,
+			expected: []string{
+				`svg L1C1:L1C6;0x0:0x5`,
+				`g L1C6:L1C9;0x5:0x8`,
+				`style L1C19:L1C26;0x12:0x19`,
+				`"<i>" L1C26:L1C29;0x19:0x1c`,
+			},
+		},
+		{
+			name:  "cdata",
+			input: "<svg><![CDATA[a<b>&amp;]]>c\x00</svg>",
+			expected: []string{
+				`svg L1C1:L1C6;0x0:0x5`,
+				`"a<b>&" L1C6:L1C27;0x5:0x1a`,
+				`"c�" L1C27:L1C29;0x1a:0x1c`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok {
+					return
+				}
+
+				switch n.Type {
+				case html.ElementNode:
+					actual = append(actual, fmt.Sprintf("%s %s", n.Data, np.TokenOffsets.OffsetRangeString()))
+				default:
+					actual = append(actual, fmt.Sprintf("%q %s", n.Data, np.TokenOffsets.OffsetRangeString()))
+				}
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("nodes: expected\n%s\ngot\n%s", _e, _a)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderForeignContentSelect(t *testing.T) {
+	// elements are mostly ignored within a select upstream, so they must not affect the tokenizer state
+	for _, input := range []string{
+		`<select><svg><script>document.write("<b>x</b>")</script></select>`,
+		"<select><math>a\x00b</math></select>",
+		`<table><select><svg><script><b>x</b></script></svg></select></table>`,
+		`<select><option><svg><title><b>x</b></title></svg>`,
+		`<select><style><b>x</b></style></select>`,
+		`<select><textarea><b>x</b></textarea>`,
+		`<select><input><svg><script><b>x</b></script></svg>`,
+		`<select><select><svg><script><b>x</b></script></svg>`,
+		`<select></select><svg><script><b>x</b></script></svg>`,
+		`<table><select><td><svg><script><b>x</b></script></svg>`,
+		`<select><template><svg><script><b>x</b></script></svg></template></select>`,
+		`<p><select></p><title><b>x</b></title></select>`,
+		`<div><select></div><style><b>x</b></style></select>`,
+		`<select><table></select><svg><script><b>x</b></script></svg>`,
+		`<select><div></select><svg><script><b>x</b></script></svg>`,
+		`<select><script>a</script></select><svg><script><b>x</b></script></svg>`,
+		`<template><select></template><svg><script><b>x</b></script></svg>`,
+		`<select><template><select></template><svg><script><b>x</b></script></svg>`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			document, _, err := Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderForeignContentFrameset(t *testing.T) {
+	// start tags are ignored within or after a frameset upstream, so they must not affect the tokenizer state
+	for _, input := range []string{
+		`<frameset><svg><title><dt>`,
+		`<frameset><svg><style><noembed>`,
+		`<frameset><math><mi><style><b>x</b></style>`,
+		`<frameset></frameset><svg><title><b>x</b></title></svg>`,
+		`<div><frameset><svg><title><b>x</b></title></svg>`,
+		`<input type="hidden"><frameset><svg><title><b>x</b></title></svg>`,
+		`<p>x</p><frameset><svg><title><b>x</b></title></svg>`,
+		`<input><frameset><svg><title><b>x</b></title></svg>`,
+		`<a><frame><math></a><style><b>x</b></style>`,
+		`<a><body><math></a><style><b>x</b></style>`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			document, _, err := Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderRawTextAfterImpliedEndTags(t *testing.T) {
+	// elements closed implicitly upstream must not be considered open when deciding whether content is raw text
+	for _, input := range []string{
+		`<p>a<div><select><style><b>x</b></style></select></div>`,
+		`<ul><li>a<li><select><style><b>x</b></style>`,
+		`<dl><dd>a<dt><select></dt><title><b>x</b></title>`,
+		`<button><button><select><style><b>x</b></style>`,
+		`<h1><h2><select></h1><title><b>x</b></title>`,
+		`<h1>a<h2></h1><svg><script><b>x</b></script></svg>`,
+		`<table><tr><td>x</table><select><td><svg><script><b>x</b></script></svg>`,
+		`<table><table></table><select><td><svg><script><b>x</b></script></svg>`,
+		`<table><td><table></table><select><td><svg><script><b>x</b></script></svg>`,
+		`<table><caption><table></table><select><td><svg><script><b>x</b></script></svg>`,
+		`<div><tr><select></div><style><b>x</b></style>`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			document, _, err := Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestReaderMergedStartTags(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:  "html and body",
+			input: `<html lang=en><body class=a>x<body id=b class=c><html dir=rtl lang=fr>`,
+			expected: []string{
+				`html L1C1:L1C15;0x0:0xe`,
+				`  lang L1C7:L1C11;0x6:0xa`,
+				`  dir L1C55:L1C58;0x36:0x39 merged=L1C49:L1C71;0x30:0x46`,
+				`  merged L1C49:L1C71;0x30:0x46`,
+				`body L1C15:L1C29;0xe:0x1c`,
+				`  class L1C21:L1C26;0x14:0x19`,
+				`  id L1C36:L1C38;0x23:0x25 merged=L1C30:L1C49;0x1d:0x30`,
+				`  merged L1C30:L1C49;0x1d:0x30`,
+			},
+		},
+		{
+			name:  "implied",
+			input: `x<body id=a><body id=b title=c>`,
+			expected: []string{
+				`body L1C2:L1C13;0x1:0xc`,
+				`  id L1C8:L1C10;0x7:0x9`,
+				`  title L1C24:L1C29;0x17:0x1c merged=L1C13:L1C32;0xc:0x1f`,
+				`  merged L1C13:L1C32;0xc:0x1f`,
+			},
+		},
+		{
+			name:  "ignored",
+			input: `<template><body id=a></template>`,
+			expected: []string{
+				`body`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			document, documentOffsets, err := Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				if n.Type != html.ElementNode || (n.DataAtom != atom.Html && n.DataAtom != atom.Body) {
+					return
+				}
+
+				np, ok := documentOffsets.GetNodeMetadata(n)
+				if !ok {
+					if n.DataAtom == atom.Body {
+						actual = append(actual, n.Data)
+					}
+
+					return
+				} else if len(np.TagAttr) != len(n.Attr) {
+					t.Fatalf("attr: expected %d, got %d", len(n.Attr), len(np.TagAttr))
+				}
+
+				actual = append(actual, fmt.Sprintf("%s %s", n.Data, np.TokenOffsets.OffsetRangeString()))
+
+				for attrIdx, attr := range n.Attr {
+					attrMetadata := np.TagAttr[attrIdx]
+					line := fmt.Sprintf("  %s %s", attr.Key, attrMetadata.KeyOffsets.OffsetRangeString())
+
+					if attrMetadata.MergedStartTag != nil {
+						line += " merged=" + attrMetadata.MergedStartTag.TokenOffsets.OffsetRangeString()
+					}
+
+					actual = append(actual, line)
+				}
+
+				for _, merged := range np.MergedStartTags {
+					actual = append(actual, fmt.Sprintf("  merged %s", merged.TokenOffsets.OffsetRangeString()))
+				}
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("nodes: expected\n%s\ngot\n%s", _e, _a)
+			}
+
+			expectedDocument, err := html.Parse(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var rendered, expectedRendered = &bytes.Buffer{}, &bytes.Buffer{}
+			html.Render(rendered, document)
+			html.Render(expectedRendered, expectedDocument)
+
+			if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+				t.Errorf("rendered: expected %v, got %v", _e, _a)
+			}
+		})
+	}
+}
+
+func TestParseMetadataRawSource(t *testing.T) {
+	input := "<HTML>\n<Body><P Class = 'a&amp;b' hidden>x<br>y</P><DIV data-x=1>z</body><html LANG=\"en\">"
+
+	// streamed one byte at a time, with a non-zero initial offset
+	document, documentMetadata, err := NewParser(
+		iotest.OneByteReader(strings.NewReader(input)),
+		ParserConfig{}.SetRetainSource(true).SetInitialOffset(cursorio.TextOffset{Byte: 100}),
+	).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _a, _e := string(documentMetadata.GetSource()), input; _a != _e {
+		t.Fatalf("source: expected %q, got %q", _e, _a)
+	}
+
+	var actual []string
+
+	raw := func(v []byte, ok bool) string {
+		if !ok {
+			return "-"
+		}
+
+		return fmt.Sprintf("%q", v)
+	}
+
+	visitNode(document, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		actual = append(actual, fmt.Sprintf(
+			"%s name=%s start=%s end=%s inner=%s outer=%s",
+			n.Data,
+			raw(documentMetadata.GetNodeRawTagName(n)),
+			raw(documentMetadata.GetNodeRawStartTag(n)),
+			raw(documentMetadata.GetNodeRawEndTag(n)),
+			raw(documentMetadata.GetNodeRawInnerHTML(n)),
+			raw(documentMetadata.GetNodeRawOuterHTML(n)),
+		))
+
+		for attrIdx, attr := range n.Attr {
+			actual = append(actual, fmt.Sprintf(
+				"  %s key=%s value=%s quoted=%s",
+				attr.Key,
+				raw(documentMetadata.GetNodeRawAttrKey(n, attrIdx)),
+				raw(documentMetadata.GetNodeRawAttrValue(n, attrIdx)),
+				raw(documentMetadata.GetNodeRawAttrQuotedValue(n, attrIdx)),
+			))
+		}
+	})
+
+	if _a, _e := strings.Join(actual, "\n"), strings.Join([]string{
+		`html name="HTML" start="<HTML>" end=- inner="\n<Body><P Class = 'a&amp;b' hidden>x<br>y</P><DIV data-x=1>z</body>" outer="<HTML>\n<Body><P Class = 'a&amp;b' hidden>x<br>y</P><DIV data-x=1>z</body>"`,
+		`  lang key="LANG" value="en" quoted="\"en\""`,
+		`head name=- start=- end=- inner=- outer=-`,
+		`body name="Body" start="<Body>" end="</body>" inner="<P Class = 'a&amp;b' hidden>x<br>y</P><DIV data-x=1>z" outer="<Body><P Class = 'a&amp;b' hidden>x<br>y</P><DIV data-x=1>z</body>"`,
+		`p name="P" start="<P Class = 'a&amp;b' hidden>" end="</P>" inner="x<br>y" outer="<P Class = 'a&amp;b' hidden>x<br>y</P>"`,
+		`  class key="Class" value="a&amp;b" quoted="'a&amp;b'"`,
+		`  hidden key="hidden" value=- quoted=-`,
+		`br name="br" start="<br>" end=- inner=- outer="<br>"`,
+		`div name="DIV" start="<DIV data-x=1>" end=- inner="z" outer="<DIV data-x=1>z"`,
+		`  data-x key="data-x" value="1" quoted="1"`,
+	}, "\n"); _a != _e {
+		t.Errorf("nodes: expected\n%s\ngot\n%s", _e, _a)
+	}
+
+	_, documentMetadata, err = Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := documentMetadata.GetRawSource(cursorio.TextOffsetRange{}); ok {
+		t.Errorf("expected no source unless retained")
+	}
+}
+
+func TestParseMetadataColumnUnits(t *testing.T) {
+	input := "<p title=\"\u00e9\">e\u0301\U0001F44D\U0001F3FDx</p>\r\n<b>\U0001F1FA\U0001F1F8</b>"
+
+	document, documentMetadata, err := NewParser(strings.NewReader(input), ParserConfig{}.SetRetainSource(true)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		unit     ColumnUnit
+		expected []string
+	}{
+		{
+			unit: ColumnUnitByte,
+			expected: []string{
+				`p L1C1:L1C15;0x0:0xe value=L1C11:L1C13;0xa:0xc`,
+				`#text L1C15:L1C27;0xe:0x1a`,
+				`#text L1C31:L2C1;0x1e:0x20`,
+				`b L2C1:L2C4;0x20:0x23`,
+				`#text L2C4:L2C12;0x23:0x2b`,
+			},
+		},
+		{
+			unit: ColumnUnitRune,
+			expected: []string{
+				`p L1C1:L1C14;0x0:0xe value=L1C11:L1C12;0xa:0xc`,
+				`#text L1C14:L1C19;0xe:0x1a`,
+				`#text L1C23:L2C1;0x1e:0x20`,
+				`b L2C1:L2C4;0x20:0x23`,
+				`#text L2C4:L2C6;0x23:0x2b`,
+			},
+		},
+		{
+			unit: ColumnUnitUTF16,
+			expected: []string{
+				`p L1C1:L1C14;0x0:0xe value=L1C11:L1C12;0xa:0xc`,
+				`#text L1C14:L1C21;0xe:0x1a`,
+				`#text L1C25:L2C1;0x1e:0x20`,
+				`b L2C1:L2C4;0x20:0x23`,
+				`#text L2C4:L2C8;0x23:0x2b`,
+			},
+		},
+		{
+			unit: ColumnUnitGrapheme,
+			expected: []string{
+				`p L1C1:L1C14;0x0:0xe value=L1C11:L1C12;0xa:0xc`,
+				`#text L1C14:L1C17;0xe:0x1a`,
+				`#text L1C21:L2C1;0x1e:0x20`,
+				`b L2C1:L2C4;0x20:0x23`,
+				`#text L2C4:L2C5;0x23:0x2b`,
+			},
+		},
+	} {
+		t.Run(tc.unit.String(), func(t *testing.T) {
+			var actual []string
+
+			visitNode(document, func(n *html.Node) {
+				v, ok := documentMetadata.GetNodeMetadata(n)
+				if !ok {
+					return
+				}
+
+				cv, ok := documentMetadata.ConvertNodeMetadata(v, tc.unit)
+				if !ok {
+					t.Fatalf("%s: expected conversion", describeTreeNode(n))
+				}
+
+				s := fmt.Sprintf("%s %s", describeTreeNode(n), cv.TokenOffsets.OffsetRangeString())
+
+				for _, attr := range cv.TagAttr {
+					s += fmt.Sprintf(" value=%s", attr.ValueSegment.Offsets.OffsetRangeString())
+				}
+
+				actual = append(actual, s)
+
+				for _, offset := range []cursorio.TextOffset{cv.TokenOffsets.From, cv.TokenOffsets.Until} {
+					if _a, ok := documentMetadata.ConvertLineColumn(offset.LineColumn, tc.unit); !ok {
+						t.Errorf("%s: expected line/column %v", describeTreeNode(n), offset.LineColumn)
+					} else if _a != offset {
+						t.Errorf("%s: line/column: expected %v, got %v", describeTreeNode(n), offset, _a)
+					}
+				}
+			})
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("offsets: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+
+	// within a surrogate pair
+	if _a, ok := documentMetadata.ConvertLineColumn(cursorio.TextLineColumn{0, 16}, ColumnUnitUTF16); !ok {
+		t.Errorf("expected line/column")
+	} else if _a, _e := _a.OffsetString(), "L1C16;0x11"; _a != _e {
+		t.Errorf("line/column: expected %v, got %v", _e, _a)
+	}
+
+	// beyond the end of a line
+	if _, ok := documentMetadata.ConvertLineColumn(cursorio.TextLineColumn{1, 12}, ColumnUnitUTF16); ok {
+		t.Errorf("expected no line/column")
+	}
+
+	_, documentMetadata, err = Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, ok := documentMetadata.ConvertOffset(cursorio.TextOffset{}, ColumnUnitUTF16); ok {
+		t.Errorf("expected no conversion unless retained")
+	}
+}
+
+func TestParserConfigOverride(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		opts  []ParserOption
+		check func(t *testing.T, documentMetadata *ParseMetadata)
+	}{
+		{
+			name:  "unset options are not changed",
+			input: "<p a a>x</p>",
+			opts: []ParserOption{
+				ParserConfig{}.SetTokenizerDiagnostics(true),
+				ParserConfig{},
+			},
+			check: func(t *testing.T, documentMetadata *ParseMetadata) {
+				if _a := len(documentMetadata.GetDiagnostics()); _a == 0 {
+					t.Errorf("diagnostics: expected some, got %v", _a)
+				}
+			},
+		},
+		{
+			name:  "tokenizer diagnostics",
+			input: "<p a a>x</p>",
+			opts: []ParserOption{
+				ParserConfig{}.SetTokenizerDiagnostics(true),
+				ParserConfig{}.SetTokenizerDiagnostics(false),
+			},
+			check: func(t *testing.T, documentMetadata *ParseMetadata) {
+				if _a := documentMetadata.GetDiagnostics(); len(_a) != 0 {
+					t.Errorf("diagnostics: expected none, got %v", _a)
+				}
+			},
+		},
+		{
+			name:  "tree diagnostics",
+			input: "<div><span>x</div>",
+			opts: []ParserOption{
+				ParserConfig{}.SetTreeDiagnostics(true),
+				ParserConfig{}.SetTreeDiagnostics(false),
+			},
+			check: func(t *testing.T, documentMetadata *ParseMetadata) {
+				if _a := documentMetadata.GetDiagnostics(); len(_a) != 0 {
+					t.Errorf("diagnostics: expected none, got %v", _a)
+				}
+			},
+		},
+		{
+			name:  "syntax tree",
+			input: "<p>x</p>",
+			opts: []ParserOption{
+				ParserConfig{}.SetSyntaxTree(true),
+				ParserConfig{}.SetSyntaxTree(false),
+			},
+			check: func(t *testing.T, documentMetadata *ParseMetadata) {
+				if _a := documentMetadata.GetSyntaxTree(); _a != nil {
+					t.Errorf("syntax tree: expected nil, got %v", _a)
+				}
+			},
+		},
+		{
+			name:  "retain source",
+			input: "<p>x</p>",
+			opts: []ParserOption{
+				ParserConfig{}.SetRetainSource(true),
+				ParserConfig{}.SetRetainSource(false),
+			},
+			check: func(t *testing.T, documentMetadata *ParseMetadata) {
+				if _a := documentMetadata.GetSource(); _a != nil {
+					t.Errorf("source: expected nil, got %q", _a)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, documentMetadata, err := NewParser(strings.NewReader(tc.input), tc.opts...).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			tc.check(t, documentMetadata)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package inspecthtml
+
+import (
+	"fmt"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// https://html.spec.whatwg.org/multipage/parsing.html#stop-parsing
+func isOptionalEndTagAtom(a atom.Atom) bool {
+	switch a {
+	case atom.Dd, atom.Dt, atom.Li, atom.Optgroup, atom.Option, atom.P, atom.Rb, atom.Rp, atom.Rt, atom.Rtc, atom.Tbody,
+		atom.Td, atom.Tfoot, atom.Th, atom.Thead, atom.Tr, atom.Body, atom.Html:
+		return true
+	}
+
+	return false
+}
+
+// rebuildTreeDiagnostics reports where the DOM Processor recovered from malformed markup. It relies on the provenance
+// and implied end tags, so the metadata must already be finalized.
+func (p *Parser) rebuildTreeDiagnostics(root *html.Node) {
+	var visitNode func(n *html.Node)
+	visitNode = func(n *html.Node) {
+		if v, ok := p.offsets.GetNodeMetadata(n); ok {
+			p.reportTreeDiagnostics(n, v)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visitNode(c)
+		}
+	}
+
+	visitNode(root)
+}
+
+func (p *Parser) reportTreeDiagnostics(n *html.Node, v *NodeMetadata) {
+	report := func(code DiagnosticCode, severity DiagnosticSeverity, message string) {
+		p.r.report(Diagnostic{
+			Code:     code,
+			Severity: severity,
+			Message:  message,
+			Offsets:  v.TokenOffsets,
+			Node:     n,
+		})
+	}
+
+	switch p.offsets.GetNodeProvenance(n) {
+	case NodeProvenanceCloned:
+		report(DiagnosticCodeElementReconstructed, DiagnosticSeverityInfo, fmt.Sprintf("formatting element was reopened: %s", n.Data))
+
+		// its end tag is the same as the original
+		return
+	case NodeProvenanceReparented:
+		if table := p.findTreeSibling(n, v, atom.Table, 1); table != nil {
+			report(DiagnosticCodeNodeFosterParented, DiagnosticSeverityWarning, fmt.Sprintf("node was moved before the table it was within: %s", describeTreeNode(n)))
+		} else if misnested := p.findTreeSibling(n, v, 0, -1); misnested != nil {
+			report(DiagnosticCodeElementMisnested, DiagnosticSeverityWarning, fmt.Sprintf("node was moved out of the misnested %s element: %s", misnested.Data, describeTreeNode(n)))
+		} else {
+			report(DiagnosticCodeNodeReparented, DiagnosticSeverityWarning, fmt.Sprintf("node was moved from its position in source: %s", describeTreeNode(n)))
+		}
+	}
+
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	switch v.ImpliedEndTagReason {
+	case ImpliedEndTagReasonEOF:
+		if !isOptionalEndTagAtom(n.DataAtom) {
+			report(DiagnosticCodeElementUnclosed, DiagnosticSeverityWarning, fmt.Sprintf("element was not closed before the end of input: %s", n.Data))
+		}
+	case ImpliedEndTagReasonParent:
+		switch n.DataAtom {
+		case atom.Caption, atom.Colgroup, atom.Head:
+			// closed along with their table or by the body
+		default:
+			if !isOptionalEndTagAtom(n.DataAtom) {
+				report(DiagnosticCodeElementImplicitlyClosed, DiagnosticSeverityWarning, fmt.Sprintf("element was implicitly closed by the end of its parent: %s", n.Data))
+			}
+		}
+	case ImpliedEndTagReasonSibling:
+		next := findTreeNextElement(n)
+
+		if n.DataAtom == atom.P {
+			// optional, but the sibling may not have been expected to close it (e.g. a div)
+			if next != nil && next.DataAtom != atom.P {
+				report(DiagnosticCodeElementImplicitlyClosed, DiagnosticSeverityInfo, fmt.Sprintf("element was implicitly closed by a following %s: %s", next.Data, n.Data))
+			}
+		} else {
+			switch n.DataAtom {
+			case atom.Caption, atom.Colgroup, atom.Head:
+				// closed by the next section of their table or by the body
+			default:
+				if !isOptionalEndTagAtom(n.DataAtom) {
+					message := fmt.Sprintf("element was implicitly closed: %s", n.Data)
+					if next != nil {
+						message = fmt.Sprintf("element was implicitly closed by a following %s: %s", next.Data, n.Data)
+					}
+
+					report(DiagnosticCodeElementImplicitlyClosed, DiagnosticSeverityWarning, message)
+				}
+			}
+		}
+	}
+}
+
+// findTreeNextElement returns the nearest following sibling which is an element, since only an element may close its
+// preceding sibling. Text and comments between them (e.g. foster parented text) are skipped.
+func findTreeNextElement(n *html.Node) *html.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// findTreeSibling returns the nearest sibling, in the direction of step, whose source contains the node. If a is not
+// zero, only elements of the atom are considered.
+func (p *Parser) findTreeSibling(n *html.Node, v *NodeMetadata, a atom.Atom, step int) *html.Node {
+	from := v.TokenOffsets.From.Byte
+
+	for c := n; c != nil; {
+		if step > 0 {
+			c = c.NextSibling
+		} else {
+			c = c.PrevSibling
+		}
+
+		if c == nil {
+			break
+		} else if a != 0 && c.DataAtom != a {
+			continue
+		}
+
+		if cv, ok := p.offsets.GetNodeMetadata(c); ok {
+			if outer := cv.GetOuterOffsets(); outer.From.Byte <= from && from < outer.Until.Byte {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// describeTreeNode returns the tag name of an element, otherwise the name of its type (similar to DOM nodeName).
+func describeTreeNode(n *html.Node) string {
+	switch n.Type {
+	case html.ElementNode:
+		return n.Data
+	case html.TextNode:
+		return "#text"
+	case html.CommentNode:
+		return "#comment"
+	}
+
+	return "#node"
+}
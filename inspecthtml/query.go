@@ -0,0 +1,110 @@
+package inspecthtml
+
+import (
+	"golang.org/x/net/html"
+)
+
+// QueryMatch is an element which matched a selector.
+type QueryMatch struct {
+	Node *html.Node
+
+	// NodeMetadata is nil if the node has no metadata (e.g. it was added after parsing).
+	NodeMetadata *NodeMetadata
+}
+
+// Query returns the descendants of n matching the selector, along with their metadata, in document order (similar to
+// querySelectorAll). The metadata may be nil.
+func Query(n *html.Node, metadata *ParseMetadata, selector string) ([]QueryMatch, error) {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Query(n, metadata), nil
+}
+
+// Selector is a compiled list of selectors, which supports Selectors Level 3 along with the :is, :where, :not (of
+// complex selectors), :has, and :scope pseudo-classes and the "of S" syntax of :nth-child from Level 4. Namespace
+// prefixes and pseudo-elements are not supported, and dynamic pseudo-classes (e.g. :hover) never match.
+type Selector struct {
+	source string
+	list   selectorList
+}
+
+// CompileSelector parses a list of selectors. If it is not valid (or not supported), the error wraps
+// ErrSelectorSyntax.
+func CompileSelector(selector string) (*Selector, error) {
+	p := &selectorParser{
+		s: selector,
+	}
+
+	list, err := p.parseSelectorList(false)
+	if err != nil {
+		return nil, err
+	} else if p.i < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.i])
+	}
+
+	return &Selector{
+		source: selector,
+		list:   list,
+	}, nil
+}
+
+// MustCompileSelector is the same as CompileSelector, but panics if the selector is not valid.
+func MustCompileSelector(selector string) *Selector {
+	s, err := CompileSelector(selector)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func (s *Selector) String() string {
+	return s.source
+}
+
+// Match returns true if the element matches any of the selectors. The :scope pseudo-class matches the root element.
+func (s *Selector) Match(n *html.Node) bool {
+	return s.list.match(&selectorContext{}, n)
+}
+
+// Query returns the descendants of n matching the selector, along with their metadata, in document order. When n is an
+// element, it is matched by the :scope pseudo-class. The metadata may be nil.
+func (s *Selector) Query(n *html.Node, metadata *ParseMetadata) []QueryMatch {
+	ctx := &selectorContext{}
+
+	if n.Type == html.ElementNode {
+		ctx.scope = n
+	}
+
+	var matches []QueryMatch
+	var visit func(n *html.Node)
+
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+
+			if s.list.match(ctx, c) {
+				match := QueryMatch{
+					Node: c,
+				}
+
+				if metadata != nil {
+					match.NodeMetadata, _ = metadata.GetNodeMetadata(c)
+				}
+
+				matches = append(matches, match)
+			}
+
+			visit(c)
+		}
+	}
+
+	visit(n)
+
+	return matches
+}
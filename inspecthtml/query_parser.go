@@ -0,0 +1,632 @@
+package inspecthtml
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrSelectorSyntax = errors.New("invalid selector")
+
+// selectorParser parses selectors following the tokenization of CSS Syntax, but directly from the string.
+type selectorParser struct {
+	s string
+	i int
+}
+
+func (p *selectorParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s (offset %d)", ErrSelectorSyntax, fmt.Sprintf(format, args...), p.i)
+}
+
+func (p *selectorParser) peek(offset int) byte {
+	if p.i+offset >= len(p.s) {
+		return 0
+	}
+
+	return p.s[p.i+offset]
+}
+
+// skipWhitespace returns true if any whitespace was skipped.
+func (p *selectorParser) skipWhitespace() bool {
+	start := p.i
+
+	for p.i < len(p.s) && selectorIsWhitespace(rune(p.s[p.i])) {
+		p.i++
+	}
+
+	return p.i > start
+}
+
+// parseSelectorList parses comma-separated selectors until the end or a closing parenthesis.
+func (p *selectorParser) parseSelectorList(relative bool) (selectorList, error) {
+	var list selectorList
+
+	for {
+		p.skipWhitespace()
+
+		c, err := p.parseComplex(relative)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, c)
+
+		p.skipWhitespace()
+
+		if p.peek(0) != ',' {
+			return list, nil
+		}
+
+		p.i++
+	}
+}
+
+func (p *selectorParser) parseCombinator() (selectorCombinator, bool) {
+	var combinator selectorCombinator
+
+	switch p.peek(0) {
+	case '>':
+		combinator = selectorCombinatorChild
+	case '+':
+		combinator = selectorCombinatorNextSibling
+	case '~':
+		combinator = selectorCombinatorSubsequentSibling
+	default:
+		return selectorCombinatorNone, false
+	}
+
+	p.i++
+
+	return combinator, true
+}
+
+func (p *selectorParser) parseComplex(relative bool) (*selectorComplex, error) {
+	c := &selectorComplex{}
+	combinator := selectorCombinatorNone
+
+	if relative {
+		c.compounds = append(c.compounds, selectorCompound{
+			conditions: []selectorCondition{selectorAnchorCondition},
+		})
+
+		combinator = selectorCombinatorDescendant
+
+		if v, ok := p.parseCombinator(); ok {
+			combinator = v
+			c.siblings = v == selectorCombinatorNextSibling || v == selectorCombinatorSubsequentSibling
+
+			p.skipWhitespace()
+		}
+	}
+
+	for {
+		compound, err := p.parseCompound()
+		if err != nil {
+			return nil, err
+		}
+
+		compound.combinator = combinator
+		c.compounds = append(c.compounds, compound)
+
+		whitespace := p.skipWhitespace()
+
+		switch p.peek(0) {
+		case 0, ',', ')':
+			return c, nil
+		}
+
+		if v, ok := p.parseCombinator(); ok {
+			combinator = v
+
+			p.skipWhitespace()
+		} else if whitespace {
+			combinator = selectorCombinatorDescendant
+		} else {
+			return nil, p.errorf("unexpected %q", p.s[p.i])
+		}
+	}
+}
+
+func (p *selectorParser) parseCompound() (selectorCompound, error) {
+	var compound selectorCompound
+	var typed bool
+
+	if p.peek(0) == '*' {
+		p.i++
+		typed = true
+	} else if name, ok := p.readIdent(); ok {
+		compound.conditions = append(compound.conditions, selectorTypeCondition(name))
+		typed = true
+	}
+
+	if p.peek(0) == '|' && p.peek(1) != '=' {
+		return compound, p.errorf("namespace prefixes are not supported")
+	}
+
+	for {
+		switch p.peek(0) {
+		case '#':
+			p.i++
+
+			name, ok := p.readName()
+			if !ok {
+				return compound, p.errorf("expected id")
+			}
+
+			compound.conditions = append(compound.conditions, selectorIDCondition(name))
+		case '.':
+			p.i++
+
+			name, ok := p.readIdent()
+			if !ok {
+				return compound, p.errorf("expected class")
+			}
+
+			compound.conditions = append(compound.conditions, selectorClassCondition(name))
+		case '[':
+			p.i++
+
+			condition, err := p.parseAttr()
+			if err != nil {
+				return compound, err
+			}
+
+			compound.conditions = append(compound.conditions, condition)
+		case ':':
+			p.i++
+
+			if p.peek(0) == ':' {
+				return compound, p.errorf("pseudo-elements are not supported")
+			}
+
+			conditions, err := p.parsePseudoClass()
+			if err != nil {
+				return compound, err
+			}
+
+			compound.conditions = append(compound.conditions, conditions...)
+		default:
+			if !typed && len(compound.conditions) == 0 {
+				if p.i < len(p.s) {
+					return compound, p.errorf("unexpected %q", p.s[p.i])
+				}
+
+				return compound, p.errorf("expected selector")
+			}
+
+			return compound, nil
+		}
+	}
+}
+
+func (p *selectorParser) parseAttr() (selectorCondition, error) {
+	p.skipWhitespace()
+
+	name, ok := p.readIdent()
+	if !ok {
+		return nil, p.errorf("expected attribute name")
+	} else if p.peek(0) == '|' && p.peek(1) != '=' {
+		return nil, p.errorf("namespace prefixes are not supported")
+	}
+
+	p.skipWhitespace()
+
+	if p.peek(0) == ']' {
+		p.i++
+
+		return selectorAttrCondition(name, "", "", 0), nil
+	}
+
+	var op string
+
+	if p.peek(0) == '=' {
+		op = "="
+	} else if strings.IndexByte("~|^$*", p.peek(0)) >= 0 && p.peek(1) == '=' {
+		op = p.s[p.i : p.i+2]
+	} else {
+		return nil, p.errorf("expected attribute operator")
+	}
+
+	p.i += len(op)
+	p.skipWhitespace()
+
+	var value string
+
+	if c := p.peek(0); c == '"' || c == '\'' {
+		value, ok = p.readString()
+	} else {
+		value, ok = p.readIdent()
+	}
+
+	if !ok {
+		return nil, p.errorf("expected attribute value")
+	}
+
+	p.skipWhitespace()
+
+	var flag byte
+
+	if v, ok := p.readIdent(); ok {
+		switch selectorASCIILower(v) {
+		case "i":
+			flag = 'i'
+		case "s":
+			flag = 's'
+		default:
+			return nil, p.errorf("unexpected attribute flag %q", v)
+		}
+
+		p.skipWhitespace()
+	}
+
+	if p.peek(0) != ']' {
+		return nil, p.errorf("expected ]")
+	}
+
+	p.i++
+
+	return selectorAttrCondition(name, op, value, flag), nil
+}
+
+func (p *selectorParser) parsePseudoClass() ([]selectorCondition, error) {
+	name, ok := p.readIdent()
+	if !ok {
+		return nil, p.errorf("expected pseudo-class")
+	}
+
+	name = selectorASCIILower(name)
+
+	if p.peek(0) != '(' {
+		switch name {
+		case "root":
+			return []selectorCondition{selectorRootCondition}, nil
+		case "scope":
+			return []selectorCondition{selectorScopeCondition}, nil
+		case "empty":
+			return []selectorCondition{selectorEmptyCondition}, nil
+		case "first-child":
+			return []selectorCondition{selectorNthCondition(0, 1, false, false, nil)}, nil
+		case "last-child":
+			return []selectorCondition{selectorNthCondition(0, 1, true, false, nil)}, nil
+		case "only-child":
+			return []selectorCondition{
+				selectorNthCondition(0, 1, false, false, nil),
+				selectorNthCondition(0, 1, true, false, nil),
+			}, nil
+		case "first-of-type":
+			return []selectorCondition{selectorNthCondition(0, 1, false, true, nil)}, nil
+		case "last-of-type":
+			return []selectorCondition{selectorNthCondition(0, 1, true, true, nil)}, nil
+		case "only-of-type":
+			return []selectorCondition{
+				selectorNthCondition(0, 1, false, true, nil),
+				selectorNthCondition(0, 1, true, true, nil),
+			}, nil
+		case "link", "any-link":
+			return []selectorCondition{selectorLinkCondition}, nil
+		case "checked":
+			return []selectorCondition{selectorCheckedCondition}, nil
+		case "enabled":
+			return []selectorCondition{selectorEnabledCondition}, nil
+		case "disabled":
+			return []selectorCondition{selectorDisabledCondition}, nil
+		case "visited", "hover", "active", "focus", "focus-within", "focus-visible", "target":
+			// dynamic, which depend on user interaction
+			return []selectorCondition{selectorNeverCondition}, nil
+		case "before", "after", "first-line", "first-letter":
+			return nil, p.errorf("pseudo-elements are not supported")
+		}
+
+		return nil, p.errorf("unsupported pseudo-class :%s", name)
+	}
+
+	p.i++
+	p.skipWhitespace()
+
+	var condition selectorCondition
+
+	switch name {
+	case "is", "where", "not", "has":
+		list, err := p.parseSelectorList(name == "has")
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "not":
+			condition = selectorNotCondition(list)
+		case "has":
+			condition = selectorHasCondition(list)
+		default:
+			// where only differs by specificity
+			condition = selectorIsCondition(list)
+		}
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		a, b, err := p.parseNth()
+		if err != nil {
+			return nil, err
+		}
+
+		var of selectorList
+
+		if name == "nth-child" || name == "nth-last-child" {
+			start := p.i
+
+			if p.skipWhitespace() {
+				if v, ok := p.readIdent(); ok && selectorASCIILower(v) == "of" && p.skipWhitespace() {
+					of, err = p.parseSelectorList(false)
+					if err != nil {
+						return nil, err
+					}
+				} else {
+					p.i = start
+				}
+			}
+		}
+
+		condition = selectorNthCondition(a, b, strings.Contains(name, "last"), strings.HasSuffix(name, "of-type"), of)
+	case "lang":
+		var ranges []string
+
+		for {
+			var v string
+			var ok bool
+
+			if c := p.peek(0); c == '"' || c == '\'' {
+				v, ok = p.readString()
+			} else {
+				v, ok = p.readIdent()
+			}
+
+			if !ok {
+				return nil, p.errorf("expected language")
+			}
+
+			ranges = append(ranges, v)
+
+			p.skipWhitespace()
+
+			if p.peek(0) != ',' {
+				break
+			}
+
+			p.i++
+			p.skipWhitespace()
+		}
+
+		condition = selectorLangCondition(ranges)
+	default:
+		return nil, p.errorf("unsupported pseudo-class :%s()", name)
+	}
+
+	p.skipWhitespace()
+
+	if p.peek(0) != ')' {
+		return nil, p.errorf("expected )")
+	}
+
+	p.i++
+
+	return []selectorCondition{condition}, nil
+}
+
+// parseNth parses the An+B microsyntax, including odd and even.
+func (p *selectorParser) parseNth() (int, int, error) {
+	start := p.i
+
+	if v, ok := p.readIdent(); ok {
+		switch selectorASCIILower(v) {
+		case "odd":
+			return 2, 1, nil
+		case "even":
+			return 2, 0, nil
+		}
+
+		// rescan, since n-based values are also identifiers
+		p.i = start
+	}
+
+	sign := 1
+
+	switch p.peek(0) {
+	case '-':
+		sign = -1
+		p.i++
+	case '+':
+		p.i++
+	}
+
+	digits := p.readDigits()
+
+	if c := p.peek(0); c != 'n' && c != 'N' {
+		if digits == "" {
+			p.i = start
+
+			return 0, 0, p.errorf("expected An+B")
+		}
+
+		b, err := strconv.Atoi(digits)
+		if err != nil {
+			return 0, 0, p.errorf("invalid integer %q", digits)
+		}
+
+		return 0, sign * b, nil
+	}
+
+	p.i++
+
+	a := 1
+
+	if digits != "" {
+		var err error
+
+		a, err = strconv.Atoi(digits)
+		if err != nil {
+			return 0, 0, p.errorf("invalid integer %q", digits)
+		}
+	}
+
+	a *= sign
+	end := p.i
+
+	p.skipWhitespace()
+
+	sign = 1
+
+	switch p.peek(0) {
+	case '-':
+		sign = -1
+	case '+':
+		// positive
+	default:
+		p.i = end
+
+		return a, 0, nil
+	}
+
+	p.i++
+	p.skipWhitespace()
+
+	digits = p.readDigits()
+	if digits == "" {
+		return 0, 0, p.errorf("expected An+B")
+	}
+
+	b, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, 0, p.errorf("invalid integer %q", digits)
+	}
+
+	return a, sign * b, nil
+}
+
+func (p *selectorParser) readDigits() string {
+	start := p.i
+
+	for p.i < len(p.s) && '0' <= p.s[p.i] && p.s[p.i] <= '9' {
+		p.i++
+	}
+
+	return p.s[start:p.i]
+}
+
+func selectorIsNameStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c >= utf8.RuneSelf
+}
+
+func selectorIsName(c byte) bool {
+	return selectorIsNameStart(c) || '0' <= c && c <= '9' || c == '-'
+}
+
+func (p *selectorParser) isEscape(offset int) bool {
+	return p.peek(offset) == '\\' && p.i+offset+1 < len(p.s) && p.peek(offset+1) != '\n' && p.peek(offset+1) != '\r' && p.peek(offset+1) != '\f'
+}
+
+// readIdent reads an identifier, if one starts at the current position.
+func (p *selectorParser) readIdent() (string, bool) {
+	switch c := p.peek(0); {
+	case c == '-':
+		if !selectorIsNameStart(p.peek(1)) && p.peek(1) != '-' && !p.isEscape(1) {
+			return "", false
+		}
+	case selectorIsNameStart(c), p.isEscape(0):
+		// valid
+	default:
+		return "", false
+	}
+
+	return p.readName()
+}
+
+// readName reads a sequence of name characters and escapes (e.g. of an id, which may start with a digit).
+func (p *selectorParser) readName() (string, bool) {
+	sb := &strings.Builder{}
+
+	for p.i < len(p.s) {
+		if selectorIsName(p.s[p.i]) {
+			sb.WriteByte(p.s[p.i])
+			p.i++
+		} else if p.isEscape(0) {
+			p.i++
+			sb.WriteRune(p.readEscape())
+		} else {
+			break
+		}
+	}
+
+	return sb.String(), sb.Len() > 0
+}
+
+// readEscape reads the escaped character after a backslash.
+func (p *selectorParser) readEscape() rune {
+	start := p.i
+
+	for p.i < len(p.s) && p.i-start < 6 && strings.IndexByte("0123456789abcdefABCDEF", p.s[p.i]) >= 0 {
+		p.i++
+	}
+
+	if p.i == start {
+		r, size := utf8.DecodeRuneInString(p.s[p.i:])
+		p.i += size
+
+		return r
+	}
+
+	v, _ := strconv.ParseUint(p.s[start:p.i], 16, 32)
+
+	// a single whitespace ends the escape
+	if p.peek(0) == '\r' && p.peek(1) == '\n' {
+		p.i += 2
+	} else if selectorIsWhitespace(rune(p.peek(0))) {
+		p.i++
+	}
+
+	if v == 0 || v > utf8.MaxRune || 0xd800 <= v && v <= 0xdfff {
+		return utf8.RuneError
+	}
+
+	return rune(v)
+}
+
+// readString reads a quoted string, if one starts at the current position.
+func (p *selectorParser) readString() (string, bool) {
+	quote := p.peek(0)
+	if quote != '"' && quote != '\'' {
+		return "", false
+	}
+
+	p.i++
+
+	sb := &strings.Builder{}
+
+	for p.i < len(p.s) {
+		switch c := p.s[p.i]; c {
+		case quote:
+			p.i++
+
+			return sb.String(), true
+		case '\n', '\r', '\f':
+			return "", false
+		case '\\':
+			if p.peek(1) == '\r' && p.peek(2) == '\n' {
+				// line continuation
+				p.i += 3
+			} else if c := p.peek(1); c == '\n' || c == '\r' || c == '\f' {
+				p.i += 2
+			} else if p.i+1 == len(p.s) {
+				p.i++
+			} else {
+				p.i++
+				sb.WriteRune(p.readEscape())
+			}
+		default:
+			sb.WriteByte(c)
+			p.i++
+		}
+	}
+
+	// unterminated at the end is still valid
+	return sb.String(), true
+}
@@ -0,0 +1,509 @@
+package inspecthtml
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type selectorCombinator int
+
+const (
+	// selectorCombinatorNone is used for the first compound selector of a complex selector.
+	selectorCombinatorNone selectorCombinator = iota
+	selectorCombinatorDescendant
+	selectorCombinatorChild
+	selectorCombinatorNextSibling
+	selectorCombinatorSubsequentSibling
+)
+
+type selectorContext struct {
+	// scope is the element matched by :scope; otherwise, the root element.
+	scope *html.Node
+
+	// anchor is the element of the relative selectors of :has.
+	anchor *html.Node
+}
+
+// selectorCondition is a simple selector (e.g. a type, attribute, or pseudo-class) of a compound selector.
+type selectorCondition func(ctx *selectorContext, n *html.Node) bool
+
+type selectorCompound struct {
+	// combinator is how the compound relates to the previous compound of the complex selector.
+	combinator selectorCombinator
+	conditions []selectorCondition
+}
+
+func (c selectorCompound) match(ctx *selectorContext, n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+
+	for _, condition := range c.conditions {
+		if !condition(ctx, n) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selectorComplex is a sequence of compound selectors and their combinators. The last compound is the subject. For
+// relative selectors, the first compound only matches the anchor.
+type selectorComplex struct {
+	compounds []selectorCompound
+
+	// siblings is true if a relative selector may match siblings of the anchor (and their descendants).
+	siblings bool
+}
+
+func (c *selectorComplex) match(ctx *selectorContext, n *html.Node) bool {
+	return c.matchCompound(ctx, len(c.compounds)-1, n)
+}
+
+// matchCompound matches the compound at the index and, from right to left, the compounds before it.
+func (c *selectorComplex) matchCompound(ctx *selectorContext, i int, n *html.Node) bool {
+	compound := c.compounds[i]
+
+	if !compound.match(ctx, n) {
+		return false
+	}
+
+	switch compound.combinator {
+	case selectorCombinatorDescendant:
+		for p := n.Parent; p != nil; p = p.Parent {
+			if c.matchCompound(ctx, i-1, p) {
+				return true
+			}
+		}
+
+		return false
+	case selectorCombinatorChild:
+		return n.Parent != nil && c.matchCompound(ctx, i-1, n.Parent)
+	case selectorCombinatorNextSibling:
+		p := selectorPreviousElementSibling(n)
+
+		return p != nil && c.matchCompound(ctx, i-1, p)
+	case selectorCombinatorSubsequentSibling:
+		for p := selectorPreviousElementSibling(n); p != nil; p = selectorPreviousElementSibling(p) {
+			if c.matchCompound(ctx, i-1, p) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+type selectorList []*selectorComplex
+
+func (l selectorList) match(ctx *selectorContext, n *html.Node) bool {
+	for _, c := range l {
+		if c.match(ctx, n) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func selectorPreviousElementSibling(n *html.Node) *html.Node {
+	for n = n.PrevSibling; n != nil; n = n.PrevSibling {
+		if n.Type == html.ElementNode {
+			return n
+		}
+	}
+
+	return nil
+}
+
+// selectorGetAttr returns the value of an attribute without a namespace.
+func selectorGetAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+
+	return "", false
+}
+
+func selectorIsHTML(n *html.Node, names ...string) bool {
+	if n.Namespace != "" {
+		return false
+	}
+
+	for _, name := range names {
+		if n.Data == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectorASCIILower is used for case-insensitive matching, which is only for ASCII letters.
+func selectorASCIILower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+
+		return r
+	}, s)
+}
+
+// selectorFieldsContain returns true if a whitespace-separated list contains the value.
+func selectorFieldsContain(list, v string) bool {
+	if v == "" {
+		return false
+	}
+
+	for _, field := range strings.FieldsFunc(list, selectorIsWhitespace) {
+		if field == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func selectorIsWhitespace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+func selectorTypeCondition(name string) selectorCondition {
+	lower := selectorASCIILower(name)
+
+	return func(_ *selectorContext, n *html.Node) bool {
+		if n.Namespace == "" {
+			return n.Data == lower
+		}
+
+		// foreign elements are case-sensitive
+		return n.Data == name
+	}
+}
+
+func selectorIDCondition(id string) selectorCondition {
+	return func(_ *selectorContext, n *html.Node) bool {
+		v, ok := selectorGetAttr(n, "id")
+
+		return ok && v == id
+	}
+}
+
+func selectorClassCondition(class string) selectorCondition {
+	return func(_ *selectorContext, n *html.Node) bool {
+		v, ok := selectorGetAttr(n, "class")
+
+		return ok && selectorFieldsContain(v, class)
+	}
+}
+
+// selectorCaseInsensitiveAttrs are the attributes of HTML elements whose values are matched case-insensitively, unless
+// the s flag is used.
+//
+// https://html.spec.whatwg.org/multipage/semantics-other.html#case-sensitivity-of-selectors
+var selectorCaseInsensitiveAttrs = map[string]bool{
+	"accept": true, "accept-charset": true, "align": true, "alink": true, "axis": true, "bgcolor": true, "charset": true,
+	"checked": true, "clear": true, "codetype": true, "color": true, "compact": true, "declare": true, "defer": true,
+	"dir": true, "direction": true, "disabled": true, "enctype": true, "face": true, "frame": true, "hreflang": true,
+	"http-equiv": true, "lang": true, "language": true, "link": true, "media": true, "method": true, "multiple": true,
+	"nohref": true, "noresize": true, "noshade": true, "nowrap": true, "readonly": true, "rel": true, "rev": true,
+	"rules": true, "scope": true, "scrolling": true, "selected": true, "shape": true, "target": true, "text": true,
+	"type": true, "valign": true, "valuetype": true, "vlink": true,
+}
+
+// selectorAttrCondition matches an attribute by its operator (e.g. `^=`, or empty if it only needs to exist) and flag
+// (`i`, `s`, or 0).
+func selectorAttrCondition(name, op, value string, flag byte) selectorCondition {
+	lower := selectorASCIILower(name)
+
+	return func(_ *selectorContext, n *html.Node) bool {
+		key := name
+		if n.Namespace == "" {
+			key = lower
+		}
+
+		v, ok := selectorGetAttr(n, key)
+		if !ok {
+			return false
+		} else if op == "" {
+			return true
+		}
+
+		expected := value
+
+		if flag == 'i' || flag == 0 && n.Namespace == "" && selectorCaseInsensitiveAttrs[lower] {
+			v = selectorASCIILower(v)
+			expected = selectorASCIILower(expected)
+		}
+
+		switch op {
+		case "=":
+			return v == expected
+		case "~=":
+			return !strings.ContainsFunc(expected, selectorIsWhitespace) && selectorFieldsContain(v, expected)
+		case "|=":
+			return v == expected || strings.HasPrefix(v, expected+"-")
+		case "^=":
+			return expected != "" && strings.HasPrefix(v, expected)
+		case "$=":
+			return expected != "" && strings.HasSuffix(v, expected)
+		case "*=":
+			return expected != "" && strings.Contains(v, expected)
+		}
+
+		return false
+	}
+}
+
+// selectorNthCondition matches the index (one-based) of an element among its siblings by an+b, where the siblings are
+// optionally limited to the same type or those matching a list of selectors.
+func selectorNthCondition(a, b int, last, ofType bool, of selectorList) selectorCondition {
+	return func(ctx *selectorContext, n *html.Node) bool {
+		if of != nil && !of.match(ctx, n) {
+			return false
+		}
+
+		idx := 1
+
+		for s := n; ; {
+			if last {
+				s = s.NextSibling
+			} else {
+				s = s.PrevSibling
+			}
+
+			if s == nil {
+				break
+			} else if s.Type != html.ElementNode {
+				continue
+			} else if ofType && (s.Data != n.Data || s.Namespace != n.Namespace) {
+				continue
+			} else if of != nil && !of.match(ctx, s) {
+				continue
+			}
+
+			idx++
+		}
+
+		if a == 0 {
+			return idx == b
+		}
+
+		return (idx-b)%a == 0 && (idx-b)/a >= 0
+	}
+}
+
+func selectorRootCondition(_ *selectorContext, n *html.Node) bool {
+	return n.Parent != nil && n.Parent.Type == html.DocumentNode
+}
+
+func selectorScopeCondition(ctx *selectorContext, n *html.Node) bool {
+	if ctx.scope == nil {
+		return selectorRootCondition(ctx, n)
+	}
+
+	return n == ctx.scope
+}
+
+func selectorAnchorCondition(ctx *selectorContext, n *html.Node) bool {
+	return n == ctx.anchor
+}
+
+func selectorNeverCondition(_ *selectorContext, _ *html.Node) bool {
+	return false
+}
+
+func selectorEmptyCondition(_ *selectorContext, n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode || c.Type == html.TextNode && c.Data != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func selectorLinkCondition(_ *selectorContext, n *html.Node) bool {
+	if !selectorIsHTML(n, "a", "area", "link") {
+		return false
+	}
+
+	_, ok := selectorGetAttr(n, "href")
+
+	return ok
+}
+
+func selectorCheckedCondition(_ *selectorContext, n *html.Node) bool {
+	if selectorIsHTML(n, "input") {
+		v, _ := selectorGetAttr(n, "type")
+
+		switch selectorASCIILower(v) {
+		case "checkbox", "radio":
+			_, ok := selectorGetAttr(n, "checked")
+
+			return ok
+		}
+	} else if selectorIsHTML(n, "option") {
+		_, ok := selectorGetAttr(n, "selected")
+
+		return ok
+	}
+
+	return false
+}
+
+// selectorIsDisabled returns whether a form control is disabled, or false if the element cannot be disabled.
+func selectorIsDisabled(n *html.Node) (bool, bool) {
+	if selectorIsHTML(n, "optgroup") {
+		_, ok := selectorGetAttr(n, "disabled")
+
+		return ok, true
+	} else if selectorIsHTML(n, "option") {
+		if _, ok := selectorGetAttr(n, "disabled"); ok {
+			return true, true
+		} else if p := n.Parent; p != nil && selectorIsHTML(p, "optgroup") {
+			_, ok := selectorGetAttr(p, "disabled")
+
+			return ok, true
+		}
+
+		return false, true
+	} else if !selectorIsHTML(n, "button", "input", "select", "textarea", "fieldset") {
+		return false, false
+	}
+
+	if _, ok := selectorGetAttr(n, "disabled"); ok {
+		return true, true
+	}
+
+	// a disabled fieldset disables its descendants, except those of its first legend
+	for c, p := n, n.Parent; p != nil; c, p = p, p.Parent {
+		if !selectorIsHTML(p, "fieldset") {
+			continue
+		} else if _, ok := selectorGetAttr(p, "disabled"); !ok {
+			continue
+		}
+
+		var legend *html.Node
+
+		for s := p.FirstChild; s != nil; s = s.NextSibling {
+			if selectorIsHTML(s, "legend") {
+				legend = s
+
+				break
+			}
+		}
+
+		if legend == nil || c != legend {
+			return true, true
+		}
+	}
+
+	return false, true
+}
+
+func selectorDisabledCondition(_ *selectorContext, n *html.Node) bool {
+	disabled, ok := selectorIsDisabled(n)
+
+	return ok && disabled
+}
+
+func selectorEnabledCondition(_ *selectorContext, n *html.Node) bool {
+	disabled, ok := selectorIsDisabled(n)
+
+	return ok && !disabled
+}
+
+// selectorLangCondition matches the language of the element (or its nearest ancestor with one) by any of the ranges,
+// where a range also matches its subtags (e.g. `en` matches `en-US`).
+func selectorLangCondition(ranges []string) selectorCondition {
+	return func(_ *selectorContext, n *html.Node) bool {
+		var lang string
+		var found bool
+
+	ancestors:
+		for p := n; p != nil && p.Type == html.ElementNode; p = p.Parent {
+			for _, attr := range p.Attr {
+				if attr.Key == "lang" && (attr.Namespace == "" || attr.Namespace == "xml") {
+					lang, found = selectorASCIILower(attr.Val), true
+
+					break ancestors
+				}
+			}
+		}
+
+		if !found {
+			return false
+		}
+
+		for _, r := range ranges {
+			r = selectorASCIILower(r)
+
+			if lang == r || r != "" && strings.HasPrefix(lang, r+"-") {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func selectorNotCondition(list selectorList) selectorCondition {
+	return func(ctx *selectorContext, n *html.Node) bool {
+		return !list.match(ctx, n)
+	}
+}
+
+func selectorIsCondition(list selectorList) selectorCondition {
+	return list.match
+}
+
+// selectorHasCondition matches if any element relative to the anchor matches any of the relative selectors.
+func selectorHasCondition(list selectorList) selectorCondition {
+	var siblings bool
+
+	for _, c := range list {
+		siblings = siblings || c.siblings
+	}
+
+	return func(ctx *selectorContext, n *html.Node) bool {
+		inner := *ctx
+		inner.anchor = n
+
+		var visit func(n *html.Node) bool
+
+		visit = func(n *html.Node) bool {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode {
+					continue
+				} else if list.match(&inner, c) || visit(c) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if visit(n) {
+			return true
+		} else if !siblings {
+			return false
+		}
+
+		for s := n.NextSibling; s != nil; s = s.NextSibling {
+			if s.Type != html.ElementNode {
+				continue
+			} else if list.match(&inner, s) || visit(s) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
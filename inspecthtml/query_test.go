@@ -0,0 +1,226 @@
+package inspecthtml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestQuery(t *testing.T) {
+	input := strings.Join([]string{
+		`<html lang="en-US"><body id="body">`,
+		`<div id="d1" class="a b" title="Hello World">`,
+		`  <p id="p1" lang="fr">one</p>`,
+		`  <p id="p2" class="b"><a id="a1" href="/x">x</a><a id="a2">y</a></p>`,
+		`  <span id="s1"></span>`,
+		`  <p id="p3" data-x="a-b"></p>`,
+		`</div>`,
+		`<form id="f1"><fieldset id="fs1" disabled><legend id="l1"><input id="i1"></legend><input id="i2" type=CHECKBOX checked></fieldset>`,
+		`<select id="sel1"><optgroup id="og1" disabled><option id="o1" selected></option></optgroup><option id="o2"></option></select></form>`,
+		`<img id="img1" alt=""><img id="img2" src="a.png">`,
+		`<svg id="svg1"><foreignObject id="fo1"></foreignObject></svg>`,
+		`</body></html>`,
+	}, "\n")
+
+	document, documentMetadata, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		selector string
+		expected string
+	}{
+		{"p", "p1 p2 p3"},
+		{"*", "html head body d1 p1 p2 a1 a2 s1 p3 f1 fs1 l1 i1 i2 sel1 og1 o1 o2 img1 img2 svg1 fo1"},
+		{"#p2, #a1", "p2 a1"},
+		{"P.b", "p2"},
+		{".a.b", "d1"},
+		{"div > p", "p1 p2 p3"},
+		{"body a", "a1 a2"},
+		{"p + span", "s1"},
+		{"p ~ p", "p2 p3"},
+		{"#p1 ~ *", "p2 s1 p3"},
+		{"[href]", "a1"},
+		{"[title='Hello World']", "d1"},
+		{"[title='hello world' i]", "d1"},
+		{"[title~=World]", "d1"},
+		{"[data-x|=a]", "p3"},
+		{"[title^=Hell][title$=rld][title*='o W']", "d1"},
+		{"[title^='']", ""},
+		{"[type=checkbox]", "i2"},
+		{"[type=checkbox s]", ""},
+		{"p:first-child", "p1"},
+		{"a:last-child", "a2"},
+		{"p:last-of-type", "p3"},
+		{"span:only-of-type", "s1"},
+		{"a:only-child", ""},
+		{"div > :nth-child(2n+1)", "p1 s1"},
+		{"div > :nth-child(-n+2)", "p1 p2"},
+		{"div > :nth-last-child(odd)", "p2 p3"},
+		{"div > :nth-of-type(2)", "p2"},
+		{"div > :nth-last-of-type(even)", "p2"},
+		{"div > :nth-child(2 of .b, [data-x])", "p3"},
+		{":empty", "head s1 p3 i1 i2 o1 o2 img1 img2 fo1"},
+		{":root", "html"},
+		{":link", "a1"},
+		{":hover, :visited", ""},
+		{":lang(en)", "html head body d1 p2 a1 a2 s1 p3 f1 fs1 l1 i1 i2 sel1 og1 o1 o2 img1 img2 svg1 fo1"},
+		{"div :lang(fr, de)", "p1"},
+		{":disabled", "fs1 i2 og1 o1"},
+		{":enabled", "i1 sel1 o2"},
+		{":checked", "i2 o1"},
+		{"img:not([alt])", "img2"},
+		{"div > :not(p, span)", ""},
+		{":not(div p)", "html head body d1 a1 a2 s1 f1 fs1 l1 i1 i2 sel1 og1 o1 o2 img1 img2 svg1 fo1"},
+		{":is(span, a:not([href]))", "a2 s1"},
+		{":where(#p1, #p3)", "p1 p3"},
+		{"p:has(a)", "p2"},
+		{"div:has(> span)", "d1"},
+		{"div:has(> a)", ""},
+		{"p:has(+ span)", "p2"},
+		{"p:has(~ p[data-x])", "p1 p2"},
+		{":has(> p:has(a[href]))", "d1"},
+		{"foreignObject", "fo1"},
+		{"foreignobject", ""},
+		{"#\\70 1", "p1"},
+		{"[id='\\61 1']", "a1"},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			matches, err := Query(document, documentMetadata, tc.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			for _, match := range matches {
+				id, ok := selectorGetAttr(match.Node, "id")
+				if !ok {
+					id = match.Node.Data
+				}
+
+				actual = append(actual, id)
+			}
+
+			if _a, _e := strings.Join(actual, " "), tc.expected; _a != _e {
+				t.Errorf("expected %q, got %q", _e, _a)
+			}
+		})
+	}
+}
+
+func TestQueryScope(t *testing.T) {
+	input := `<div id="d1"><div id="d2"><p id="p1"></p></div><p id="p2"></p></div>`
+
+	document, documentMetadata, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var scope *html.Node
+
+	visitNode(document, func(n *html.Node) {
+		if v, _ := selectorGetAttr(n, "id"); v == "d2" {
+			scope = n
+		}
+	})
+
+	for _, tc := range []struct {
+		selector string
+		expected string
+	}{
+		{"p", "p1"},
+		{"div p", "p1"},
+		{"#d1 > div > p", "p1"},
+		{":scope > p", "p1"},
+		{":scope", ""},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			matches, err := Query(scope, documentMetadata, tc.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var actual []string
+
+			for _, match := range matches {
+				id, _ := selectorGetAttr(match.Node, "id")
+				actual = append(actual, id)
+			}
+
+			if _a, _e := strings.Join(actual, " "), tc.expected; _a != _e {
+				t.Errorf("expected %q, got %q", _e, _a)
+			}
+		})
+	}
+
+	if _a, _e := MustCompileSelector(":scope").Match(scope), false; _a != _e {
+		t.Errorf("match: expected %v, got %v", _e, _a)
+	} else if _a, _e := MustCompileSelector("div:has(> p) div").Match(scope), true; _a != _e {
+		t.Errorf("match: expected %v, got %v", _e, _a)
+	}
+}
+
+func TestQueryMetadata(t *testing.T) {
+	input := "<p>\n  <img src=a.png alt=a>\n  <img src=b.png>\n</p>"
+
+	document, documentMetadata, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// modified after parsing
+	document.LastChild.LastChild.AppendChild(&html.Node{
+		Type: html.ElementNode,
+		Data: "img",
+	})
+
+	matches, err := Query(document, documentMetadata, "img:not([alt])")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _a, _e := len(matches), 2; _a != _e {
+		t.Fatalf("matches: expected %v, got %v", _e, _a)
+	} else if _a, _e := matches[0].NodeMetadata.TokenOffsets.OffsetRangeString(), "L3C3:L3C18;0x1e:0x2d"; _a != _e {
+		t.Errorf("offsets: expected %v, got %v", _e, _a)
+	} else if _a := matches[1].NodeMetadata; _a != nil {
+		t.Errorf("metadata: expected nil, got %v", _a)
+	}
+}
+
+func TestCompileSelectorError(t *testing.T) {
+	for _, selector := range []string{
+		"",
+		"p,",
+		"p >",
+		"> p",
+		"p::before",
+		"p:first-line",
+		"svg|rect",
+		"[svg|href]",
+		"[a=]",
+		"[a=b x]",
+		"[a",
+		"p:unknown",
+		"p:not()",
+		"p:nth-child(n+)",
+		"p:nth-child(+ n)",
+		"p:nth-child(2 of)",
+		"p:lang()",
+		"#",
+		"p)",
+	} {
+		t.Run(selector, func(t *testing.T) {
+			_, err := CompileSelector(selector)
+			if err == nil {
+				t.Fatalf("expected error")
+			} else if !errors.Is(err, ErrSelectorSyntax) {
+				t.Fatalf("expected ErrSelectorSyntax, got %v", err)
+			}
+		})
+	}
+}
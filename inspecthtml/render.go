@@ -0,0 +1,351 @@
+package inspecthtml
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+// RenderMapping maps a range of rendered output to the source of the node it was rendered from.
+type RenderMapping struct {
+	Node *html.Node
+
+	// Offsets is the range of output.
+	Offsets cursorio.TextOffsetRange
+
+	// SourceOffsets is the range of source, such as the start tag of an element. The output may differ from the source
+	// (e.g. quoting of attributes or character references), so only the start of each range corresponds exactly. Text
+	// is mapped by line, so the start of every line of output corresponds to its source.
+	SourceOffsets cursorio.TextOffsetRange
+}
+
+// Render writes the same output as html.Render, and returns the mappings of its output to the source of any nodes with
+// metadata, in order. The tree may have been modified after parsing, in which case nodes without metadata are not
+// mapped. The metadata may be nil. Options may change the output (e.g. RenderConfig.SetSourcePosAttr).
+func Render(w io.Writer, n *html.Node, metadata *ParseMetadata, opts ...RenderOption) ([]RenderMapping, error) {
+	cfg := &RenderConfig{
+		sourcePosAttr:  new(string),
+		sourcePosBytes: new(bool),
+		sourcePosFile:  new(string),
+	}
+
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+
+	r := &renderer{
+		w:        bufio.NewWriter(w),
+		offset:   cursorio.NewTextWriter(cursorio.TextOffset{}),
+		metadata: metadata,
+		cfg:      cfg,
+	}
+
+	err := r.render(n)
+	if err == errRenderPlaintextAbort {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.mappings, nil
+}
+
+// errRenderPlaintextAbort is returned after a plaintext element was rendered, since nothing else is rendered after it.
+var errRenderPlaintextAbort = errors.New("plaintext abort")
+
+// renderer follows the same steps as html.Render, while tracking the offsets of output. Comments and doctypes are
+// rendered by html.Render itself, since their escaping is not exported.
+type renderer struct {
+	w        *bufio.Writer
+	offset   *cursorio.TextWriter
+	metadata *ParseMetadata
+	cfg      *RenderConfig
+	mappings []RenderMapping
+}
+
+// getNodeMetadata returns the metadata of a node, if any. Rendering does not require metadata, in which case nothing
+// is mapped.
+func (r *renderer) getNodeMetadata(n *html.Node) (*NodeMetadata, bool) {
+	if r.metadata == nil {
+		return nil, false
+	}
+
+	return r.metadata.GetNodeMetadata(n)
+}
+
+func (r *renderer) writeString(s string) error {
+	r.offset.Write([]byte(s))
+
+	_, err := r.w.WriteString(s)
+
+	return err
+}
+
+// writeMapped writes s and, if source is not nil, maps its output to the source.
+func (r *renderer) writeMapped(n *html.Node, s string, source *cursorio.TextOffsetRange) error {
+	from := r.offset.GetTextOffset()
+
+	err := r.writeString(s)
+	if err != nil {
+		return err
+	}
+
+	if source != nil {
+		r.mappings = append(r.mappings, RenderMapping{
+			Node: n,
+			Offsets: cursorio.TextOffsetRange{
+				From:  from,
+				Until: r.offset.GetTextOffset(),
+			},
+			SourceOffsets: *source,
+		})
+	}
+
+	return nil
+}
+
+// renderDetached renders a copy of a node without its relatives using html.Render.
+func (r *renderer) renderDetached(n *html.Node) error {
+	buf := &bytes.Buffer{}
+
+	err := html.Render(buf, &html.Node{
+		Type:      n.Type,
+		DataAtom:  n.DataAtom,
+		Data:      n.Data,
+		Namespace: n.Namespace,
+		Attr:      n.Attr,
+	})
+	if err != nil {
+		return err
+	}
+
+	var source *cursorio.TextOffsetRange
+
+	if v, ok := r.getNodeMetadata(n); ok {
+		source = &v.TokenOffsets
+	}
+
+	return r.writeMapped(n, buf.String(), source)
+}
+
+// renderText writes the data of a text node, escaped unless literal. Each line of output is mapped separately.
+func (r *renderer) renderText(n *html.Node, literal bool) error {
+	v, ok := r.getNodeMetadata(n)
+
+	for i := 0; i < len(n.Data); {
+		until := len(n.Data)
+		if j := strings.IndexByte(n.Data[i:], '\n'); j >= 0 {
+			until = i + j + 1
+		}
+
+		line := n.Data[i:until]
+		if !literal {
+			line = html.EscapeString(line)
+		}
+
+		var source *cursorio.TextOffsetRange
+
+		if ok {
+			from, fromOK := v.GetTextDataOffset(n.Data, i)
+			sourceUntil, untilOK := v.GetTextDataOffset(n.Data, until)
+
+			if fromOK && untilOK {
+				source = &cursorio.TextOffsetRange{
+					From:  from,
+					Until: sourceUntil,
+				}
+			}
+		}
+
+		err := r.writeMapped(n, line, source)
+		if err != nil {
+			return err
+		}
+
+		i = until
+	}
+
+	return nil
+}
+
+func (r *renderer) render(n *html.Node) error {
+	switch n.Type {
+	case html.ErrorNode:
+		return errors.New("html: cannot render an ErrorNode node")
+	case html.TextNode:
+		return r.renderText(n, false)
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := r.render(c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case html.ElementNode:
+		// below
+	case html.CommentNode, html.DoctypeNode:
+		return r.renderDetached(n)
+	case html.RawNode:
+		return r.writeString(n.Data)
+	default:
+		return errors.New("html: unknown node type")
+	}
+
+	v, hasMetadata := r.getNodeMetadata(n)
+
+	var startTag, endTag *cursorio.TextOffsetRange
+
+	if hasMetadata {
+		startTag = &v.TokenOffsets
+
+		if v.EndTagTokenOffsets != nil && v.ImpliedEndTagReason == 0 && v.EndTagTokenOffsets.Until.Byte > v.EndTagTokenOffsets.From.Byte {
+			endTag = v.EndTagTokenOffsets
+		}
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("<")
+	sb.WriteString(n.Data)
+
+	for _, a := range n.Attr {
+		if hasMetadata && *r.cfg.sourcePosAttr != "" && a.Namespace == "" && a.Key == *r.cfg.sourcePosAttr {
+			// replaced below
+			continue
+		}
+
+		sb.WriteString(" ")
+
+		if a.Namespace != "" {
+			sb.WriteString(a.Namespace)
+			sb.WriteString(":")
+		}
+
+		sb.WriteString(a.Key)
+		sb.WriteString(`="`)
+		sb.WriteString(html.EscapeString(a.Val))
+		sb.WriteString(`"`)
+	}
+
+	if hasMetadata && *r.cfg.sourcePosAttr != "" {
+		sb.WriteString(" ")
+		sb.WriteString(*r.cfg.sourcePosAttr)
+		sb.WriteString(`="`)
+		sb.WriteString(html.EscapeString(r.sourcePos(v.GetOuterOffsets())))
+		sb.WriteString(`"`)
+	}
+
+	if renderVoidElements[n.Data] {
+		if n.FirstChild != nil {
+			return fmt.Errorf("html: void element <%s> has child nodes", n.Data)
+		}
+
+		sb.WriteString("/>")
+
+		return r.writeMapped(n, sb.String(), startTag)
+	}
+
+	sb.WriteString(">")
+
+	// initial newline where there is danger of a newline being ignored
+	if c := n.FirstChild; c != nil && c.Type == html.TextNode && strings.HasPrefix(c.Data, "\n") {
+		switch n.Data {
+		case "pre", "listing", "textarea":
+			sb.WriteString("\n")
+		}
+	}
+
+	err := r.writeMapped(n, sb.String(), startTag)
+	if err != nil {
+		return err
+	}
+
+	literal := renderChildTextNodesAreLiteral(n)
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if literal && c.Type == html.TextNode {
+			err = r.renderText(c, true)
+		} else {
+			err = r.render(c)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if literal && n.Data == "plaintext" {
+		return errRenderPlaintextAbort
+	}
+
+	return r.writeMapped(n, "</"+n.Data+">", endTag)
+}
+
+// sourcePos returns the value of the source position attribute for the range (see RenderConfig.SetSourcePosAttr).
+func (r *renderer) sourcePos(offsets cursorio.TextOffsetRange) string {
+	var s string
+
+	if *r.cfg.sourcePosFile != "" {
+		s = *r.cfg.sourcePosFile + ":"
+	}
+
+	s += fmt.Sprintf(
+		"%d:%d-%d:%d",
+		offsets.From.LineColumn[0]+1,
+		offsets.From.LineColumn[1]+1,
+		offsets.Until.LineColumn[0]+1,
+		offsets.Until.LineColumn[1],
+	)
+
+	if *r.cfg.sourcePosBytes {
+		s += fmt.Sprintf(";%d-%d", offsets.From.Byte, offsets.Until.Byte)
+	}
+
+	return s
+}
+
+// renderChildTextNodesAreLiteral is the same as upstream, where the text of some elements is not escaped.
+func renderChildTextNodesAreLiteral(n *html.Node) bool {
+	if n.Namespace != "" {
+		return false
+	}
+
+	switch n.Data {
+	case "iframe", "noembed", "noframes", "noscript", "plaintext", "script", "style", "xmp":
+		return true
+	}
+
+	return false
+}
+
+// renderVoidElements is the same as upstream, which is by tag name rather than atom.
+var renderVoidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"keygen": true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
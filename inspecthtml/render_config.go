@@ -0,0 +1,55 @@
+package inspecthtml
+
+type RenderOption interface {
+	apply(*RenderConfig)
+}
+
+type RenderConfig struct {
+	sourcePosAttr  *string
+	sourcePosBytes *bool
+	sourcePosFile  *string
+}
+
+var _ RenderOption = RenderConfig{}
+
+func (c RenderConfig) apply(o *RenderConfig) {
+	if c.sourcePosAttr != nil {
+		o.sourcePosAttr = c.sourcePosAttr
+	}
+
+	if c.sourcePosBytes != nil {
+		o.sourcePosBytes = c.sourcePosBytes
+	}
+
+	if c.sourcePosFile != nil {
+		o.sourcePosFile = c.sourcePosFile
+	}
+}
+
+// SetSourcePosAttr enables adding an attribute with the source position (e.g. `data-sourcepos`) to every element with
+// metadata, similar to CommonMark renderers. The value is the outer range of the element as `line:column-line:column`,
+// where both are one-based and the end column is inclusive (e.g. `3:5-7:12`). An end column of 0 is used when the range
+// ended with a line break. Any existing attribute of the same name is replaced, so the output is no longer the same as
+// html.Render. An empty name disables it.
+func (c RenderConfig) SetSourcePosAttr(name string) RenderConfig {
+	c.sourcePosAttr = &name
+
+	return c
+}
+
+// SetSourcePosBytes enables appending the byte offsets of the outer range to the source position, as `;from-until`
+// where until is exclusive (e.g. `3:5-7:12;40-152`).
+func (c RenderConfig) SetSourcePosBytes(v bool) RenderConfig {
+	c.sourcePosBytes = &v
+
+	return c
+}
+
+// SetSourcePosFile enables prefixing the source position with an identifier of the source file, as `file:` (e.g.
+// `index.html:3:5-7:12`). Since the identifier may contain colons, it ends at the colon before the position. An empty
+// identifier disables it.
+func (c RenderConfig) SetSourcePosFile(v string) RenderConfig {
+	c.sourcePosFile = &v
+
+	return c
+}
@@ -0,0 +1,317 @@
+package inspecthtml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRender(t *testing.T) {
+	input := "<!DOCTYPE html><P Class=a>x &amp; y\r\nz</p><!--c--><br><pre>\n\nt</pre><script>a<b</script><table><td>c</table>"
+
+	document, documentMetadata, err := NewParser(strings.NewReader(input), ParserConfig{}.SetRetainSource(true)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// modified after parsing
+	document.LastChild.LastChild.AppendChild(&html.Node{
+		Type: html.ElementNode,
+		Data: "footer",
+	})
+
+	rendered := &bytes.Buffer{}
+
+	mappings, err := Render(rendered, document, documentMetadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedRendered := &bytes.Buffer{}
+	html.Render(expectedRendered, document)
+
+	if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+		t.Fatalf("rendered: expected %q, got %q", _e, _a)
+	}
+
+	var actual []string
+
+	for _, mapping := range mappings {
+		source, _ := documentMetadata.GetRawSource(mapping.SourceOffsets)
+
+		actual = append(actual, fmt.Sprintf(
+			"%s %q %s %q",
+			describeTreeNode(mapping.Node),
+			rendered.Bytes()[mapping.Offsets.From.Byte:mapping.Offsets.Until.Byte],
+			mapping.Offsets.OffsetRangeString(),
+			source,
+		))
+	}
+
+	if _a, _e := strings.Join(actual, "\n"), strings.Join([]string{
+		`#node "<!DOCTYPE html>" L1C1:L1C16;0x0:0xf "<!DOCTYPE html>"`,
+		`p "<p class=\"a\">" L1C41:L1C54;0x28:0x35 "<P Class=a>"`,
+		`#text "x &amp; y\n" L1C54:L2C1;0x35:0x3f "x &amp; y\r\n"`,
+		`#text "z" L2C1:L2C2;0x3f:0x40 "z"`,
+		`p "</p>" L2C2:L2C6;0x40:0x44 "</p>"`,
+		`#comment "<!--c-->" L2C6:L2C14;0x44:0x4c "<!--c-->"`,
+		`br "<br/>" L2C14:L2C19;0x4c:0x51 "<br>"`,
+		`pre "<pre>\n" L2C19:L3C1;0x51:0x57 "<pre>"`,
+		`#text "\n" L3C1:L4C1;0x57:0x58 "\n"`,
+		`#text "t" L4C1:L4C2;0x58:0x59 "t"`,
+		`pre "</pre>" L4C2:L4C8;0x59:0x5f "</pre>"`,
+		`script "<script>" L4C8:L4C16;0x5f:0x67 "<script>"`,
+		`#text "a<b" L4C16:L4C19;0x67:0x6a "a<b"`,
+		`script "</script>" L4C19:L4C28;0x6a:0x73 "</script>"`,
+		`table "<table>" L4C28:L4C35;0x73:0x7a "<table>"`,
+		`td "<td>" L4C46:L4C50;0x85:0x89 "<td>"`,
+		`#text "c" L4C50:L4C51;0x89:0x8a "c"`,
+		`table "</table>" L4C69:L4C77;0x9c:0xa4 "</table>"`,
+	}, "\n"); _a != _e {
+		t.Errorf("mappings: expected\n%s\ngot\n%s", _e, _a)
+	}
+}
+
+func TestRenderEquivalence(t *testing.T) {
+	for _, input := range []string{
+		string(benchmarkInput()),
+		"",
+		`<p a="x"b='y' c=d"e f/ g/=h =i>`,
+		"a\x00b<p\x00=\"\x00\">&#0;&amp&#xD800;\r\n",
+		`<svg><![CDATA[a]]><title><b>t</b></title></svg><math><mi><b>x</b></mi></math>`,
+		`<table>a<tr>b<td>c</table><b><i></b>c</i>`,
+		"<textarea>\r\ny</textarea><style></p></style><noscript><p></noscript><xmp>&amp;</xmp>",
+		`<!--a-->b--><!--->--><!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" 'x"y'>`,
+		`<p>a<plaintext>b</p><i>`,
+	} {
+		document, documentMetadata, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		rendered := &bytes.Buffer{}
+
+		mappings, err := Render(rendered, document, documentMetadata)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expectedRendered := &bytes.Buffer{}
+		html.Render(expectedRendered, document)
+
+		if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+			t.Errorf("rendered: expected %q, got %q", _e, _a)
+		}
+
+		var until int64
+
+		for _, mapping := range mappings {
+			if mapping.Offsets.From.Byte < until || mapping.Offsets.Until.Byte <= mapping.Offsets.From.Byte {
+				t.Fatalf("%q: mapping out of order: %s", input, mapping.Offsets.OffsetRangeString())
+			}
+
+			until = mapping.Offsets.Until.Byte
+		}
+	}
+}
+
+func TestRenderWithoutMetadata(t *testing.T) {
+	document, err := html.Parse(strings.NewReader("<div>\n  <p title=x>a\nb</p><!--c--></div>"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rendered := &bytes.Buffer{}
+
+	mappings, err := Render(rendered, document, nil, RenderConfig{}.SetSourcePosAttr("data-src"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedRendered := &bytes.Buffer{}
+	html.Render(expectedRendered, document)
+
+	if _a, _e := rendered.String(), expectedRendered.String(); _a != _e {
+		t.Errorf("rendered: expected %q, got %q", _e, _a)
+	} else if _a, _e := len(mappings), 0; _a != _e {
+		t.Errorf("mappings: expected %v, got %v", _e, _a)
+	}
+
+	sm := NewSourceMap(rendered.Bytes(), mappings, nil, "input.html")
+
+	if _a, _e := sm.Mappings, ""; _a != _e {
+		t.Errorf("source map: expected %q, got %q", _e, _a)
+	} else if _a := sm.SourcesContent; _a != nil {
+		t.Errorf("sources content: expected nil, got %v", _a)
+	}
+}
+
+func TestNewSourceMap(t *testing.T) {
+	input := "<div>\n  <p title=\"😀\">a\nb</p></div>"
+
+	document, documentMetadata, err := NewParser(strings.NewReader(input), ParserConfig{}.SetRetainSource(true)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rendered := &bytes.Buffer{}
+
+	mappings, err := Render(rendered, document, documentMetadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sourceMap := NewSourceMap(rendered.Bytes(), mappings, documentMetadata, "input.html")
+
+	buf, err := json.Marshal(sourceMap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded SourceMap
+
+	err = json.Unmarshal(buf, &decoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _a, _e := decoded.Version, 3; _a != _e {
+		t.Errorf("version: expected %v, got %v", _e, _a)
+	} else if _a, _e := strings.Join(decoded.Sources, ","), "input.html"; _a != _e {
+		t.Errorf("sources: expected %v, got %v", _e, _a)
+	} else if _a, _e := *decoded.SourcesContent[0], input; _a != _e {
+		t.Errorf("sources content: expected %v, got %v", _e, _a)
+	}
+
+	// the segments of each line, as output column and then source line and column (zero-based)
+	var actual []string
+
+	for _, line := range strings.Split(decoded.Mappings, ";") {
+		var segments []string
+		var column int64
+
+		for _, segment := range strings.Split(line, ",") {
+			if segment == "" {
+				continue
+			}
+
+			var fields []int64
+
+			for len(segment) > 0 {
+				var v int64
+				var shift uint
+
+				for {
+					digit := int64(strings.IndexByte(sourceMapBase64, segment[0]))
+					segment = segment[1:]
+					v |= (digit & 0x1f) << shift
+					shift += 5
+
+					if digit&0x20 == 0 {
+						break
+					}
+				}
+
+				if v&1 == 1 {
+					v = -(v >> 1)
+				} else {
+					v >>= 1
+				}
+
+				fields = append(fields, v)
+			}
+
+			column += fields[0]
+
+			if len(fields) == 1 {
+				segments = append(segments, fmt.Sprintf("%d", column))
+			} else {
+				segments = append(segments, fmt.Sprintf("%d=%+d:%+d", column, fields[2], fields[3]))
+			}
+		}
+
+		actual = append(actual, strings.Join(segments, " "))
+	}
+
+	if _a, _e := strings.Join(actual, "\n"), strings.Join([]string{
+		`25=+0:+0 30=+0:+5`,
+		`0=+1:-5 2=+0:+2 16=+0:+14`,
+		`0=+1:-16 1=+0:+1 5=+0:+4 11`,
+	}, "\n"); _a != _e {
+		t.Errorf("mappings: expected\n%s\ngot\n%s", _e, _a)
+	}
+}
+
+func TestRenderSourcePos(t *testing.T) {
+	input := "<div data-sourcepos=x>\n  <p class=a>text\n</div>"
+
+	document, documentMetadata, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		opts     []RenderOption
+		expected string
+	}{
+		{
+			name:     "default",
+			expected: "<html><head></head><body><div data-sourcepos=\"x\">\n  <p class=\"a\">text\n</p></div></body></html>",
+		},
+		{
+			name:     "attr",
+			opts:     []RenderOption{RenderConfig{}.SetSourcePosAttr("data-sourcepos")},
+			expected: "<html><head></head><body><div data-sourcepos=\"1:1-3:6\">\n  <p class=\"a\" data-sourcepos=\"2:3-3:0\">text\n</p></div></body></html>",
+		},
+		{
+			name: "bytes and file",
+			opts: []RenderOption{
+				RenderConfig{}.SetSourcePosAttr("data-src"),
+				RenderConfig{}.SetSourcePosBytes(true).SetSourcePosFile("views/a.html"),
+			},
+			expected: "<html><head></head><body><div data-sourcepos=\"x\" data-src=\"views/a.html:1:1-3:6;0-47\">\n  <p class=\"a\" data-src=\"views/a.html:2:3-3:0;25-41\">text\n</p></div></body></html>",
+		},
+		{
+			name: "bytes disabled",
+			opts: []RenderOption{
+				RenderConfig{}.SetSourcePosAttr("data-src").SetSourcePosBytes(true),
+				RenderConfig{}.SetSourcePosBytes(false),
+			},
+			expected: "<html><head></head><body><div data-sourcepos=\"x\" data-src=\"1:1-3:6\">\n  <p class=\"a\" data-src=\"2:3-3:0\">text\n</p></div></body></html>",
+		},
+		{
+			name: "file disabled",
+			opts: []RenderOption{
+				RenderConfig{}.SetSourcePosAttr("data-src").SetSourcePosFile("views/a.html"),
+				RenderConfig{}.SetSourcePosFile(""),
+			},
+			expected: "<html><head></head><body><div data-sourcepos=\"x\" data-src=\"1:1-3:6\">\n  <p class=\"a\" data-src=\"2:3-3:0\">text\n</p></div></body></html>",
+		},
+		{
+			name: "attr disabled",
+			opts: []RenderOption{
+				RenderConfig{}.SetSourcePosAttr("data-src").SetSourcePosBytes(true),
+				RenderConfig{}.SetSourcePosAttr(""),
+			},
+			expected: "<html><head></head><body><div data-sourcepos=\"x\">\n  <p class=\"a\">text\n</p></div></body></html>",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rendered := &bytes.Buffer{}
+
+			_, err := Render(rendered, document, documentMetadata, tc.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _a, _e := rendered.String(), tc.expected; _a != _e {
+				t.Errorf("rendered: expected %q, got %q", _e, _a)
+			}
+		})
+	}
+}
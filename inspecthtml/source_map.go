@@ -0,0 +1,140 @@
+package inspecthtml
+
+import (
+	"strings"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+)
+
+// SourceMap is a Source Map (revision 3) document, which may be marshaled as JSON.
+//
+// https://tc39.es/ecma426/
+type SourceMap struct {
+	Version        int       `json:"version"`
+	File           string    `json:"file,omitempty"`
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent,omitempty"`
+	Names          []string  `json:"names"`
+	Mappings       string    `json:"mappings"`
+}
+
+// NewSourceMap returns a source map of the output of Render, which must be the same output the mappings refer to.
+// Source is the name of the source (e.g. its URL). Columns are counted in UTF-16 code units, as expected by browsers,
+// which requires ParserConfig.SetRetainSource for the source columns; otherwise, they are used as-is. The retained
+// source is also included as the content of the source. The metadata may be nil.
+func NewSourceMap(output []byte, mappings []RenderMapping, metadata *ParseMetadata, source string) *SourceMap {
+	sm := &SourceMap{
+		Version: 3,
+		Sources: []string{source},
+		Names:   []string{},
+	}
+
+	if metadata != nil && metadata.GetSource() != nil {
+		content := string(metadata.GetSource())
+		sm.SourcesContent = []*string{&content}
+	}
+
+	enc := &sourceMapEncoder{
+		output: newColumnIndex(output, cursorio.TextOffset{}, ColumnUnitUTF16),
+	}
+
+	for i, mapping := range mappings {
+		sourceFrom := mapping.SourceOffsets.From
+		if metadata != nil {
+			if v, ok := metadata.ConvertOffset(sourceFrom, ColumnUnitUTF16); ok {
+				sourceFrom = v
+			}
+		}
+
+		enc.segment(mapping.Offsets.From.Byte, &sourceFrom)
+
+		if i+1 == len(mappings) || mappings[i+1].Offsets.From.Byte != mapping.Offsets.Until.Byte {
+			// the following output is not mapped
+			enc.segment(mapping.Offsets.Until.Byte, nil)
+		}
+	}
+
+	sm.Mappings = enc.sb.String()
+
+	return sm
+}
+
+// sourceMapEncoder encodes segments of the mappings field, which must be added in order of output.
+type sourceMapEncoder struct {
+	output *columnIndex
+	sb     strings.Builder
+
+	// line is the line of output which is being encoded.
+	line int64
+
+	// lineSegments is the number of segments of the line.
+	lineSegments int
+
+	// previous are the fields of the previous segment, which are encoded relative to each other.
+	previousColumn       int64
+	previousSourceLine   int64
+	previousSourceColumn int64
+}
+
+// segment adds a segment at the byte of output for the source offset, or unmapped if nil.
+func (e *sourceMapEncoder) segment(b int64, source *cursorio.TextOffset) {
+	o, ok := e.output.offset(b)
+	if !ok {
+		return
+	}
+
+	for ; e.line < o.LineColumn[0]; e.line++ {
+		e.sb.WriteByte(';')
+		e.lineSegments = 0
+		e.previousColumn = 0
+	}
+
+	if e.lineSegments > 0 {
+		e.sb.WriteByte(',')
+	}
+
+	e.lineSegments++
+
+	writeSourceMapVLQ(&e.sb, o.LineColumn[1]-e.previousColumn)
+	e.previousColumn = o.LineColumn[1]
+
+	if source == nil {
+		return
+	}
+
+	// the index of the only source
+	writeSourceMapVLQ(&e.sb, 0)
+
+	writeSourceMapVLQ(&e.sb, source.LineColumn[0]-e.previousSourceLine)
+	e.previousSourceLine = source.LineColumn[0]
+
+	writeSourceMapVLQ(&e.sb, source.LineColumn[1]-e.previousSourceColumn)
+	e.previousSourceColumn = source.LineColumn[1]
+}
+
+const sourceMapBase64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// writeSourceMapVLQ writes the Base64 VLQ of v, where the least significant bit of the first digit is the sign.
+func writeSourceMapVLQ(sb *strings.Builder, v int64) {
+	if v < 0 {
+		v = (-v << 1) | 1
+	} else {
+		v <<= 1
+	}
+
+	for {
+		digit := v & 0x1f
+		v >>= 5
+
+		if v > 0 {
+			// continuation
+			digit |= 0x20
+		}
+
+		sb.WriteByte(sourceMapBase64[digit])
+
+		if v == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package inspecthtml
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+// SyntaxTree is a lossless concrete syntax tree of the source. Its nodes are the tokens read by the tokenizer, in source
+// order, and each of them is made of the syntax tokens which cover its source. Every byte of source belongs to exactly
+// one syntax token, so the source can be reproduced with WriteTo or Bytes.
+type SyntaxTree struct {
+	Nodes []*SyntaxNode
+}
+
+// WriteTo writes the source of every syntax token.
+func (t *SyntaxTree) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+
+	for _, node := range t.Nodes {
+		for _, token := range node.Tokens {
+			n, err := io.WriteString(w, token.Data)
+			written += int64(n)
+
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Bytes returns the source of every syntax token, which is the same as the original source.
+func (t *SyntaxTree) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	t.WriteTo(buf)
+
+	return buf.Bytes()
+}
+
+// SyntaxNode is a token read by the tokenizer.
+type SyntaxNode struct {
+	// Type is the type of token. An incomplete tag at the end of input, which upstream drops, uses html.ErrorToken.
+	Type    html.TokenType
+	Offsets cursorio.TextOffsetRange
+	Tokens  []SyntaxToken
+
+	// Node is the node the token resulted in, or nil if there was none (e.g. ignored tags or dropped whitespace). End
+	// tags refer to the element they closed, and merged html and body start tags refer to the element they were merged
+	// into.
+	Node *html.Node
+}
+
+// SyntaxToken is a part of the source of a SyntaxNode.
+type SyntaxToken struct {
+	Kind    SyntaxTokenKind
+	Offsets cursorio.TextOffsetRange
+
+	// Data is the source of the token, without any decoding (e.g. its original casing and character references).
+	Data string
+
+	// Node is the same as the Node of its SyntaxNode.
+	Node *html.Node
+
+	// AttrIndex is the index of the attribute within Node.Attr for the tokens of an attribute. It is -1 for other tokens,
+	// or when the attribute is not in the node (e.g. attributes of end tags).
+	AttrIndex int
+}
+
+// SyntaxTokenKind describes the part of the source a SyntaxToken is.
+type SyntaxTokenKind int
+
+const (
+	// SyntaxTokenWhitespace is whitespace within a tag or doctype, such as between attributes.
+	SyntaxTokenWhitespace SyntaxTokenKind = iota + 1
+
+	// SyntaxTokenIgnored is source which the tokenizer ignored, such as a stray solidus within a tag or an incomplete
+	// tag at the end of input.
+	SyntaxTokenIgnored
+
+	// SyntaxTokenText is the source of text, which may include character references.
+	SyntaxTokenText
+
+	// SyntaxTokenCDATAOpen and SyntaxTokenCDATAClose are the markers of a CDATA section (i.e. `<![CDATA[` and `]]>`).
+	SyntaxTokenCDATAOpen
+	SyntaxTokenCDATAClose
+
+	// SyntaxTokenTagOpen is the start of a tag (i.e. `<` or `</`).
+	SyntaxTokenTagOpen
+
+	// SyntaxTokenTagName is the name of a tag, in its original casing.
+	SyntaxTokenTagName
+
+	// SyntaxTokenTagSelfClosing is the solidus of a self-closing tag.
+	SyntaxTokenTagSelfClosing
+
+	// SyntaxTokenTagClose is the end of a tag or doctype (i.e. `>`).
+	SyntaxTokenTagClose
+
+	// SyntaxTokenAttrName is the name of an attribute, in its original casing.
+	SyntaxTokenAttrName
+
+	// SyntaxTokenAttrEquals is the equals sign between the name and value of an attribute.
+	SyntaxTokenAttrEquals
+
+	// SyntaxTokenAttrQuote is a quote of an attribute value.
+	SyntaxTokenAttrQuote
+
+	// SyntaxTokenAttrValue is the value of an attribute, excluding any quotes.
+	SyntaxTokenAttrValue
+
+	// SyntaxTokenCommentOpen is the start of a comment (e.g. `<!--`, or `<!` for a bogus comment).
+	SyntaxTokenCommentOpen
+
+	// SyntaxTokenCommentData is the data of a comment.
+	SyntaxTokenCommentData
+
+	// SyntaxTokenCommentClose is the end of a comment (e.g. `-->`, or `>` for a bogus comment).
+	SyntaxTokenCommentClose
+
+	// SyntaxTokenDoctypeOpen is the start of a doctype (i.e. `<!DOCTYPE`).
+	SyntaxTokenDoctypeOpen
+
+	// SyntaxTokenDoctypeName is the name of a doctype.
+	SyntaxTokenDoctypeName
+
+	// SyntaxTokenDoctypeKeyword is the keyword before an identifier of a doctype (i.e. `PUBLIC` or `SYSTEM`).
+	SyntaxTokenDoctypeKeyword
+
+	// SyntaxTokenDoctypeIdentifier is the public or system identifier of a doctype, including its quotes.
+	SyntaxTokenDoctypeIdentifier
+)
+
+func (k SyntaxTokenKind) String() string {
+	switch k {
+	case SyntaxTokenWhitespace:
+		return "whitespace"
+	case SyntaxTokenIgnored:
+		return "ignored"
+	case SyntaxTokenText:
+		return "text"
+	case SyntaxTokenCDATAOpen:
+		return "cdata-open"
+	case SyntaxTokenCDATAClose:
+		return "cdata-close"
+	case SyntaxTokenTagOpen:
+		return "tag-open"
+	case SyntaxTokenTagName:
+		return "tag-name"
+	case SyntaxTokenTagSelfClosing:
+		return "tag-self-closing"
+	case SyntaxTokenTagClose:
+		return "tag-close"
+	case SyntaxTokenAttrName:
+		return "attr-name"
+	case SyntaxTokenAttrEquals:
+		return "attr-equals"
+	case SyntaxTokenAttrQuote:
+		return "attr-quote"
+	case SyntaxTokenAttrValue:
+		return "attr-value"
+	case SyntaxTokenCommentOpen:
+		return "comment-open"
+	case SyntaxTokenCommentData:
+		return "comment-data"
+	case SyntaxTokenCommentClose:
+		return "comment-close"
+	case SyntaxTokenDoctypeOpen:
+		return "doctype-open"
+	case SyntaxTokenDoctypeName:
+		return "doctype-name"
+	case SyntaxTokenDoctypeKeyword:
+		return "doctype-keyword"
+	case SyntaxTokenDoctypeIdentifier:
+		return "doctype-identifier"
+	}
+
+	return "unknown"
+}
+
+//
+
+// syntaxScanner splits the source of a token into syntax tokens, in order.
+type syntaxScanner struct {
+	raw  []byte
+	w    *cursorio.TextWriter
+	node *SyntaxNode
+	i    int
+}
+
+// emit appends the source until the index as a token. Empty tokens are skipped.
+func (s *syntaxScanner) emit(kind SyntaxTokenKind, until int, attrIndex int) {
+	if until <= s.i {
+		return
+	}
+
+	s.node.Tokens = append(s.node.Tokens, SyntaxToken{
+		Kind:      kind,
+		Offsets:   s.w.WriteForOffsetRange(s.raw[s.i:until]),
+		Data:      string(s.raw[s.i:until]),
+		AttrIndex: attrIndex,
+	})
+
+	s.i = until
+}
+
+// emitTrivia appends the source until the index as whitespace and ignored tokens. If keyword is set, it may classify
+// the source between whitespace as another kind.
+func (s *syntaxScanner) emitTrivia(until int, keyword func(v []byte) SyntaxTokenKind) {
+	for s.i < until {
+		j := s.i
+
+		if isTagSpace(s.raw[j]) {
+			for j < until && isTagSpace(s.raw[j]) {
+				j++
+			}
+
+			s.emit(SyntaxTokenWhitespace, j, -1)
+
+			continue
+		}
+
+		for j < until && !isTagSpace(s.raw[j]) {
+			j++
+		}
+
+		kind := SyntaxTokenIgnored
+		if keyword != nil {
+			if v := keyword(s.raw[s.i:j]); v != 0 {
+				kind = v
+			}
+		}
+
+		s.emit(kind, j, -1)
+	}
+}
+
+// emitTag appends the tokens of a start or end tag. End tags are scanned with the same rules, after their solidus.
+func (s *syntaxScanner) emitTag(tt html.TokenType) {
+	offset := 0
+	if tt == html.EndTagToken {
+		offset = 1
+	}
+
+	nameRange, attrRanges := scanStartTag(s.raw[offset:])
+
+	s.emit(SyntaxTokenTagOpen, nameRange[0]+offset, -1)
+	s.emit(SyntaxTokenTagName, nameRange[1]+offset, -1)
+
+	for attrIdx, attrRange := range attrRanges {
+		keyFrom, keyUntil := attrRange.key[0]+offset, attrRange.key[1]+offset
+
+		s.emitTrivia(keyFrom, nil)
+		s.emit(SyntaxTokenAttrName, keyUntil, attrIdx)
+
+		// any equals sign is after the name, but there may not be a value (e.g. `a=>`)
+		eq := bytes.IndexByte(s.raw[keyUntil:], '=')
+
+		valueFrom := attrRange.value[0] + offset
+		if attrRange.value[0] < 0 {
+			valueFrom = len(s.raw) - 1
+			if attrIdx+1 < len(attrRanges) {
+				valueFrom = attrRanges[attrIdx+1].key[0] + offset
+			}
+		}
+
+		if eq > -1 && keyUntil+eq < valueFrom && len(bytes.TrimLeft(s.raw[keyUntil:keyUntil+eq], " \n\r\t\f")) == 0 {
+			s.emitTrivia(keyUntil+eq, nil)
+			s.emit(SyntaxTokenAttrEquals, keyUntil+eq+1, attrIdx)
+		}
+
+		if attrRange.value[0] < 0 {
+			continue
+		}
+
+		valueUntil := attrRange.value[1] + offset
+
+		s.emitTrivia(valueFrom, nil)
+
+		if quote := s.raw[valueFrom]; quote == '"' || quote == '\'' {
+			s.emit(SyntaxTokenAttrQuote, valueFrom+1, attrIdx)
+
+			if valueUntil-valueFrom > 1 && s.raw[valueUntil-1] == quote {
+				s.emit(SyntaxTokenAttrValue, valueUntil-1, attrIdx)
+				s.emit(SyntaxTokenAttrQuote, valueUntil, attrIdx)
+			} else {
+				s.emit(SyntaxTokenAttrValue, valueUntil, attrIdx)
+			}
+		} else {
+			s.emit(SyntaxTokenAttrValue, valueUntil, attrIdx)
+		}
+	}
+
+	closeFrom := len(s.raw) - 1
+	if tt == html.SelfClosingTagToken {
+		closeFrom--
+	}
+
+	s.emitTrivia(closeFrom, nil)
+
+	if tt == html.SelfClosingTagToken {
+		s.emit(SyntaxTokenTagSelfClosing, closeFrom+1, -1)
+	}
+
+	s.emit(SyntaxTokenTagClose, len(s.raw), -1)
+}
+
+func (s *syntaxScanner) emitComment() {
+	closeFrom := len(s.raw)
+
+	if bytes.HasPrefix(s.raw, []byte("<!--")) {
+		s.emit(SyntaxTokenCommentOpen, 4, -1)
+
+		// same as the reader; unless closed, the comment ended with the input
+		switch rest := s.raw[4:]; {
+		case bytes.HasSuffix(rest, []byte("--!>")):
+			closeFrom -= 4
+		case bytes.HasSuffix(rest, []byte("-->")):
+			closeFrom -= 3
+		case string(rest) == ">" || string(rest) == "->":
+			closeFrom = 4
+		}
+	} else {
+		// bogus comment (e.g. `<!tag>`, `</3>`, or `<?php ... ?>`); the question mark is part of its data
+		openUntil := 2
+		if len(s.raw) > 1 && s.raw[1] == '?' {
+			openUntil = 1
+		}
+
+		s.emit(SyntaxTokenCommentOpen, min(len(s.raw), openUntil), -1)
+
+		if bytes.HasSuffix(s.raw, []byte(">")) {
+			closeFrom--
+		}
+	}
+
+	s.emit(SyntaxTokenCommentData, closeFrom, -1)
+	s.emit(SyntaxTokenCommentClose, len(s.raw), -1)
+}
+
+func (s *syntaxScanner) emitDoctype() {
+	nameRange, publicRange, systemRange := scanDoctype(s.raw)
+
+	keyword := func(v []byte) SyntaxTokenKind {
+		if bytes.EqualFold(v, []byte("public")) || bytes.EqualFold(v, []byte("system")) {
+			return SyntaxTokenDoctypeKeyword
+		}
+
+		return 0
+	}
+
+	s.emit(SyntaxTokenDoctypeOpen, min(len(s.raw), len("<!doctype")), -1)
+
+	for _, v := range []struct {
+		kind  SyntaxTokenKind
+		value [2]int
+	}{
+		{SyntaxTokenDoctypeName, nameRange},
+		{SyntaxTokenDoctypeIdentifier, publicRange},
+		{SyntaxTokenDoctypeIdentifier, systemRange},
+	} {
+		if v.value[0] < 0 {
+			continue
+		}
+
+		s.emitTrivia(v.value[0], keyword)
+		s.emit(v.kind, v.value[1], -1)
+	}
+
+	closeFrom := len(s.raw)
+	if bytes.HasSuffix(s.raw[s.i:], []byte(">")) {
+		closeFrom--
+	}
+
+	s.emitTrivia(closeFrom, keyword)
+	s.emit(SyntaxTokenTagClose, len(s.raw), -1)
+}
+
+func (s *syntaxScanner) emitText() {
+	if !bytes.HasPrefix(s.raw, []byte("<![CDATA[")) {
+		s.emit(SyntaxTokenText, len(s.raw), -1)
+
+		return
+	}
+
+	// only tokenized as text within foreign content
+	closeFrom := len(s.raw)
+	if bytes.HasSuffix(s.raw[9:], []byte("]]>")) {
+		closeFrom -= 3
+	}
+
+	s.emit(SyntaxTokenCDATAOpen, 9, -1)
+	s.emit(SyntaxTokenText, closeFrom, -1)
+	s.emit(SyntaxTokenCDATAClose, len(s.raw), -1)
+}
+
+// newSyntaxNode splits the source of a token into syntax tokens. The attribute index of tokens refers to the attributes
+// of the token, in order, until they are linked to a node.
+func newSyntaxNode(tt html.TokenType, raw []byte, from cursorio.TextOffset) *SyntaxNode {
+	node := &SyntaxNode{
+		Type: tt,
+	}
+
+	s := &syntaxScanner{
+		raw:  raw,
+		w:    cursorio.NewTextWriter(from),
+		node: node,
+	}
+
+	switch tt {
+	case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+		s.emitTag(tt)
+	case html.CommentToken:
+		s.emitComment()
+	case html.DoctypeToken:
+		s.emitDoctype()
+	case html.TextToken:
+		s.emitText()
+	default:
+		s.emit(SyntaxTokenIgnored, len(raw), -1)
+	}
+
+	// should not happen since the scanners mirror upstream; keep the remaining source
+	s.emit(SyntaxTokenIgnored, len(raw), -1)
+
+	node.Offsets = cursorio.TextOffsetRange{
+		From:  from,
+		Until: s.w.GetTextOffset(),
+	}
+
+	return node
+}
@@ -0,0 +1,195 @@
+package inspecthtml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func TestSyntaxTree(t *testing.T) {
+	input := "<!DOCTYPE html>\n<HTML lang=en><Body CLASS = \"a\" x='1' / ><br/><p a=>x</p b=c><!--c--><?php ?><html id=m><div class=x"
+
+	_, documentMetadata, err := NewParser(strings.NewReader(input), ParserConfig{}.SetSyntaxTree(true)).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var _a []string
+
+	for _, node := range documentMetadata.GetSyntaxTree().Nodes {
+		nodeName := "<nil>"
+		if node.Node != nil {
+			nodeName = describeTreeNode(node.Node)
+		}
+
+		for _, token := range node.Tokens {
+			_a = append(_a, fmt.Sprintf("%s %s %s %q %d", node.Type, nodeName, token.Kind, token.Data, token.AttrIndex))
+		}
+	}
+
+	if _a, _e := strings.Join(_a, "\n"), strings.Join([]string{
+		`Doctype #node doctype-open "<!DOCTYPE" -1`,
+		`Doctype #node whitespace " " -1`,
+		`Doctype #node doctype-name "html" -1`,
+		`Doctype #node tag-close ">" -1`,
+		`Text <nil> text "\n" -1`,
+		`StartTag html tag-open "<" -1`,
+		`StartTag html tag-name "HTML" -1`,
+		`StartTag html whitespace " " -1`,
+		`StartTag html attr-name "lang" 0`,
+		`StartTag html attr-equals "=" 0`,
+		`StartTag html attr-value "en" 0`,
+		`StartTag html tag-close ">" -1`,
+		`StartTag body tag-open "<" -1`,
+		`StartTag body tag-name "Body" -1`,
+		`StartTag body whitespace " " -1`,
+		`StartTag body attr-name "CLASS" 0`,
+		`StartTag body whitespace " " -1`,
+		`StartTag body attr-equals "=" 0`,
+		`StartTag body whitespace " " -1`,
+		`StartTag body attr-quote "\"" 0`,
+		`StartTag body attr-value "a" 0`,
+		`StartTag body attr-quote "\"" 0`,
+		`StartTag body whitespace " " -1`,
+		`StartTag body attr-name "x" 1`,
+		`StartTag body attr-equals "=" 1`,
+		`StartTag body attr-quote "'" 1`,
+		`StartTag body attr-value "1" 1`,
+		`StartTag body attr-quote "'" 1`,
+		`StartTag body whitespace " " -1`,
+		`StartTag body ignored "/" -1`,
+		`StartTag body whitespace " " -1`,
+		`StartTag body tag-close ">" -1`,
+		`SelfClosingTag br tag-open "<" -1`,
+		`SelfClosingTag br tag-name "br" -1`,
+		`SelfClosingTag br tag-self-closing "/" -1`,
+		`SelfClosingTag br tag-close ">" -1`,
+		`StartTag p tag-open "<" -1`,
+		`StartTag p tag-name "p" -1`,
+		`StartTag p whitespace " " -1`,
+		`StartTag p attr-name "a" 0`,
+		`StartTag p attr-equals "=" 0`,
+		`StartTag p tag-close ">" -1`,
+		`Text #text text "x" -1`,
+		`EndTag p tag-open "</" -1`,
+		`EndTag p tag-name "p" -1`,
+		`EndTag p whitespace " " -1`,
+		`EndTag p attr-name "b" -1`,
+		`EndTag p attr-equals "=" -1`,
+		`EndTag p attr-value "c" -1`,
+		`EndTag p tag-close ">" -1`,
+		`Comment #comment comment-open "<!--" -1`,
+		`Comment #comment comment-data "c" -1`,
+		`Comment #comment comment-close "-->" -1`,
+		`Comment #comment comment-open "<" -1`,
+		`Comment #comment comment-data "?php ?" -1`,
+		`Comment #comment comment-close ">" -1`,
+		`StartTag html tag-open "<" -1`,
+		`StartTag html tag-name "html" -1`,
+		`StartTag html whitespace " " -1`,
+		`StartTag html attr-name "id" 1`,
+		`StartTag html attr-equals "=" 1`,
+		`StartTag html attr-value "m" 1`,
+		`StartTag html tag-close ">" -1`,
+		`Error <nil> ignored "<div class=x" -1`,
+	}, "\n"); _a != _e {
+		t.Errorf("tokens: expected\n%s\ngot\n%s", _e, _a)
+	}
+}
+
+func TestSyntaxTreeRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		// edge cases of tokens; documents of the test corpus are verified by examples/dev-compare
+		string(benchmarkInput()),
+		"",
+		"  \r\n<!doctype html system 'about:legacy-compat'>\r\n<title>a &amp b</title>",
+		`<p a="x"b='y' c=d"e f/ g/=h =i>`,
+		`<p a=b/><a href=/>t</a><img src="x" / >`,
+		`<!--><!---><!----><!--a--!><!--a`,
+		`<?php echo 1 ?><!x></3></ ></>`,
+		"a\x00b<p\x00=\"\x00\">&#0;&amp&#xD800;",
+		`<svg><![CDATA[a]]><title><b>t</b></title></svg><math><mi><b>x</b></mi></math>`,
+		`<table>a<tr>b<td>c</table><b><i></b>c</i>`,
+		`<html><head> <template>content</template> <meta/> </head></html><body x=1><body y=2></body>`,
+		"<pre>\n\nx</pre><textarea>\r\ny</textarea><script>a<b</script><style></p></style>",
+		`<div class=a`,
+		`<div`,
+		`<`,
+		`</`,
+		`<!DOCTYPE`,
+	} {
+		for _, opts := range [][]ParserOption{
+			{ParserConfig{}.SetSyntaxTree(true)},
+			{ParserConfig{}.SetSyntaxTree(true).SetTokenizerDiagnostics(true).SetTreeDiagnostics(true)},
+		} {
+			_, documentMetadata, err := NewParser(strings.NewReader(input), opts...).Parse()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			syntaxTree := documentMetadata.GetSyntaxTree()
+
+			if _a, _e := syntaxTree.Bytes(), []byte(input); !bytes.Equal(_a, _e) {
+				t.Errorf("bytes: expected %q, got %q", _e, _a)
+			}
+
+			var offset int64
+
+			for _, node := range syntaxTree.Nodes {
+				for _, token := range node.Tokens {
+					if _a, _e := token.Offsets.From.Byte, offset; _a != _e {
+						t.Fatalf("%q: offset: expected %v, got %v", input, _e, _a)
+					} else if _a, _e := int(token.Offsets.Until.Byte-token.Offsets.From.Byte), len(token.Data); _a != _e || _a == 0 {
+						t.Fatalf("%q: length: expected %v, got %v", input, _e, _a)
+					}
+
+					offset = token.Offsets.Until.Byte
+
+					if token.Node != node.Node {
+						t.Fatalf("%q: node: expected %v, got %v", input, node.Node, token.Node)
+					} else if token.Kind == SyntaxTokenAttrName && token.AttrIndex > -1 {
+						if _a, _e := token.Node.Attr[token.AttrIndex].Key, strings.ToLower(token.Data); _a != _e {
+							t.Errorf("%q: attr: expected %v, got %v", input, _e, _a)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSyntaxTreeDisabled(t *testing.T) {
+	_, documentMetadata, err := Parse(strings.NewReader(`<p>`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if documentMetadata.GetSyntaxTree() != nil {
+		t.Errorf("expected no syntax tree")
+	}
+}
+
+func TestSyntaxTreeFragment(t *testing.T) {
+	input := "a<b>&amp;c"
+
+	_, fragmentMetadata, err := NewParser(strings.NewReader(input), ParserConfig{}.SetSyntaxTree(true)).ParseFragment(&html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Textarea,
+		Data:     "textarea",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	syntaxTree := fragmentMetadata.GetSyntaxTree()
+
+	if _a, _e := string(syntaxTree.Bytes()), input; _a != _e {
+		t.Errorf("bytes: expected %q, got %q", _e, _a)
+	} else if _a, _e := len(syntaxTree.Nodes), 1; _a != _e {
+		t.Errorf("nodes: expected %v, got %v", _e, _a)
+	} else if _a, _e := syntaxTree.Nodes[0].Tokens[0].Kind, SyntaxTokenText; _a != _e {
+		t.Errorf("kind: expected %v, got %v", _e, _a)
+	}
+}
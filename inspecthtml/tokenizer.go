@@ -0,0 +1,126 @@
+package inspecthtml
+
+import (
+	"io"
+	"iter"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Tokenizer reads the tokens of a document along with their source offsets, without constructing a tree (i.e.
+// html.Parse is not used). Similar to the Parser, raw text and foreign content are approximated from the tokens.
+type Tokenizer struct {
+	r *parserReader
+}
+
+// NewTokenizer returns a Tokenizer for the reader. The initial offset, tokenizer interceptor, and tokenizer diagnostics
+// options are supported; other options are ignored.
+func NewTokenizer(r io.Reader, opts ...ParserOption) *Tokenizer {
+	t := &Tokenizer{
+		r: &parserReader{
+			tokenizer:     html.NewTokenizer(r),
+			scripting:     true,
+			collectTokens: true,
+		},
+	}
+
+	cfg := &ParserConfig{
+		initialOffset:        &cursorio.TextOffset{},
+		tokenizerDiagnostics: new(bool),
+	}
+
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+
+	t.r.doc = cursorio.NewTextWriter(*cfg.initialOffset)
+	t.r.tokenizerDiagnostics = *cfg.tokenizerDiagnostics
+
+	if cfg.tokenizerInterceptor != nil {
+		t.r.tokenizer = cfg.tokenizerInterceptor(t.r.tokenizer)
+	}
+
+	return t
+}
+
+// All returns an iterator over each token and its metadata, in source order. It stops at the end of input or the
+// first error, which is then available from Err.
+//
+// The metadata depends on the type of token.
+//
+//   - Start tags use TokenOffsets, TagNameOffsets, and TagAttr (in the same order as html.Token.Attr).
+//   - End tags use TokenOffsets and TagNameOffsets.
+//   - Doctypes use TokenOffsets and the doctype offsets.
+//   - Comments use TokenOffsets.
+//   - Text uses TokenOffsets and a single segment of TextSegments. Unlike the Parser, NUL characters are not dropped.
+func (t *Tokenizer) All() iter.Seq2[html.Token, *NodeMetadata] {
+	return func(yield func(html.Token, *NodeMetadata) bool) {
+		for {
+			for len(t.r.tokens) > 0 {
+				token := t.r.tokens[0]
+				t.r.tokens = t.r.tokens[1:]
+
+				if !yield(token.token, token.metadata) {
+					return
+				}
+			}
+
+			if t.r.err != nil {
+				return
+			}
+
+			t.r.err = t.r.next()
+
+			// nodes are only used for smuggling
+			t.r.nodes = t.r.nodes[:0]
+		}
+	}
+}
+
+// Err returns the first error which was encountered, other than io.EOF.
+func (t *Tokenizer) Err() error {
+	if t.r.err == io.EOF {
+		return nil
+	}
+
+	return t.r.err
+}
+
+// GetDiagnostics returns the issues encountered while tokenizing so far, in source order.
+func (t *Tokenizer) GetDiagnostics() []Diagnostic {
+	return t.r.diagnostics
+}
+
+func newTagToken(tt html.TokenType, name []byte, attrKeys, attrValues []string) html.Token {
+	token := html.Token{
+		Type:     tt,
+		DataAtom: atom.Lookup(name),
+		Data:     string(name),
+	}
+
+	for i, attrKey := range attrKeys {
+		token.Attr = append(token.Attr, html.Attribute{
+			Key: attrKey,
+			Val: attrValues[i],
+		})
+	}
+
+	return token
+}
+
+// newEndTagMetadata scans the source of an end tag with the same rules as a start tag, after its solidus.
+func newEndTagMetadata(raw []byte, offsets cursorio.TextOffsetRange) *NodeMetadata {
+	nameRange, _ := scanStartTag(raw[1:])
+
+	w := cursorio.NewTextWriter(offsets.From)
+	w.Write(raw[:nameRange[0]+1])
+
+	tagNameOffsets := w.WriteForOffsetRange(raw[nameRange[0]+1 : nameRange[1]+1])
+
+	return &NodeMetadata{
+		TokenOffsets:   offsets,
+		TagNameOffsets: &tagNameOffsets,
+	}
+}
@@ -0,0 +1,161 @@
+package inspecthtml
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dpb587/cursorio-go/cursorio"
+	"golang.org/x/net/html"
+)
+
+func describeTokenMetadata(token html.Token, metadata *NodeMetadata) string {
+	s := fmt.Sprintf("%s %q %s", token.Type, token.Data, metadata.TokenOffsets.OffsetRangeString())
+
+	if metadata.TagNameOffsets != nil {
+		s += fmt.Sprintf(" name=%s", metadata.TagNameOffsets.OffsetRangeString())
+	}
+
+	for attrIdx, attr := range metadata.TagAttr {
+		s += fmt.Sprintf(" %s=%s", token.Attr[attrIdx].Key, attr.KeyOffsets.OffsetRangeString())
+
+		if attr.ValueOffsets != nil {
+			s += fmt.Sprintf(":%s", attr.ValueOffsets.OffsetRangeString())
+		}
+	}
+
+	for _, segment := range metadata.TextSegments {
+		s += fmt.Sprintf(" data=%s", segment.Offsets.OffsetRangeString())
+	}
+
+	return s
+}
+
+func TestTokenizer(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+
+		// foreign is used when the upstream tokenizer, which is unaware of foreign content, is expected to differ
+		foreign bool
+
+		expected []string
+	}{
+		{
+			name:  "document",
+			input: "<!DOCTYPE html>\n<P Class=a id='b'>x &lt; y</p><!-- c -->",
+			expected: []string{
+				`Doctype "html" L1C1:L1C16;0x0:0xf`,
+				`Text "\n" L1C16:L2C1;0xf:0x10 data=L1C16:L2C1;0xf:0x10`,
+				`StartTag "p" L2C1:L2C19;0x10:0x22 name=L2C2:L2C3;0x11:0x12 class=L2C4:L2C9;0x13:0x18:L2C10:L2C11;0x19:0x1a id=L2C12:L2C14;0x1b:0x1d:L2C15:L2C18;0x1e:0x21`,
+				`Text "x < y" L2C19:L2C27;0x22:0x2a data=L2C19:L2C27;0x22:0x2a`,
+				`EndTag "p" L2C27:L2C31;0x2a:0x2e name=L2C29:L2C30;0x2c:0x2d`,
+				`Comment " c " L2C31:L2C41;0x2e:0x38`,
+			},
+		},
+		{
+			name:  "raw text",
+			input: `<script>a<b</script>`,
+			expected: []string{
+				`StartTag "script" L1C1:L1C9;0x0:0x8 name=L1C2:L1C8;0x1:0x7`,
+				`Text "a<b" L1C9:L1C12;0x8:0xb data=L1C9:L1C12;0x8:0xb`,
+				`EndTag "script" L1C12:L1C21;0xb:0x14 name=L1C14:L1C20;0xd:0x13`,
+			},
+		},
+		{
+			name:    "foreign content",
+			input:   `<svg><title><b/></title></svg>`,
+			foreign: true,
+			expected: []string{
+				`StartTag "svg" L1C1:L1C6;0x0:0x5 name=L1C2:L1C5;0x1:0x4`,
+				`StartTag "title" L1C6:L1C13;0x5:0xc name=L1C7:L1C12;0x6:0xb`,
+				`SelfClosingTag "b" L1C13:L1C17;0xc:0x10 name=L1C14:L1C15;0xd:0xe`,
+				`EndTag "title" L1C17:L1C25;0x10:0x18 name=L1C19:L1C24;0x12:0x17`,
+				`EndTag "svg" L1C25:L1C31;0x18:0x1e name=L1C27:L1C30;0x1a:0x1d`,
+			},
+		},
+		{
+			name:  "null characters",
+			input: "a\x00b",
+			expected: []string{
+				`Text "a\x00b" L1C1:L1C4;0x0:0x3 data=L1C1:L1C4;0x0:0x3`,
+			},
+		},
+		{
+			name:  "incomplete tag",
+			input: `a<p class`,
+			expected: []string{
+				`Text "a" L1C1:L1C2;0x0:0x1 data=L1C1:L1C2;0x0:0x1`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var _a, _e []html.Token
+
+			upstream := html.NewTokenizer(strings.NewReader(tc.input))
+			for upstream.Next() != html.ErrorToken {
+				_e = append(_e, upstream.Token())
+			}
+
+			tokenizer := NewTokenizer(strings.NewReader(tc.input))
+
+			var actual []string
+
+			for token, metadata := range tokenizer.All() {
+				_a = append(_a, token)
+				actual = append(actual, describeTokenMetadata(token, metadata))
+			}
+
+			if err := tokenizer.Err(); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if tc.foreign {
+				// title is raw text for upstream
+			} else if !reflect.DeepEqual(_a, _e) {
+				t.Errorf("tokens: expected %v, got %v", _e, _a)
+			}
+
+			if _a, _e := strings.Join(actual, "\n"), strings.Join(tc.expected, "\n"); _a != _e {
+				t.Errorf("metadata: expected\n%s\ngot\n%s", _e, _a)
+			}
+		})
+	}
+}
+
+func TestTokenizerOptions(t *testing.T) {
+	tokenizer := NewTokenizer(
+		strings.NewReader(`<p class=a class=b>text</p>`),
+		ParserConfig{}.SetInitialOffset(cursorio.TextOffset{Byte: 10, LineColumn: cursorio.TextLineColumn{2, 0}}).SetTokenizerDiagnostics(true),
+	)
+
+	var actual []string
+
+	for token, metadata := range tokenizer.All() {
+		actual = append(actual, describeTokenMetadata(token, metadata))
+
+		// stopping early should not lose any state
+		break
+	}
+
+	if _a, _e := strings.Join(actual, "\n"), `StartTag "p" L3C1:L3C20;0xa:0x1d name=L3C2:L3C3;0xb:0xc class=L3C4:L3C9;0xd:0x12:L3C10:L3C11;0x13:0x14 class=L3C12:L3C17;0x15:0x1a:L3C18:L3C19;0x1b:0x1c`; _a != _e {
+		t.Errorf("metadata: expected\n%s\ngot\n%s", _e, _a)
+	}
+
+	var remaining int
+
+	for range tokenizer.All() {
+		remaining++
+	}
+
+	if _a, _e := remaining, 2; _a != _e {
+		t.Errorf("remaining: expected %v, got %v", _e, _a)
+	}
+
+	if _a, _e := len(tokenizer.GetDiagnostics()), 1; _a != _e {
+		t.Fatalf("diagnostics: expected %v, got %v", _e, _a)
+	} else if _a, _e := tokenizer.GetDiagnostics()[0].Code, DiagnosticCodeDuplicateAttribute; _a != _e {
+		t.Errorf("diagnostic code: expected %v, got %v", _e, _a)
+	}
+}